@@ -0,0 +1,89 @@
+// Command thlint checks templatehandler page templates, so that broken pages fail CI rather than requests.
+//
+// It loads the base template and every page given on the command line, reports parse errors, invalid input blocks,
+// undefined templates and unsupplied input, and renders each page to catch errors that only show up when executed:
+//
+//	thlint -base templates/base.html -partials templates/partials -input testdata/input.json 'templates/*.html'
+//
+// Pages may be file names or glob patterns. The -input file is a JSON object used as the base's input; give it
+// representative values for whatever the site provides from Go or at request time. thlint exits with status 1 if it
+// finds any problems.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/mconbere/quitlikeapro/go/templatehandler"
+	"github.com/mconbere/quitlikeapro/go/templatehandler/i18n"
+	"github.com/mconbere/quitlikeapro/go/templatehandler/lint"
+)
+
+var (
+	base     = flag.String("base", "templates/base.html", "base template the pages are rendered in")
+	partials = flag.String("partials", "", "directory of partial templates to add to the base")
+	input    = flag.String("input", "", "JSON file with input for every page")
+	catalog  = flag.String("catalog", "", "directory of message catalogs to render every page in each locale of")
+	locale   = flag.String("default_locale", "en", "default locale of the -catalog")
+	strict   = flag.Bool("strict", true, "fail on missing input keys when rendering, as StrictMode does")
+)
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("thlint: ")
+	flag.Parse()
+	if flag.NArg() == 0 {
+		log.Fatal("no page templates given")
+	}
+
+	opts := []templatehandler.Option{templatehandler.WithStrict(*strict)}
+	if *input != "" {
+		b, err := os.ReadFile(*input)
+		if err != nil {
+			log.Fatal(err)
+		}
+		m := make(map[string]interface{})
+		if err := json.Unmarshal(b, &m); err != nil {
+			log.Fatalf("%s: %v", *input, err)
+		}
+		opts = append(opts, templatehandler.WithInput(m))
+	}
+	if *partials != "" {
+		opts = append(opts, templatehandler.WithPartialsDir(*partials))
+	}
+	if *catalog != "" {
+		c, err := i18n.LoadDir(*catalog, *locale)
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts = append(opts, templatehandler.WithCatalog(c))
+	}
+	b, err := templatehandler.NewBase(*base, opts...)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var pages []string
+	for _, pattern := range flag.Args() {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if matches == nil {
+			log.Fatalf("%s: no such page", pattern)
+		}
+		pages = append(pages, matches...)
+	}
+
+	problems := lint.Check(b, pages...)
+	for _, p := range problems {
+		fmt.Println(p)
+	}
+	if len(problems) > 0 {
+		os.Exit(1)
+	}
+}
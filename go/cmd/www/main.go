@@ -0,0 +1,310 @@
+// Command www runs the quitlikeapro site as a standalone HTTP server, outside of App Engine.
+//
+// It expects to be run from (or pointed at with -root) the directory containing the templates/ and static/
+// directories, normally go/www/appengine.
+package main
+
+import (
+	"context"
+	"expvar"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/mconbere/quitlikeapro/go/templatehandler"
+	"github.com/mconbere/quitlikeapro/go/templatehandler/gcs"
+	"github.com/mconbere/quitlikeapro/go/templatehandler/memcache"
+	"github.com/mconbere/quitlikeapro/go/www"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+var (
+	addr            = flag.String("addr", ":8080", "address to listen on")
+	root            = flag.String("root", ".", "directory containing templates/ and static/")
+	deadline        = flag.Duration("deadline", www.AppEngineDeadline, "how long a request may take before the timeout page is served")
+	liveReload      = flag.Bool("live_reload", false, "re-parse templates when they change on disk")
+	shutdownTimeout = flag.Duration("shutdown_timeout", 10*time.Second, "how long to wait for in-flight requests to finish on shutdown")
+
+	acmeDomains = flag.String("acme_domains", "", "comma separated list of domains to request certificates for; enables HTTPS when set")
+	acmeCache   = flag.String("acme_cache", "acme-cache", "directory in which to cache ACME certificates")
+	tlsAddr     = flag.String("tls_addr", ":443", "address to listen on for HTTPS when -acme_domains is set")
+
+	debugAddr   = flag.String("debug_addr", "", "address to serve render metrics on at /debug/vars; keep it private. Disabled if empty")
+	accessLog   = flag.Bool("access_log", false, "log each page request to stdout as a line of JSON")
+	reloadToken = flag.String("reload_token", os.Getenv("RELOAD_TOKEN"), "bearer token enabling POST /_admin/reload and the moderation of submissions under /_admin/submissions; defaults to $RELOAD_TOKEN. Disabled if empty")
+	submissions = flag.String("submissions", "", "file in which to keep programs suggested on /submit until they're moderated. Disabled if empty")
+	saved       = flag.String("saved", "", "file in which to count the visitors each program has saved, shown on its page and sorting the home page. Disabled if empty")
+	views       = flag.String("views", "", "file in which to count the views of each program's page, written every -views_interval. Disabled if empty")
+	viewsEvery  = flag.Duration("views_interval", 10*time.Second, "how often the view counts are written to -views")
+	export      = flag.String("export", "", "write a static copy of the site, including static/, to this directory and exit instead of serving")
+	env         = flag.String("env", "", "environment the site runs in: dev, staging or prod. Defaults to dev with -live_reload and prod otherwise")
+	taskAud     = flag.String("task_audience", "", "audience of the OIDC tokens, such as Cloud Scheduler's, that may run the jobs under /tasks/. Disabled if empty")
+	taskAccts   = flag.String("task_accounts", "", "comma separated service accounts whose OIDC tokens for -task_audience may run the jobs under /tasks/")
+	iapAud      = flag.String("iap_audience", "", "audience of the Identity-Aware Proxy assertions that let their users use the /_admin/ endpoints, as an alternative to -reload_token. Disabled if empty")
+	iapUsers    = flag.String("iap_users", "", "comma separated emails of the IAP users who may use the /_admin/ endpoints; defaults to all those IAP lets through")
+	fastly      = flag.String("fastly_service", "", "ID of the Fastly service in front of the site, which is told to keep pages for longer and purged with $FASTLY_API_TOKEN whenever the data changes. Disabled if empty")
+	memcached   = flag.String("memcache", "", "host:port of a memcached server, such as Memorystore, in which to share rendered pages and API responses with other instances. Disabled if empty")
+	content     = flag.String("content", os.Getenv("CONTENT_BUCKET"), "gs://bucket/prefix URL to read templates/, content/, data/ and site.yaml from instead of -root, refreshed by POST /_admin/reload; defaults to $CONTENT_BUCKET. Needs a Google Cloud service account from the metadata server")
+)
+
+// contentTimeout is how long the content bucket may take to download at startup.
+const contentTimeout = time.Minute
+
+func main() {
+	flag.Parse()
+
+	// The export directory is relative to where the command is run, not to -root.
+	if *export != "" {
+		dir, err := filepath.Abs(*export)
+		if err != nil {
+			log.Fatalf("could not resolve export directory: %v", err)
+		}
+		*export = dir
+	}
+	// So are the submissions and saved counts files.
+	var submissionStore www.SubmissionStore
+	if *submissions != "" {
+		path, err := filepath.Abs(*submissions)
+		if err != nil {
+			log.Fatalf("could not resolve submissions file: %v", err)
+		}
+		submissionStore = www.NewFileSubmissions(path)
+	}
+	var savedCounter www.SavedCounter
+	if *saved != "" {
+		path, err := filepath.Abs(*saved)
+		if err != nil {
+			log.Fatalf("could not resolve saved counts file: %v", err)
+		}
+		savedCounter = www.NewFileCounter(path)
+	}
+	// Views are counted on every page, so they're written in the background rather than while the page waits.
+	var viewCounter www.SavedCounter
+	var bufferedViews *www.BufferedCounter
+	if *views != "" {
+		path, err := filepath.Abs(*views)
+		if err != nil {
+			log.Fatalf("could not resolve views file: %v", err)
+		}
+		bufferedViews = www.NewBufferedCounter(www.NewFileCounter(path), *viewsEvery)
+		viewCounter = bufferedViews
+	}
+
+	if err := os.Chdir(*root); err != nil {
+		log.Fatalf("could not change to root directory: %v", err)
+	}
+
+	var metrics templatehandler.Metrics
+	if *debugAddr != "" {
+		metrics = templatehandler.ExpvarMetrics("templatehandler")
+		go func() {
+			debug := http.NewServeMux()
+			debug.Handle("/debug/vars", expvar.Handler())
+			log.Printf("serving metrics on %s", *debugAddr)
+			log.Fatal(http.ListenAndServe(*debugAddr, debug))
+		}()
+	}
+
+	var accessLogger templatehandler.AccessLog
+	if *accessLog {
+		// On Cloud Run, whose requests carry a Cloud Trace, each line is shown with the request it's about.
+		accessLogger = templatehandler.CloudAccessLog(os.Stdout, os.Getenv("GOOGLE_CLOUD_PROJECT"))
+	}
+
+	environment := templatehandler.Environment{Name: *env}
+	switch environment.Name {
+	case "":
+		environment.Name = templatehandler.EnvProd
+		if *liveReload {
+			environment.Name = templatehandler.EnvDev
+		}
+	case templatehandler.EnvDev, templatehandler.EnvStaging, templatehandler.EnvProd:
+	default:
+		log.Fatalf("unknown environment %q", *env)
+	}
+
+	var contentFS fs.FS
+	if *content != "" {
+		bucket, err := gcs.NewURL(*content)
+		if err != nil {
+			log.Fatal(err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), contentTimeout)
+		err = bucket.Refresh(ctx)
+		cancel()
+		if err != nil {
+			log.Fatalf("could not download content: %v", err)
+		}
+		contentFS = bucket
+	}
+
+	var cache templatehandler.Store
+	if *memcached != "" {
+		cache = memcache.New(*memcached)
+	}
+
+	var purger templatehandler.Purger
+	if *fastly != "" {
+		purger = &templatehandler.FastlyPurger{ServiceID: *fastly, Token: os.Getenv("FASTLY_API_TOKEN")}
+	}
+
+	// Without -iap_users, everyone IAP lets through is an admin, which an empty entry would turn into no one.
+	var admins []string
+	if *iapUsers != "" {
+		admins = strings.Split(*iapUsers, ",")
+	}
+
+	site := www.New(www.Config{
+		Deadline:     *deadline,
+		LiveReload:   *liveReload,
+		Metrics:      metrics,
+		AccessLog:    accessLogger,
+		ReloadToken:  *reloadToken,
+		Environment:  environment,
+		Submissions:  submissionStore,
+		Saved:        savedCounter,
+		Views:        viewCounter,
+		Content:      contentFS,
+		TaskAudience: *taskAud,
+		TaskAccounts: strings.Split(*taskAccts, ","),
+		IAPAudience:  *iapAud,
+		IAPUsers:     admins,
+		CDNPurger:    purger,
+		Cache:        cache,
+	})
+	if *export != "" {
+		pages, err := site.Pages()
+		if err != nil {
+			log.Fatalf("could not list the site's pages: %v", err)
+		}
+		if err := templatehandler.Export(site, *export, pages...); err != nil {
+			log.Fatalf("could not export site: %v", err)
+		}
+		if err := os.CopyFS(filepath.Join(*export, "static"), os.DirFS("static")); err != nil {
+			log.Fatalf("could not export static files: %v", err)
+		}
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/static/", templatehandler.Assets(os.DirFS("static"), "/static/"))
+	// Nothing in front of this server sets X-Appengine-User-Ip or X-Appengine-Cron, so a client sending them is making
+	// up its address to get around rate limits, or posing as App Engine's cron service.
+	mux.Handle("/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Header.Del("X-Appengine-User-Ip")
+		r.Header.Del("X-Appengine-Cron")
+		site.ServeHTTP(w, r)
+	}))
+
+	// Report ready only once every static page has rendered, so that a broken deploy fails its health checks.
+	var ready atomic.Bool
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if !ready.Load() {
+			http.Error(w, "not ready", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+	go func() {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest("GET", "/_ah/warmup", nil))
+		if rec.Code != http.StatusOK {
+			log.Printf("warmup failed, not reporting ready: %s", rec.Body)
+			return
+		}
+		ready.Store(true)
+	}()
+
+	var servers []*http.Server
+	var serve []func() error
+
+	if *acmeDomains == "" {
+		srv := &http.Server{
+			Addr:    *addr,
+			Handler: mux,
+		}
+		servers = append(servers, srv)
+		serve = append(serve, srv.ListenAndServe)
+	} else {
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(strings.Split(*acmeDomains, ",")...),
+			Cache:      autocert.DirCache(*acmeCache),
+		}
+
+		// Plain HTTP only answers ACME HTTP-01 challenges and redirects everything else to HTTPS.
+		srv := &http.Server{
+			Addr:    *addr,
+			Handler: m.HTTPHandler(nil),
+		}
+		tlsSrv := &http.Server{
+			Addr:      *tlsAddr,
+			Handler:   mux,
+			TLSConfig: m.TLSConfig(),
+		}
+		servers = append(servers, srv, tlsSrv)
+		serve = append(serve, srv.ListenAndServe, func() error { return tlsSrv.ListenAndServeTLS("", "") })
+	}
+
+	// SIGHUP re-reads the templates, data, site.yaml and redirects.yaml, as POST /_admin/reload does, without a restart.
+	go func() {
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		for range hup {
+			start := time.Now()
+			if err := site.Reload(); err != nil {
+				log.Printf("could not reload: %v", err)
+				continue
+			}
+			log.Printf("reloaded in %v", time.Since(start))
+		}
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGTERM, os.Interrupt)
+		s := <-sig
+		log.Printf("received %v, draining connections", s)
+
+		ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+		defer cancel()
+		for _, srv := range servers {
+			if err := srv.Shutdown(ctx); err != nil {
+				log.Printf("could not drain connections on %s: %v", srv.Addr, err)
+			}
+		}
+		if bufferedViews != nil {
+			if err := bufferedViews.Flush(ctx); err != nil {
+				log.Printf("could not write view counts: %v", err)
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i, srv := range servers {
+		wg.Add(1)
+		go func(srv *http.Server, serve func() error) {
+			defer wg.Done()
+			log.Printf("listening on %s", srv.Addr)
+			if err := serve(); err != http.ErrServerClosed {
+				log.Fatal(err)
+			}
+		}(srv, serve[i])
+	}
+	wg.Wait()
+	<-done
+}
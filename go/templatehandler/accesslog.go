@@ -0,0 +1,222 @@
+package templatehandler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AccessLog receives a record of each request a page's handlers serve. Implementations must be safe for concurrent
+// use.
+type AccessLog interface {
+	Served(e AccessEntry)
+}
+
+// AccessEntry describes a served request.
+type AccessEntry struct {
+	// Page is the TemplateHandler.Name of the page that served it.
+	Page string
+
+	Request *http.Request
+	Status  int
+	Latency time.Duration
+
+	// Bytes is the size of the response body as sent, after any compression.
+	Bytes int64
+
+	// CacheHit is whether the response came from a static handler's cache or an OutputCache rather than being
+	// rendered.
+	CacheHit bool
+
+	// Err is the error the page failed to render with, if it did.
+	Err error
+}
+
+// JSONAccessLog returns an AccessLog that writes each entry to w as a line of JSON in the structured logging format
+// of Cloud Logging, which App Engine and Cloud Run pick up from stdout:
+//
+//	{"severity":"INFO","message":"GET / 200","page":"templates/index.html","httpRequest":{"requestMethod":"GET",...}}
+//
+// Responses with a 4xx status are logged as warnings and those with a 5xx status as errors, along with the error the
+// page failed to render with.
+func JSONAccessLog(w io.Writer) AccessLog {
+	return &jsonAccessLog{w: w}
+}
+
+// CloudAccessLog is like JSONAccessLog, but also logs the trace App Engine and Cloud Run give each request in its
+// X-Cloud-Trace-Context header, as a trace of the Google Cloud project, so that the Cloud Console shows each request's
+// log line with the request it's about.
+func CloudAccessLog(w io.Writer, project string) AccessLog {
+	return &jsonAccessLog{w: w, project: project}
+}
+
+type jsonAccessLog struct {
+	mu      sync.Mutex
+	w       io.Writer
+	project string
+}
+
+// jsonHTTPRequest is Cloud Logging's HttpRequest, as written in a structured log line.
+type jsonHTTPRequest struct {
+	RequestMethod string `json:"requestMethod"`
+	RequestURL    string `json:"requestUrl"`
+	Status        int    `json:"status"`
+	ResponseSize  string `json:"responseSize"`
+	UserAgent     string `json:"userAgent,omitempty"`
+	RemoteIP      string `json:"remoteIp,omitempty"`
+	Referer       string `json:"referer,omitempty"`
+	Latency       string `json:"latency"`
+	CacheHit      bool   `json:"cacheHit"`
+	Protocol      string `json:"protocol,omitempty"`
+}
+
+type jsonAccessEntry struct {
+	Severity     string          `json:"severity"`
+	Message      string          `json:"message"`
+	Page         string          `json:"page"`
+	Error        string          `json:"error,omitempty"`
+	HTTPRequest  jsonHTTPRequest `json:"httpRequest"`
+	Trace        string          `json:"logging.googleapis.com/trace,omitempty"`
+	SpanID       string          `json:"logging.googleapis.com/spanId,omitempty"`
+	TraceSampled bool            `json:"logging.googleapis.com/trace_sampled,omitempty"`
+}
+
+func (l *jsonAccessLog) Served(e AccessEntry) {
+	r := e.Request
+	severity := "INFO"
+	switch {
+	case e.Status >= 500:
+		severity = "ERROR"
+	case e.Status >= 400:
+		severity = "WARNING"
+	}
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	entry := jsonAccessEntry{
+		Severity: severity,
+		Message:  fmt.Sprintf("%s %s %d", r.Method, r.URL.RequestURI(), e.Status),
+		Page:     e.Page,
+		HTTPRequest: jsonHTTPRequest{
+			RequestMethod: r.Method,
+			RequestURL:    r.URL.RequestURI(),
+			Status:        e.Status,
+			ResponseSize:  strconv.FormatInt(e.Bytes, 10),
+			UserAgent:     r.UserAgent(),
+			RemoteIP:      ip,
+			Referer:       r.Referer(),
+			Latency:       fmt.Sprintf("%.9fs", e.Latency.Seconds()),
+			CacheHit:      e.CacheHit,
+			Protocol:      r.Proto,
+		},
+	}
+	if e.Err != nil {
+		entry.Error = e.Err.Error()
+	}
+	if l.project != "" {
+		if trace, span, sampled, ok := cloudTrace(r); ok {
+			entry.Trace, entry.SpanID, entry.TraceSampled = "projects/"+l.project+"/traces/"+trace, span, sampled
+		}
+	}
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	b = append(b, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.w.Write(b)
+}
+
+// cloudTrace returns the trace ID and span ID in r's X-Cloud-Trace-Context header, which has the form
+// TRACE_ID/SPAN_ID;o=OPTIONS, and whether the request is sampled. The span ID is given in hex, as Cloud Logging wants
+// it, rather than in decimal as the header has it.
+func cloudTrace(r *http.Request) (trace, span string, sampled, ok bool) {
+	h := r.Header.Get("X-Cloud-Trace-Context")
+	h, options, _ := strings.Cut(h, ";")
+	trace, span, _ = strings.Cut(h, "/")
+	if trace == "" {
+		return "", "", false, false
+	}
+	if id, err := strconv.ParseUint(span, 10, 64); err == nil {
+		span = fmt.Sprintf("%016x", id)
+	} else {
+		span = ""
+	}
+	return trace, span, options == "o=1", true
+}
+
+// accessWriter records the response to a request for the Base's AccessLog.
+type accessWriter struct {
+	http.ResponseWriter
+	t     *TemplateHandler
+	r     *http.Request
+	start time.Time
+
+	status int
+	bytes  int64
+	hit    bool
+	err    error
+}
+
+// logAccess returns w wrapped to record the response for the Base's AccessLog, or nil if there is none. Call log
+// once the response has been served.
+func (t *TemplateHandler) logAccess(w http.ResponseWriter, r *http.Request) *accessWriter {
+	if t.base.AccessLog == nil {
+		return nil
+	}
+	return &accessWriter{ResponseWriter: w, t: t, r: r, start: time.Now()}
+}
+
+func (a *accessWriter) WriteHeader(code int) {
+	// Informational responses such as 103 Early Hints come before the real one.
+	if a.status == 0 && code >= 200 {
+		a.status = code
+	}
+	a.ResponseWriter.WriteHeader(code)
+}
+
+func (a *accessWriter) Write(b []byte) (int, error) {
+	if a.status == 0 {
+		a.status = http.StatusOK
+	}
+	n, err := a.ResponseWriter.Write(b)
+	a.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying ResponseWriter.
+func (a *accessWriter) Unwrap() http.ResponseWriter {
+	return a.ResponseWriter
+}
+
+func (a *accessWriter) log() {
+	status := a.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	a.t.base.AccessLog.Served(AccessEntry{
+		Page:     a.t.Name,
+		Request:  a.r,
+		Status:   status,
+		Latency:  time.Since(a.start),
+		Bytes:    a.bytes,
+		CacheHit: a.hit,
+		Err:      a.err,
+	})
+}
+
+// cacheHit marks the response being written to w, if logged, as served from a cache.
+func cacheHit(w http.ResponseWriter) {
+	if a, ok := w.(*accessWriter); ok {
+		a.hit = true
+	}
+}
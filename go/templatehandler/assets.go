@@ -0,0 +1,211 @@
+package templatehandler
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultAssetCache is the policy an AssetServer serves files with when they're requested without their fingerprint.
+var DefaultAssetCache = CachePolicy{MaxAge: time.Hour}
+
+// immutableCache is the Cache-Control header for fingerprinted files, whose URL changes with their contents.
+const immutableCache = "public, max-age=31536000, immutable"
+
+// AssetServer serves the static files in FS under Prefix, such as /static/css/main.css from css/main.css. Each file
+// has a strong ETag from its contents, so repeat visitors revalidate with a 304, and text files are compressed for
+// clients that accept it. Directories aren't listed.
+//
+// Its "asset" func, from Funcs, gives a file's URL with its fingerprint, a hash of its contents, as the "v" query
+// parameter. Requests carrying the current fingerprint are cached for a year as immutable, since a change to the file
+// changes the URL pages link to; others get Cache, or DefaultAssetCache if it's nil:
+//
+//	<link href="{{ asset "css/main.css" }}" rel="stylesheet">
+//
+// The fingerprint is in the query rather than the file name, so the same URLs also work from a static file host, such
+// as App Engine's static handlers or an Export of the site alongside a copy of the files.
+type AssetServer struct {
+	FS     fs.FS
+	Prefix string
+	Cache  *CachePolicy
+
+	mu     sync.Mutex
+	hashes map[string]assetHash
+	z      map[string][]byte
+}
+
+// assetHash is a file's fingerprint, kept until the file changes.
+type assetHash struct {
+	modTime time.Time
+	size    int64
+	sum     string
+}
+
+// Assets returns an AssetServer for the files in fsys, to be mounted at prefix, e.g.
+// mux.Handle("/static/", Assets(os.DirFS("static"), "/static/")).
+func Assets(fsys fs.FS, prefix string) *AssetServer {
+	return &AssetServer{FS: fsys, Prefix: prefix}
+}
+
+// Funcs returns the "asset" func, for making pages link to files by their fingerprinted URL.
+func (a *AssetServer) Funcs() template.FuncMap {
+	return template.FuncMap{"asset": a.URL}
+}
+
+// URL returns the URL of the named file with its fingerprint, e.g. /static/css/main.css?v=1a2b3c4d5e6f7a8b.
+func (a *AssetServer) URL(name string) (string, error) {
+	sum, err := a.fingerprint(name)
+	if err != nil {
+		return "", fmt.Errorf("asset: %v", err)
+	}
+	return a.Prefix + name + "?v=" + sum, nil
+}
+
+// fingerprint returns the hash of the named file, hashing it again only if it has changed since the last time.
+func (a *AssetServer) fingerprint(name string) (string, error) {
+	info, err := fs.Stat(a.FS, name)
+	if err != nil {
+		return "", err
+	}
+	if info.IsDir() {
+		return "", fmt.Errorf("%s is a directory", name)
+	}
+	a.mu.Lock()
+	h, ok := a.hashes[name]
+	a.mu.Unlock()
+	if ok && h.modTime.Equal(info.ModTime()) && h.size == info.Size() {
+		return h.sum, nil
+	}
+
+	b, err := fs.ReadFile(a.FS, name)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	h = assetHash{modTime: info.ModTime(), size: info.Size(), sum: fmt.Sprintf("%x", sum[:8])}
+	a.mu.Lock()
+	if a.hashes == nil {
+		a.hashes = make(map[string]assetHash)
+	}
+	a.hashes[name] = h
+	a.mu.Unlock()
+	return h.sum, nil
+}
+
+func (a *AssetServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !(&methodSet{defaultMethods: []string{http.MethodGet}}).allow(w, r) {
+		return
+	}
+	name, ok := strings.CutPrefix(r.URL.Path, a.Prefix)
+	if !ok || !fs.ValidPath(name) || name == "." {
+		http.NotFound(w, r)
+		return
+	}
+	sum, err := a.fingerprint(name)
+	if err != nil {
+		// Missing files and directories alike.
+		http.NotFound(w, r)
+		return
+	}
+	f, err := a.FS.Open(name)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		DefaultErrorHandler(w, r, err)
+		return
+	}
+
+	h := w.Header()
+	if ct := mime.TypeByExtension(path.Ext(name)); ct != "" {
+		h.Set("Content-Type", ct)
+	}
+	h.Set("X-Content-Type-Options", "nosniff")
+	if r.URL.Query().Get("v") == sum {
+		h.Set("Cache-Control", immutableCache)
+	} else {
+		cache := DefaultAssetCache
+		if a.Cache != nil {
+			cache = *a.Cache
+		}
+		h.Set("Cache-Control", cache.String())
+	}
+
+	etag := sum
+	var content io.ReadSeeker
+	if enc := negotiateEncoding(r); enc != "" && compressible(h.Get("Content-Type")) {
+		body, err := a.compressed(f, sum, enc)
+		if err != nil {
+			DefaultErrorHandler(w, r, err)
+			return
+		}
+		h.Set("Content-Encoding", enc)
+		setEncodedLength(h, r, len(body))
+		content, etag = bytes.NewReader(body), etag+"-"+enc
+	} else if rs, ok := f.(io.ReadSeeker); ok {
+		content = rs
+	} else {
+		b, err := io.ReadAll(f)
+		if err != nil {
+			DefaultErrorHandler(w, r, err)
+			return
+		}
+		content = bytes.NewReader(b)
+	}
+	addVary(h, "Accept-Encoding")
+	h.Set("ETag", strconv.Quote(etag))
+	http.ServeContent(w, r, name, info.ModTime(), content)
+}
+
+// compressed returns the file f, whose fingerprint is sum, compressed with enc. Compressed copies are kept by
+// fingerprint, so a changed file is compressed again.
+func (a *AssetServer) compressed(f fs.File, sum, enc string) ([]byte, error) {
+	key := sum + "-" + enc
+	a.mu.Lock()
+	body, ok := a.z[key]
+	a.mu.Unlock()
+	if ok {
+		return body, nil
+	}
+	b, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	if body, err = compress(enc, b); err != nil {
+		return nil, err
+	}
+	a.mu.Lock()
+	if a.z == nil {
+		a.z = make(map[string][]byte)
+	}
+	a.z[key] = body
+	a.mu.Unlock()
+	return body, nil
+}
+
+// compressible reports whether files of the given content type are worth compressing. Images other than SVG, fonts
+// and archives are compressed already.
+func compressible(contentType string) bool {
+	t, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case strings.HasPrefix(t, "text/"):
+		return true
+	case t == "image/svg+xml", t == "application/javascript", t == "application/json", t == "application/xml",
+		t == "application/manifest+json", t == "application/wasm":
+		return true
+	}
+	return false
+}
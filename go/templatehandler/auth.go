@@ -0,0 +1,45 @@
+package templatehandler
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// BearerToken returns middleware that only lets through requests carrying token as a bearer token, for admin
+// endpoints reached with e.g. curl -H "Authorization: Bearer $TOKEN". Other requests get a 401. An empty token
+// refuses every request, so an unset secret doesn't open the endpoint to everyone.
+func BearerToken(token string) Middleware {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || token == "" || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// AppEngineCron returns middleware that lets through the requests made by App Engine's cron service, which carry
+// X-Appengine-Cron: true, and hands the others to or, such as idtoken.Bearer for Cloud Scheduler's, or refuses them
+// with a 403 if or is nil. App Engine removes the header from requests from outside, so only trust it there; servers
+// elsewhere must remove it themselves.
+func AppEngineCron(or Middleware) Middleware {
+	return func(h http.Handler) http.Handler {
+		other := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		}))
+		if or != nil {
+			other = or(h)
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("X-Appengine-Cron") == "true" {
+				h.ServeHTTP(w, r)
+				return
+			}
+			other.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package templatehandler
+
+import (
+	"html/template"
+	"strings"
+)
+
+// BreadcrumbsKey is the reserved input key under which pages find their breadcrumb trail, a []Crumb from the root of
+// the site down to the page itself. It's empty for pages that haven't declared their place with Breadcrumb.
+const BreadcrumbsKey = "Breadcrumbs"
+
+// Crumb is one step of a breadcrumb trail.
+type Crumb struct {
+	Title string
+	Path  string
+}
+
+type crumbRoute struct {
+	title  string
+	parent string
+}
+
+// Breadcrumb declares the page's place in the site's breadcrumb trail: it's served at path, shown in trails as title,
+// and its parent is the page at parent, or none if parent is "". Declare every page of a trail, parents included,
+// before any of them is first rendered, since static pages keep the trail they were first rendered with.
+//
+// The page's trail is given to it under BreadcrumbsKey, and the breadcrumbs func renders it:
+//
+//	{{ breadcrumbs .Breadcrumbs }}
+func (t *TemplateHandler) Breadcrumb(path, title, parent string) *TemplateHandler {
+	t.base.crumbsMu.Lock()
+	defer t.base.crumbsMu.Unlock()
+	if t.base.crumbs == nil {
+		t.base.crumbs = make(map[string]crumbRoute)
+	}
+	t.base.crumbs[path] = crumbRoute{title: title, parent: parent}
+	t.crumb = path
+	return t
+}
+
+// trail returns the breadcrumb trail from the root down to the page at path. A parent that was never declared ends
+// the trail, as does a loop.
+func (b *Base) trail(path string) []Crumb {
+	b.crumbsMu.RLock()
+	defer b.crumbsMu.RUnlock()
+	var trail []Crumb
+	seen := make(map[string]bool)
+	for path != "" && !seen[path] {
+		r, ok := b.crumbs[path]
+		if !ok {
+			break
+		}
+		seen[path] = true
+		trail = append(trail, Crumb{Title: r.title, Path: path})
+		path = r.parent
+	}
+	for i, j := 0, len(trail)-1; i < j; i, j = i+1, j-1 {
+		trail[i], trail[j] = trail[j], trail[i]
+	}
+	return trail
+}
+
+// withBreadcrumbs returns a copy of input with the page's trail added under BreadcrumbsKey.
+func (t *TemplateHandler) withBreadcrumbs(input map[string]interface{}) map[string]interface{} {
+	var trail []Crumb
+	if t.crumb != "" {
+		trail = t.base.trail(t.crumb)
+	}
+	return mergeMap(input, map[string]interface{}{BreadcrumbsKey: trail})
+}
+
+var breadcrumbsTemplate = template.Must(template.New("breadcrumbs").Parse(`<nav aria-label="breadcrumb"><ol class="breadcrumb">
+{{- range $i, $c := .Crumbs }}
+{{- if eq $i $.Last }}<li class="breadcrumb-item active" aria-current="page">{{ $c.Title }}</li>
+{{- else }}<li class="breadcrumb-item"><a href="{{ $c.Path }}">{{ $c.Title }}</a></li>
+{{- end }}
+{{- end -}}
+</ol></nav>
+<script type="application/ld+json">{{ .LD }}</script>`))
+
+// breadcrumbs renders trail as an ordered list of links, marked up with Bootstrap's breadcrumb classes, followed by
+// the same trail as schema.org BreadcrumbList JSON-LD for search engines. Search engines want absolute URLs in JSON-LD,
+// so pass the site's origin, such as .Site.BaseURL, as baseURL if it has one.
+func breadcrumbs(trail []Crumb, baseURL ...string) (template.HTML, error) {
+	if len(trail) == 0 {
+		return "", nil
+	}
+	origin := ""
+	if len(baseURL) > 0 {
+		origin = strings.TrimSuffix(baseURL[0], "/")
+	}
+
+	var items []map[string]interface{}
+	for i, c := range trail {
+		items = append(items, map[string]interface{}{
+			"@type":    "ListItem",
+			"position": i + 1,
+			"name":     c.Title,
+			"item":     origin + c.Path,
+		})
+	}
+	var b strings.Builder
+	err := breadcrumbsTemplate.Execute(&b, map[string]interface{}{
+		"Crumbs": trail,
+		"Last":   len(trail) - 1,
+		"LD": map[string]interface{}{
+			"@context":        "https://schema.org",
+			"@type":           "BreadcrumbList",
+			"itemListElement": items,
+		},
+	})
+	return template.HTML(b.String()), err
+}
@@ -0,0 +1,122 @@
+package templatehandler
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// bundle holds the "css" and "js" blocks of a page, moved out into files of their own.
+type bundle struct {
+	prefix string
+	name   string
+
+	mu    sync.RWMutex
+	files map[string][]byte
+}
+
+// Bundle moves the page's "css" and "js" blocks out of the page into a stylesheet and a script, served by the returned
+// handler as prefix + name + "-" + hash + ".css" (or ".js"). The page links to them instead of inlining them, so that it
+// gets smaller and browsers can cache the assets. Mount the returned handler at prefix.
+//
+// The blocks are rendered once with the page's static input; a wrapping <style> or <script> element is removed. Call
+// Bundle before the page is first served.
+func (t *TemplateHandler) Bundle(prefix, name string) (http.Handler, error) {
+	if _, _, err := t.current(""); err != nil {
+		return nil, err
+	}
+	b := &bundle{
+		prefix: prefix,
+		name:   name,
+	}
+	if err := b.apply(&page{tmpl: t.Template, input: t.Input, locales: t.locales}); err != nil {
+		return nil, err
+	}
+	t.bundle = b
+	return b, nil
+}
+
+// apply renders the "css" and "js" blocks of p into files, and redefines the blocks to link to them instead, in p and
+// in each of its translations.
+func (b *bundle) apply(p *page) error {
+	// An executed template can't be parsed into, so render the blocks from a clone.
+	c, err := p.tmpl.Clone()
+	if err != nil {
+		return err
+	}
+
+	files := make(map[string][]byte)
+	for _, block := range []struct {
+		name, tag, ext, link string
+	}{
+		{"css", "style", "css", `<link rel="stylesheet" href="%s">`},
+		{"js", "script", "js", `<script src="%s"></script>`},
+	} {
+		var out bytes.Buffer
+		if err := c.ExecuteTemplate(&out, block.name, p.input); err != nil {
+			return err
+		}
+		body := unwrapElement(out.Bytes(), block.tag)
+
+		define := fmt.Sprintf("{{ define %q }}{{ end }}", block.name)
+		if len(body) > 0 {
+			sum := sha256.Sum256(body)
+			file := fmt.Sprintf("%s-%x.%s", b.name, sum[:8], block.ext)
+			files[file] = body
+			link := fmt.Sprintf(block.link, template.HTMLEscapeString(b.prefix+file))
+			define = fmt.Sprintf("{{ define %q }}%s{{ end }}", block.name, link)
+		}
+		if err := parseInternal(p.tmpl, define); err != nil {
+			return err
+		}
+		for _, l := range p.locales {
+			if err := parseInternal(l.tmpl, define); err != nil {
+				return err
+			}
+		}
+	}
+
+	b.mu.Lock()
+	b.files = files
+	b.mu.Unlock()
+	return nil
+}
+
+func (b *bundle) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, b.prefix)
+	b.mu.RLock()
+	body, ok := b.files[name]
+	b.mu.RUnlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	if strings.HasSuffix(name, ".css") {
+		w.Header().Set("Content-Type", "text/css; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "text/javascript; charset=utf-8")
+	}
+	// The file name changes with its contents, so it can be cached forever.
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	writeCompressed(w, r, body)
+}
+
+// unwrapElement trims b and, if it is a single element with the given tag, returns just that element's contents.
+func unwrapElement(b []byte, tag string) []byte {
+	b = bytes.TrimSpace(b)
+	lower := bytes.ToLower(b)
+	end := []byte("</" + tag + ">")
+	if !bytes.HasPrefix(lower, []byte("<"+tag)) || !bytes.HasSuffix(lower, end) {
+		return b
+	}
+	i := bytes.IndexByte(b, '>')
+	if i < 0 || i >= len(b)-len(end) {
+		return b
+	}
+	return bytes.TrimSpace(b[i+1 : len(b)-len(end)])
+}
@@ -0,0 +1,61 @@
+package templatehandler
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// CachePolicy describes how long browsers and shared caches may keep a response, and is sent as its Cache-Control
+// header.
+type CachePolicy struct {
+	// MaxAge is how long any cache may serve the response without revalidating it.
+	MaxAge time.Duration
+
+	// SMaxAge, if set, overrides MaxAge for shared caches such as CDNs.
+	SMaxAge time.Duration
+
+	// StaleWhileRevalidate is how long after MaxAge a cache may keep serving the response while it revalidates it
+	// in the background.
+	StaleWhileRevalidate time.Duration
+
+	// Private keeps the response out of shared caches, as it is meant for one visitor only.
+	Private bool
+
+	// NoCache makes caches revalidate the response before every use.
+	NoCache bool
+
+	// NoStore keeps the response out of all caches. The other fields are ignored.
+	NoStore bool
+}
+
+// Policies static and dynamic handlers use if they aren't given one.
+var (
+	DefaultStaticCache  = CachePolicy{MaxAge: time.Hour, StaleWhileRevalidate: 24 * time.Hour}
+	DefaultDynamicCache = CachePolicy{NoStore: true}
+)
+
+// String returns the policy as a Cache-Control header value.
+func (p CachePolicy) String() string {
+	if p.NoStore {
+		return "no-store"
+	}
+
+	var parts []string
+	if p.Private {
+		parts = append(parts, "private")
+	} else {
+		parts = append(parts, "public")
+	}
+	if p.NoCache {
+		parts = append(parts, "no-cache")
+	}
+	parts = append(parts, fmt.Sprintf("max-age=%d", int(p.MaxAge.Seconds())))
+	if p.SMaxAge > 0 {
+		parts = append(parts, fmt.Sprintf("s-maxage=%d", int(p.SMaxAge.Seconds())))
+	}
+	if p.StaleWhileRevalidate > 0 {
+		parts = append(parts, fmt.Sprintf("stale-while-revalidate=%d", int(p.StaleWhileRevalidate.Seconds())))
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,110 @@
+package templatehandler
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// TrackingParams are the query parameters left out of canonical URLs, besides any starting with "utm_", because they
+// say where a visitor came from rather than what they're looking at.
+var TrackingParams = []string{"fbclid", "gclid", "dclid", "msclkid", "mc_cid", "mc_eid", "_ga", "_gl", "ref"}
+
+// CanonicalURL returns the canonical URL of the page r is for: its lower-cased path, without any "index.html", on
+// baseURL, with tracking parameters removed from the query. Without a baseURL it's on the origin r was sent to, so give
+// one for every version of the site to name the same URL.
+func CanonicalURL(baseURL string, r *http.Request) string {
+	origin := strings.TrimSuffix(baseURL, "/")
+	if origin == "" {
+		origin = scheme(r) + "://" + r.Host
+	}
+	return canonicalURL(origin, r.URL.Path, r.URL.Query())
+}
+
+func canonicalURL(origin, p string, query url.Values) string {
+	clean := cleanPath(p)
+	if strings.HasSuffix(p, "/") && clean != "/" {
+		clean += "/"
+	}
+	u := origin + (&url.URL{Path: clean}).EscapedPath()
+
+	q := make(url.Values)
+	for k, v := range query {
+		if !isTrackingParam(k) {
+			q[k] = v
+		}
+	}
+	if len(q) > 0 {
+		u += "?" + q.Encode()
+	}
+	return u
+}
+
+func isTrackingParam(name string) bool {
+	name = strings.ToLower(name)
+	return strings.HasPrefix(name, "utm_") || contains(TrackingParams, name)
+}
+
+// CanonicalLink returns middleware adding a Link: rel=canonical header to each successful response to a GET or HEAD
+// request, naming its CanonicalURL on baseURL, so that search engines index a single copy of each page however it was
+// reached, static pages included.
+func CanonicalLink(baseURL string) Middleware {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != "GET" && r.Method != "HEAD" {
+				h.ServeHTTP(w, r)
+				return
+			}
+			link := fmt.Sprintf(`<%s>; rel="canonical"`, CanonicalURL(baseURL, r))
+			h.ServeHTTP(&canonicalWriter{ResponseWriter: w, link: link}, r)
+		})
+	}
+}
+
+// canonicalWriter adds a canonical link to the response once it's known to be a successful one, rather than a
+// redirect to the canonical URL or an error.
+type canonicalWriter struct {
+	http.ResponseWriter
+	link    string
+	started bool
+}
+
+func (w *canonicalWriter) WriteHeader(code int) {
+	// Informational responses such as 103 Early Hints don't start the real one.
+	if code >= 200 && !w.started {
+		w.started = true
+		if code < 300 {
+			w.Header().Add("Link", w.link)
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *canonicalWriter) Write(b []byte) (int, error) {
+	if !w.started {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying ResponseWriter.
+func (w *canonicalWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// canonicalURLFunc is the "canonicalURL" func. It returns the canonical URL of the page with the given input, on the
+// site configuration's BaseURL, for a <link rel="canonical">: {{ with canonicalURL . }}...{{ end }}. Static pages
+// aren't rendered for a request, so it's "" for them; CanonicalLink covers those.
+func canonicalURLFunc(input map[string]interface{}) string {
+	req, _ := input[RequestKey].(*Request)
+	if req == nil {
+		return ""
+	}
+	site, _ := input[SiteKey].(map[string]interface{})
+	origin := strings.TrimSuffix(metaString(site["BaseURL"]), "/")
+	if origin == "" {
+		origin = req.scheme + "://" + req.Host
+	}
+	return canonicalURL(origin, req.Path, req.Query)
+}
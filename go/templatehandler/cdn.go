@@ -0,0 +1,204 @@
+package templatehandler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// cdnPurgeTimeout is how long a CDN's Purger may take when the base purges it.
+const cdnPurgeTimeout = 10 * time.Second
+
+// CDNRoute is how a CDN in front of the site caches the responses for the paths starting with Prefix.
+type CDNRoute struct {
+	Prefix string
+
+	// SMaxAge is how long the CDN may keep the route's responses. It replaces the s-maxage of responses that shared
+	// caches may keep at all; private, no-cache and no-store responses are left alone.
+	SMaxAge time.Duration
+
+	// Keys are the surrogate keys the route's responses are tagged with, so that they can be purged together.
+	Keys []string
+}
+
+// CDN tells a CDN in front of the site how long to keep each route's responses, and tags them with surrogate keys, so
+// that they can be purged by key rather than left until they expire. The route with the longest matching Prefix is
+// used; paths no route matches keep their handler's Cache-Control, but are still tagged with SiteKey. Give it to a
+// Base as its CDN, which purges SiteKey whenever its templates or input change.
+type CDN struct {
+	Routes []CDNRoute
+
+	// SiteKey is the surrogate key every response is tagged with. If empty, it's "site".
+	SiteKey string
+
+	// KeyHeader is the response header the keys are sent in, space separated. If empty, it's "Surrogate-Key", as
+	// Fastly reads; Cloud CDN, for one, reads "Cache-Tag" instead, comma separated.
+	KeyHeader string
+
+	// Purger, if set, purges keys from the CDN.
+	Purger Purger
+}
+
+// Purger purges the responses tagged with any of keys from a CDN.
+type Purger interface {
+	Purge(ctx context.Context, keys ...string) error
+}
+
+func (c *CDN) siteKey() string {
+	if c.SiteKey == "" {
+		return "site"
+	}
+	return c.SiteKey
+}
+
+// Middleware adds the CDN headers to h's responses.
+func (c *CDN) Middleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cw := &cdnWriter{ResponseWriter: w, cdn: c, route: c.route(r.URL.Path)}
+		h.ServeHTTP(cw, r)
+		// A handler that writes nothing sends a 200 once it returns.
+		if !cw.started {
+			cw.started = true
+			cw.setHeaders(http.StatusOK)
+		}
+	})
+}
+
+// route returns the route for path, or nil if there's none.
+func (c *CDN) route(path string) *CDNRoute {
+	var best *CDNRoute
+	for i, route := range c.Routes {
+		if strings.HasPrefix(path, route.Prefix) && (best == nil || len(route.Prefix) > len(best.Prefix)) {
+			best = &c.Routes[i]
+		}
+	}
+	return best
+}
+
+// Purge purges the responses tagged with any of keys, if the CDN has a Purger.
+func (c *CDN) Purge(ctx context.Context, keys ...string) error {
+	if c.Purger == nil {
+		return nil
+	}
+	return c.Purger.Purge(ctx, keys...)
+}
+
+// purgeSite purges every response, as the base does once it's swapped in new templates or input.
+func (c *CDN) purgeSite() {
+	ctx, cancel := context.WithTimeout(context.Background(), cdnPurgeTimeout)
+	defer cancel()
+	if err := c.Purge(ctx, c.siteKey()); err != nil {
+		log.Printf("templatehandler: could not purge CDN key=%q error=%q", c.siteKey(), err)
+	}
+}
+
+// cdnWriter sets the CDN headers once the response's own are known.
+type cdnWriter struct {
+	http.ResponseWriter
+	cdn     *CDN
+	route   *CDNRoute
+	started bool
+}
+
+func (w *cdnWriter) WriteHeader(code int) {
+	// Informational responses such as 103 Early Hints don't start the real one.
+	if code >= 200 && !w.started {
+		w.started = true
+		w.setHeaders(code)
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *cdnWriter) setHeaders(code int) {
+	h := w.Header()
+	keys := []string{w.cdn.siteKey()}
+	if w.route != nil {
+		keys = append(keys, w.route.Keys...)
+		if code < 300 && w.route.SMaxAge > 0 {
+			if cc, ok := withSMaxAge(h.Get("Cache-Control"), w.route.SMaxAge); ok {
+				h.Set("Cache-Control", cc)
+			}
+		}
+	}
+	switch header := w.cdn.KeyHeader; header {
+	case "":
+		h.Set("Surrogate-Key", strings.Join(keys, " "))
+	case "Cache-Tag":
+		h.Set(header, strings.Join(keys, ","))
+	default:
+		h.Set(header, strings.Join(keys, " "))
+	}
+}
+
+func (w *cdnWriter) Write(b []byte) (int, error) {
+	if !w.started {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying ResponseWriter.
+func (w *cdnWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// withSMaxAge returns the Cache-Control header cc with its s-maxage set to d, and whether shared caches may keep the
+// response at all, which they may not if it's private, no-cache or no-store, or has no Cache-Control.
+func withSMaxAge(cc string, d time.Duration) (string, bool) {
+	if cc == "" {
+		return "", false
+	}
+	var parts []string
+	for _, p := range strings.Split(cc, ",") {
+		p = strings.TrimSpace(p)
+		name, _, _ := strings.Cut(strings.ToLower(p), "=")
+		switch name {
+		case "private", "no-cache", "no-store":
+			return "", false
+		case "s-maxage":
+			continue
+		}
+		parts = append(parts, p)
+	}
+	return strings.Join(append(parts, fmt.Sprintf("s-maxage=%d", int(d.Seconds()))), ", "), true
+}
+
+// fastlyAPI is the Fastly API's address.
+const fastlyAPI = "https://api.fastly.com"
+
+// FastlyPurger purges keys from the Fastly service ServiceID, with the API token Token.
+type FastlyPurger struct {
+	ServiceID string
+	Token     string
+
+	// Client makes the requests. If nil, a client with a cdnPurgeTimeout timeout is used.
+	Client *http.Client
+}
+
+var _ Purger = (*FastlyPurger)(nil)
+
+func (f *FastlyPurger) Purge(ctx context.Context, keys ...string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fastlyAPI+"/service/"+url.PathEscape(f.ServiceID)+"/purge", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Fastly-Key", f.Token)
+	req.Header.Set("Surrogate-Key", strings.Join(keys, " "))
+	client := f.Client
+	if client == nil {
+		client = &http.Client{Timeout: cdnPurgeTimeout}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("templatehandler: fastly purge: %s", resp.Status)
+	}
+	return nil
+}
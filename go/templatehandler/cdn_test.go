@@ -0,0 +1,67 @@
+package templatehandler_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/mconbere/quitlikeapro/go/templatehandler"
+)
+
+// purged records the keys purged from it.
+type purged []string
+
+func (p *purged) Purge(ctx context.Context, keys ...string) error {
+	*p = append(*p, keys...)
+	return nil
+}
+
+func TestCDN(t *testing.T) {
+	cdn := &templatehandler.CDN{Routes: []templatehandler.CDNRoute{
+		{Prefix: "/", SMaxAge: time.Hour, Keys: []string{"pages"}},
+		{Prefix: "/api/", SMaxAge: time.Minute, Keys: []string{"api"}},
+	}}
+	h := cdn.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cc := "public, max-age=60, s-maxage=10"
+		if r.URL.Path == "/private" {
+			cc = "private, max-age=60"
+		}
+		w.Header().Set("Cache-Control", cc)
+	}))
+	for _, tt := range []struct {
+		path, cacheControl, keys string
+	}{
+		{"/about", "public, max-age=60, s-maxage=3600", "site pages"},
+		{"/api/v1/x", "public, max-age=60, s-maxage=60", "site api"},
+		{"/private", "private, max-age=60", "site pages"},
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+		if got := w.Header().Get("Cache-Control"); got != tt.cacheControl {
+			t.Errorf("GET %s: Cache-Control = %q, want %q", tt.path, got, tt.cacheControl)
+		}
+		if got := w.Header().Get("Surrogate-Key"); got != tt.keys {
+			t.Errorf("GET %s: Surrogate-Key = %q, want %q", tt.path, got, tt.keys)
+		}
+	}
+}
+
+// TestCDNPurgedOnUpdate checks that the base purges its CDN when its input changes.
+func TestCDNPurgedOnUpdate(t *testing.T) {
+	var p purged
+	base, err := templatehandler.NewBaseFS(fstest.MapFS{
+		"base.html": {Data: []byte(`{{ define "base" }}{{ .N }}{{ end }}`)},
+	}, "base.html", templatehandler.WithCDN(&templatehandler.CDN{Purger: &p}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := base.SetInput("N", 1); err != nil {
+		t.Fatal(err)
+	}
+	if len(p) != 1 || p[0] != "site" {
+		t.Errorf("purged %q, want [site]", p)
+	}
+}
@@ -0,0 +1,98 @@
+package templatehandler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// encodings are the content codings responses can be compressed with, in order of preference.
+var encodings = []string{"br", "gzip"}
+
+// negotiateEncoding returns the preferred content coding the client accepts, or "" if the response should be sent
+// uncompressed.
+func negotiateEncoding(r *http.Request) string {
+	accepted := make(map[string]bool)
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		params := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(params[0]))
+		if name == "" {
+			continue
+		}
+		ok := true
+		for _, p := range params[1:] {
+			p = strings.TrimSpace(p)
+			if strings.HasPrefix(p, "q=") {
+				q, err := strconv.ParseFloat(p[2:], 64)
+				ok = err == nil && q > 0
+			}
+		}
+		accepted[name] = ok
+	}
+
+	for _, e := range encodings {
+		if ok, listed := accepted[e]; listed {
+			if ok {
+				return e
+			}
+			continue
+		}
+		if accepted["*"] {
+			return e
+		}
+	}
+	return ""
+}
+
+// newCompressor returns a writer that compresses to w with the given content coding.
+func newCompressor(encoding string, w io.Writer) (io.WriteCloser, error) {
+	switch encoding {
+	case "br":
+		return brotli.NewWriter(w), nil
+	case "gzip":
+		return gzip.NewWriter(w), nil
+	}
+	return nil, fmt.Errorf("unknown content encoding %q", encoding)
+}
+
+func compress(encoding string, b []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := newCompressor(encoding, &buf)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(b); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// setEncodingHeaders sets the headers for a response whose uncompressed body is b, sent with the given content coding.
+// The content type is detected from b, since sniffing the compressed bytes would get it wrong.
+func setEncodingHeaders(h http.Header, encoding string, b []byte) {
+	addVary(h, "Accept-Encoding")
+	if h.Get("Content-Type") == "" {
+		h.Set("Content-Type", http.DetectContentType(b))
+	}
+	if encoding != "" {
+		h.Set("Content-Encoding", encoding)
+	}
+}
+
+// setEncodedLength sets the Content-Length of a compressed body of n bytes that http.ServeContent is to send. It leaves
+// the header out of whole responses with a Content-Encoding, so HEAD requests would otherwise only get it uncompressed.
+// Range requests are left to ServeContent, which sets the length of the ranges it sends.
+func setEncodedLength(h http.Header, r *http.Request, n int) {
+	if r.Header.Get("Range") == "" {
+		h.Set("Content-Length", strconv.Itoa(n))
+	}
+}
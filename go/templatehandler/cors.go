@@ -0,0 +1,50 @@
+package templatehandler
+
+import (
+	"net/http"
+	"strings"
+)
+
+// corsMaxAge is how long, in seconds, browsers may cache the answer to a preflight request.
+const corsMaxAge = "86400"
+
+// CORS returns middleware that lets scripts on pages from origins, e.g. https://example.com, read its handler's
+// responses, or scripts on any page if origins include "*". Credentials, such as cookies, are never allowed, so only
+// use it for data anyone could fetch.
+//
+// Preflight requests, OPTIONS requests carrying Access-Control-Request-Method, are answered for the method and
+// headers asked for without reaching the handler, which still rejects methods it doesn't serve.
+func CORS(origins ...string) Middleware {
+	anyOrigin := contains(origins, "*")
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if !anyOrigin {
+				addVary(w.Header(), "Origin")
+			}
+			if origin == "" || !anyOrigin && !contains(origins, origin) {
+				h.ServeHTTP(w, r)
+				return
+			}
+
+			if anyOrigin {
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+			} else {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+			}
+			method := r.Header.Get("Access-Control-Request-Method")
+			if r.Method != http.MethodOptions || method == "" {
+				// ETag isn't one of the headers scripts can read by default, and lets them revalidate.
+				w.Header().Set("Access-Control-Expose-Headers", "ETag")
+				h.ServeHTTP(w, r)
+				return
+			}
+			w.Header().Set("Access-Control-Allow-Methods", method)
+			if headers := r.Header.Values("Access-Control-Request-Headers"); len(headers) > 0 {
+				w.Header().Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+			}
+			w.Header().Set("Access-Control-Max-Age", corsMaxAge)
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+}
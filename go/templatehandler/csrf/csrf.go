@@ -0,0 +1,183 @@
+// Package csrf protects forms against cross-site request forgery.
+//
+// Each visitor gets a random session id in a cookie, and forms carry a token derived from it with an HMAC. Requests
+// that change state must send the token back, either as a form field or a header, and are refused without it. An
+// attacker's page can make the visitor's browser send the cookie, but can't read it to compute the token.
+//
+// Wrap handlers with Protector.Middleware, and give the Base the funcs from Protector.Funcs so that forms in dynamic
+// pages can include {{ csrfField .Request }}. Static pages are cached, and so can't carry a per-visitor token; wrap the
+// handlers they post to with SameOrigin instead, which checks where the request was made from.
+package csrf
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+
+	"github.com/mconbere/quitlikeapro/go/templatehandler"
+)
+
+// Defaults for the names Protector uses when its fields are unset.
+const (
+	DefaultCookie = "csrf"
+	DefaultField  = "csrf_token"
+	DefaultHeader = "X-CSRF-Token"
+)
+
+// ErrInvalidToken is reported when a request that needs a token doesn't carry a valid one.
+var ErrInvalidToken = errors.New("csrf: missing or invalid token")
+
+type contextKey struct{}
+
+// Protector issues and checks tokens signed with Key.
+type Protector struct {
+	// Key signs the tokens. It should be at least 32 random bytes, and kept secret.
+	Key []byte
+
+	// Cookie, Field and Header name the session cookie, the form field and the request header the token is sent in. If
+	// empty, DefaultCookie, DefaultField and DefaultHeader are used.
+	Cookie string
+	Field  string
+	Header string
+
+	// ErrorHandler writes the response to requests without a valid token. If nil, a plain 403 is sent.
+	ErrorHandler http.Handler
+}
+
+// New returns a Protector that signs tokens with key.
+func New(key []byte) *Protector {
+	return &Protector{Key: key}
+}
+
+func (p *Protector) cookie() string {
+	if p.Cookie == "" {
+		return DefaultCookie
+	}
+	return p.Cookie
+}
+
+func (p *Protector) field() string {
+	if p.Field == "" {
+		return DefaultField
+	}
+	return p.Field
+}
+
+func (p *Protector) header() string {
+	if p.Header == "" {
+		return DefaultHeader
+	}
+	return p.Header
+}
+
+// Middleware gives every request a session, setting the cookie if the visitor has none yet, and refuses POST, PUT,
+// PATCH and DELETE requests that don't carry a valid token. It can be used as a templatehandler.Middleware.
+func (p *Protector) Middleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session := ""
+		if c, err := r.Cookie(p.cookie()); err == nil {
+			session = c.Value
+		}
+		if _, err := base64.RawURLEncoding.DecodeString(session); err != nil || session == "" {
+			var err error
+			if session, err = newSession(); err != nil {
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			http.SetCookie(w, &http.Cookie{
+				Name:     p.cookie(),
+				Value:    session,
+				Path:     "/",
+				HttpOnly: true,
+				Secure:   r.TLS != nil,
+				SameSite: http.SameSiteLaxMode,
+			})
+		}
+		r = r.WithContext(context.WithValue(r.Context(), contextKey{}, session))
+
+		switch r.Method {
+		case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+			if err := p.Verify(r); err != nil {
+				if p.ErrorHandler != nil {
+					p.ErrorHandler.ServeHTTP(w, r)
+					return
+				}
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func newSession() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func (p *Protector) sign(session string) string {
+	mac := hmac.New(sha256.New, p.Key)
+	mac.Write([]byte(session))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (p *Protector) token(ctx context.Context) (string, error) {
+	session, ok := ctx.Value(contextKey{}).(string)
+	if !ok {
+		return "", errors.New("csrf: request was not served through Protector.Middleware")
+	}
+	return p.sign(session), nil
+}
+
+// Token returns the token for the session of r, which must have been served through Middleware, for clients that
+// send it in the header rather than a form.
+func (p *Protector) Token(r *http.Request) (string, error) {
+	return p.token(r.Context())
+}
+
+// Verify checks that r carries the token for its session, in the header or as a form field.
+func (p *Protector) Verify(r *http.Request) error {
+	want, err := p.Token(r)
+	if err != nil {
+		return err
+	}
+	got := r.Header.Get(p.header())
+	if got == "" {
+		got = r.PostFormValue(p.field())
+	}
+	if !hmac.Equal([]byte(got), []byte(want)) {
+		return ErrInvalidToken
+	}
+	return nil
+}
+
+// Funcs returns the template funcs for using tokens in pages: {{ csrfField .Request }} renders a hidden form field with
+// the token, and {{ csrfToken .Request }} the token alone, e.g. for a meta tag read by scripts.
+func (p *Protector) Funcs() template.FuncMap {
+	token := func(r *templatehandler.Request) (string, error) {
+		if r == nil {
+			return "", errors.New("csrf: page has no request; tokens need a dynamic page")
+		}
+		return p.token(r.Context)
+	}
+	return template.FuncMap{
+		"csrfToken": token,
+		"csrfField": func(r *templatehandler.Request) (template.HTML, error) {
+			tok, err := token(r)
+			if err != nil {
+				return "", err
+			}
+			return template.HTML(fmt.Sprintf(`<input type="hidden" name="%s" value="%s">`,
+				template.HTMLEscapeString(p.field()), template.HTMLEscapeString(tok))), nil
+		},
+	}
+}
@@ -0,0 +1,261 @@
+package templatehandler
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Loader loads data for pages' input from somewhere outside the templates, such as a file or a URL.
+type Loader func() (interface{}, error)
+
+// WithData adds the data l loads to the base's input under key, so that page data can live in data files rather than
+// in Go code or large input blocks. Loaders run once, when NewBase is called, and NewBase fails if one does.
+func WithData(key string, l Loader) Option {
+	return configure(func(b *Base) { b.loaders = append(b.loaders, namedLoader{key, l}) })
+}
+
+type namedLoader struct {
+	key  string
+	load Loader
+}
+
+// DataFile returns a Loader that reads the JSON, YAML or TOML file name from the local filesystem, chosen by its
+// extension.
+func DataFile(name string) Loader {
+	return func() (interface{}, error) {
+		var v interface{}
+		return v, ReadData(name, &v)
+	}
+}
+
+// DataFileFS is like DataFile, but reads the file from fsys, such as the filesystem the base's templates are read from,
+// so that data files can be updated along with them.
+func DataFileFS(fsys fs.FS, name string) Loader {
+	return func() (interface{}, error) {
+		var v interface{}
+		return v, ReadDataFS(fsys, name, &v)
+	}
+}
+
+// dataClient fetches DataURL data and WithSRI assets. Both are fetched at startup, so don't let a slow server hold it
+// up for long.
+var dataClient = &http.Client{Timeout: 10 * time.Second}
+
+// DataURL returns a Loader that fetches JSON, YAML or TOML from url, chosen by the response's Content-Type or, failing
+// that, the extension of the URL's path. Anything else is assumed to be JSON.
+func DataURL(url string) Loader {
+	return func() (interface{}, error) {
+		resp, err := dataClient.Get(url)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("templatehandler: fetching data %q: %s", url, resp.Status)
+		}
+		b, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+
+		format := "json"
+		mt, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+		switch {
+		case strings.Contains(mt, "yaml"):
+			format = "yaml"
+		case strings.Contains(mt, "toml"):
+			format = "toml"
+		case !strings.Contains(mt, "json"):
+			if f, err := formatOf(path.Ext(resp.Request.URL.Path)); err == nil {
+				format = f
+			}
+		}
+		var v interface{}
+		if err := unmarshalFormat(format, b, &v); err != nil {
+			return nil, fmt.Errorf("could not parse data %q: %v", url, err)
+		}
+		return v, nil
+	}
+}
+
+// ReadData decodes the JSON, YAML or TOML file name, chosen by its extension, into v.
+func ReadData(name string, v interface{}) error {
+	return readData(nil, name, v)
+}
+
+// ReadDataFS is like ReadData, but reads the file from fsys.
+func ReadDataFS(fsys fs.FS, name string, v interface{}) error {
+	return readData(fsys, name, v)
+}
+
+func readData(fsys fs.FS, name string, v interface{}) error {
+	format, err := formatOf(path.Ext(name))
+	if err != nil {
+		return err
+	}
+	var b []byte
+	if fsys == nil {
+		b, err = os.ReadFile(name)
+	} else {
+		b, err = fs.ReadFile(fsys, name)
+	}
+	if err != nil {
+		return err
+	}
+	if err := unmarshalFormat(format, b, v); err != nil {
+		return fmt.Errorf("could not parse %q: %v", name, err)
+	}
+	return nil
+}
+
+// formatOf returns the input format of files with extension ext, such as ".yml".
+func formatOf(ext string) (string, error) {
+	format := strings.TrimPrefix(ext, ".")
+	if format == "yml" {
+		format = "yaml"
+	}
+	for _, f := range inputFormats {
+		if f.format == format {
+			return format, nil
+		}
+	}
+	return "", fmt.Errorf("templatehandler: unknown data format %q", ext)
+}
+
+func unmarshalFormat(format string, b []byte, v interface{}) error {
+	for _, f := range inputFormats {
+		if f.format == format {
+			return f.unmarshal(b, v)
+		}
+	}
+	return fmt.Errorf("templatehandler: unknown data format %q", format)
+}
+
+// loadData runs the base's loaders, adding what they load to its input.
+func (b *Base) loadData() error {
+	loaded, err := b.runLoaders()
+	if err != nil {
+		return err
+	}
+	if loaded != nil {
+		b.setInput(mergeMap(b.input(), loaded))
+	}
+	return nil
+}
+
+// runLoaders runs the base's loaders, and returns what they load by key.
+func (b *Base) runLoaders() (map[string]interface{}, error) {
+	if len(b.loaders) == 0 {
+		return nil, nil
+	}
+	loaded := make(map[string]interface{}, len(b.loaders))
+	for _, l := range b.loaders {
+		v, err := l.load()
+		if err != nil {
+			return nil, fmt.Errorf("templatehandler: loading %q: %v", l.key, err)
+		}
+		loaded[l.key] = v
+	}
+	return loaded, nil
+}
+
+// resolveData returns a copy of v with the data references in it replaced by the data they refer to. A reference is
+// an object with a single "$file" or "$url" key, loaded as DataFile and DataURL would:
+//
+//	{{ define "input" }}{"Quittables": {"$file": "data/quittables.yaml"}}{{ end }}
+//
+// Files are read from the base's filesystem, and URLs fetched into fetched, so that each is only fetched once. Strings
+// have the environment variables allowed by WithEnv expanded.
+func (b *Base) resolveData(v interface{}, fetched *urlData) (interface{}, error) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if len(v) == 1 {
+			if name, ok := v["$file"].(string); ok {
+				var data interface{}
+				return data, readData(b.src.fsys, name, &data)
+			}
+			if url, ok := v["$url"].(string); ok {
+				return fetched.get(url)
+			}
+		}
+		out := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			r, err := b.resolveData(e, fetched)
+			if err != nil {
+				return nil, err
+			}
+			out[k] = r
+		}
+		return out, nil
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, e := range v {
+			r, err := b.resolveData(e, fetched)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = r
+		}
+		return out, nil
+	case string:
+		return b.expandEnv(v)
+	}
+	return v, nil
+}
+
+// resolveInput is resolveData for a page's input.
+func (b *Base) resolveInput(input map[string]interface{}, fetched *urlData) (map[string]interface{}, error) {
+	v, err := b.resolveData(input, fetched)
+	if err != nil {
+		return nil, err
+	}
+	return v.(map[string]interface{}), nil
+}
+
+// urlData is the data fetched for a base's {"$url": ...} references, by URL.
+type urlData struct {
+	mu sync.Mutex
+	m  map[string]*urlFetch
+}
+
+// urlFetch is the data fetched from a URL, which is ready once done is closed.
+type urlFetch struct {
+	done chan struct{}
+	v    interface{}
+	err  error
+}
+
+// get returns the data at url, fetching it the first time it's asked for. Only one goroutine fetches each URL, without
+// holding the lock, and others asking for it meanwhile wait for that fetch. A failed fetch isn't kept, so the next
+// call tries again.
+func (d *urlData) get(url string) (interface{}, error) {
+	d.mu.Lock()
+	if f, ok := d.m[url]; ok {
+		d.mu.Unlock()
+		<-f.done
+		return f.v, f.err
+	}
+	f := &urlFetch{done: make(chan struct{})}
+	if d.m == nil {
+		d.m = make(map[string]*urlFetch)
+	}
+	d.m[url] = f
+	d.mu.Unlock()
+
+	f.v, f.err = DataURL(url)()
+	if f.err != nil {
+		d.mu.Lock()
+		delete(d.m, url)
+		d.mu.Unlock()
+	}
+	close(f.done)
+	return f.v, f.err
+}
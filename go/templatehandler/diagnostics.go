@@ -0,0 +1,193 @@
+package templatehandler
+
+import (
+	"sort"
+	"text/template/parse"
+)
+
+// Diagnostics describes problems with a page's templates that would otherwise only show up when the page is served.
+type Diagnostics struct {
+	// Defined is the names of the templates the page defines, sorted.
+	Defined []string
+
+	// Missing maps the templates that are used but not defined to the templates that use them.
+	Missing map[string][]string
+
+	// MissingInput maps the input keys the templates use, as in {{ .Title }}, but the page's input doesn't supply to
+	// the templates that use them. Dynamic pages may supply these at request time. Keys are only found where the dot
+	// is the page's input: outside of range and with, in templates called with the dot.
+	MissingInput map[string][]string
+}
+
+// OK reports whether no templates or input are missing.
+func (d *Diagnostics) OK() bool {
+	return len(d.Missing) == 0 && len(d.MissingInput) == 0
+}
+
+// reservedInput are the input keys templatehandler supplies itself when it renders a page.
+var reservedInput = map[string]bool{
+	RequestKey:     true,
+	LocaleKey:      true,
+	FormKey:        true,
+	BreadcrumbsKey: true,
+}
+
+// Diagnostics inspects the page's templates, so that broken pages can be caught in tests rather than when served.
+func (t *TemplateHandler) Diagnostics() (*Diagnostics, error) {
+	tmpl, input, err := t.current("")
+	if err != nil {
+		return nil, err
+	}
+
+	d := &Diagnostics{
+		Missing:      make(map[string][]string),
+		MissingInput: make(map[string][]string),
+	}
+	uses := make(map[string]*templateUses)
+	for _, c := range tmpl.Templates() {
+		// The unnamed template is the empty root that page templates are parsed into.
+		if c.Name() == "" || c.Name() == internalTemplate || c.Tree == nil || c.Tree.Root == nil {
+			continue
+		}
+		d.Defined = append(d.Defined, c.Name())
+		u := &templateUses{}
+		u.walk(c.Tree.Root, true)
+		uses[c.Name()] = u
+	}
+	sort.Strings(d.Defined)
+
+	for name, u := range uses {
+		for _, call := range u.calls {
+			if _, ok := uses[call.name]; !ok {
+				d.Missing[call.name] = appendOnce(d.Missing[call.name], name)
+			}
+		}
+	}
+
+	// Follow the calls that pass the page's input down from the templates pages are rendered from.
+	seen := make(map[string]bool)
+	queue := []string{"base", textVariant, ampVariant}
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		u, ok := uses[name]
+		if !ok || seen[name] {
+			continue
+		}
+		seen[name] = true
+		for _, key := range u.fields {
+			if _, ok := input[key]; !ok && !reservedInput[key] {
+				d.MissingInput[key] = appendOnce(d.MissingInput[key], name)
+			}
+		}
+		for _, call := range u.calls {
+			if call.root {
+				queue = append(queue, call.name)
+			}
+		}
+	}
+	return d, nil
+}
+
+func appendOnce(list []string, s string) []string {
+	for _, v := range list {
+		if v == s {
+			return list
+		}
+	}
+	list = append(list, s)
+	sort.Strings(list)
+	return list
+}
+
+// templateUses is what one template refers to: the templates it calls, and the input keys it reads from the dot while
+// the dot is still the template's own data.
+type templateUses struct {
+	calls  []templateCall
+	fields []string
+}
+
+type templateCall struct {
+	name string
+	// root is whether the callee is passed the caller's own data.
+	root bool
+}
+
+// walk records the uses in n. root is whether the dot at n is the template's own data.
+func (u *templateUses) walk(n parse.Node, root bool) {
+	switch n := n.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, c := range n.Nodes {
+			u.walk(c, root)
+		}
+	case *parse.ActionNode:
+		u.walk(n.Pipe, root)
+	case *parse.IfNode:
+		u.walk(n.Pipe, root)
+		u.walk(n.List, root)
+		u.walk(n.ElseList, root)
+	case *parse.RangeNode:
+		u.walk(n.Pipe, root)
+		u.walk(n.List, false)
+		u.walk(n.ElseList, root)
+	case *parse.WithNode:
+		u.walk(n.Pipe, root)
+		u.walk(n.List, false)
+		u.walk(n.ElseList, root)
+	case *parse.TemplateNode:
+		u.calls = append(u.calls, templateCall{name: n.Name, root: root && isDot(n.Pipe)})
+		u.walk(n.Pipe, root)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, c := range n.Cmds {
+			u.walk(c, root)
+		}
+	case *parse.CommandNode:
+		// {{ markdown "name" . }} renders another template of the page.
+		if len(n.Args) >= 2 {
+			if id, ok := n.Args[0].(*parse.IdentifierNode); ok && id.Ident == "markdown" {
+				if s, ok := n.Args[1].(*parse.StringNode); ok {
+					call := templateCall{name: s.Text, root: root && len(n.Args) == 3 && isDotNode(n.Args[2])}
+					u.calls = append(u.calls, call)
+				}
+			}
+		}
+		for _, a := range n.Args {
+			u.walk(a, root)
+		}
+	case *parse.FieldNode:
+		if root {
+			u.fields = append(u.fields, n.Ident[0])
+		}
+	case *parse.VariableNode:
+		// $ is the template's own data wherever it's used.
+		if n.Ident[0] == "$" && len(n.Ident) > 1 {
+			u.fields = append(u.fields, n.Ident[1])
+		}
+	case *parse.ChainNode:
+		u.walk(n.Node, root)
+	}
+}
+
+// isDot reports whether pipe is just the dot, or $.
+func isDot(pipe *parse.PipeNode) bool {
+	if pipe == nil || len(pipe.Decl) > 0 || len(pipe.Cmds) != 1 || len(pipe.Cmds[0].Args) != 1 {
+		return false
+	}
+	return isDotNode(pipe.Cmds[0].Args[0])
+}
+
+func isDotNode(n parse.Node) bool {
+	switch n := n.(type) {
+	case *parse.DotNode:
+		return true
+	case *parse.VariableNode:
+		return len(n.Ident) == 1 && n.Ident[0] == "$"
+	}
+	return false
+}
@@ -0,0 +1,61 @@
+package templatehandler
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// WithEnv lets pages' input, including the site configuration and data files, refer to the environment variables
+// names as "${NAME}" in their strings, so that values which differ between deployments, such as an analytics ID, can
+// be set in the deployment rather than in the templates:
+//
+//	AnalyticsID: "${GA_ID}"
+//
+// References are replaced with the variables' values, or "" if they're unset, when pages are built. Referring to any
+// other variable is an error, so that input can't read secrets it wasn't given. Write "$${" for a literal "${".
+// Without WithEnv, strings are left as they are.
+func WithEnv(names ...string) Option {
+	return configure(func(b *Base) {
+		if b.env == nil {
+			b.env = make(map[string]bool)
+		}
+		for _, name := range names {
+			b.env[name] = true
+		}
+	})
+}
+
+// expandEnv replaces the references to environment variables in s, if the base allows any.
+func (b *Base) expandEnv(s string) (string, error) {
+	if b.env == nil || !strings.Contains(s, "${") {
+		return s, nil
+	}
+	in := s
+	var out strings.Builder
+	for {
+		i := strings.Index(s, "${")
+		if i < 0 {
+			break
+		}
+		if i > 0 && s[i-1] == '$' {
+			out.WriteString(s[:i])
+			out.WriteString("{")
+			s = s[i+2:]
+			continue
+		}
+		end := strings.IndexByte(s[i+2:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("templatehandler: unterminated ${ in %q", in)
+		}
+		name := s[i+2 : i+2+end]
+		if !b.env[name] {
+			return "", fmt.Errorf("templatehandler: %q refers to environment variable %q, which WithEnv doesn't allow", in, name)
+		}
+		out.WriteString(s[:i])
+		out.WriteString(os.Getenv(name))
+		s = s[i+3+end:]
+	}
+	out.WriteString(s)
+	return out.String(), nil
+}
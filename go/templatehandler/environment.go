@@ -0,0 +1,61 @@
+package templatehandler
+
+import (
+	"os"
+	"strings"
+)
+
+// EnvKey is the input key under which pages find the Environment the site is running in, given by WithEnvironment,
+// so that templates can render things only in some deployments, e.g. {{ if not isProd }}{{ .Env.Version }}{{ end }}.
+const EnvKey = "Env"
+
+// The names of the environments a site runs in.
+const (
+	EnvDev     = "dev"
+	EnvStaging = "staging"
+	EnvProd    = "prod"
+)
+
+// Environment describes the deployment a site is running in.
+type Environment struct {
+	// Name is EnvDev, EnvStaging or EnvProd.
+	Name string
+
+	// Service and Version are the App Engine service and version serving the site, if it runs on App Engine.
+	Service string
+	Version string
+}
+
+// DetectEnvironment returns the Environment of an App Engine app from the variables App Engine sets: dev under the
+// development server or outside App Engine, prod for the default service, and staging for any other service.
+func DetectEnvironment() Environment {
+	e := Environment{Service: os.Getenv("GAE_SERVICE"), Version: os.Getenv("GAE_VERSION")}
+	if e.Service == "" {
+		// The first generation runtimes call services modules, and add a deployment ID to the version.
+		e.Service = os.Getenv("CURRENT_MODULE_ID")
+		e.Version, _, _ = strings.Cut(os.Getenv("CURRENT_VERSION_ID"), ".")
+	}
+	switch {
+	case os.Getenv("RUN_WITH_DEVAPPSERVER") != "" || e.Service == "":
+		e.Name = EnvDev
+	case e.Service == "default":
+		e.Name = EnvProd
+	default:
+		e.Name = EnvStaging
+	}
+	return e
+}
+
+// WithEnvironment adds e to the base's input under EnvKey, and makes the "isProd" func report whether it's EnvProd.
+// Without it, pages have no Env and isProd is always false.
+func WithEnvironment(e Environment) Option {
+	return configure(func(b *Base) {
+		b.environment = e
+		b.loaders = append(b.loaders, namedLoader{EnvKey, func() (interface{}, error) { return e, nil }})
+	})
+}
+
+// isProd is the "isProd" func.
+func (b *Base) isProd() bool {
+	return b.environment.Name == EnvProd
+}
@@ -0,0 +1,55 @@
+package templatehandler
+
+import (
+	"log"
+	"net/http"
+)
+
+// ErrorHandler is called when a handler fails to render its page. It is responsible for writing the response.
+type ErrorHandler func(w http.ResponseWriter, r *http.Request, err error)
+
+// DefaultErrorHandler logs the failure and responds with a plain 500.
+func DefaultErrorHandler(w http.ResponseWriter, r *http.Request, err error) {
+	logError(r, err)
+	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+}
+
+// ErrorPage returns an ErrorHandler that logs the failure and responds with page rendered with a 500 status. The page
+// receives "Status" and "StatusText" in its input; the error itself is only logged, never shown to the visitor. If
+// the error page fails to render too, a plain 500 is sent instead.
+func ErrorPage(page *TemplateHandler) ErrorHandler {
+	return func(w http.ResponseWriter, r *http.Request, err error) {
+		logError(r, err)
+		b, perr := page.render(w, r, map[string]interface{}{
+			"Status":     http.StatusInternalServerError,
+			"StatusText": http.StatusText(http.StatusInternalServerError),
+		})
+		if perr != nil {
+			logError(r, perr)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(withNonce(r, b))
+	}
+}
+
+func logError(r *http.Request, err error) {
+	log.Printf("templatehandler: render failed method=%s path=%q error=%q", r.Method, r.URL.Path, err)
+}
+
+// handleError passes err to the handler's ErrorHandler, falling back to the Base's and then to DefaultErrorHandler, and
+// records it for the AccessLog.
+func (t *TemplateHandler) handleError(w http.ResponseWriter, r *http.Request, err error) {
+	if a, ok := w.(*accessWriter); ok {
+		a.err = err
+	}
+	switch {
+	case t.ErrorHandler != nil:
+		t.ErrorHandler(w, r, err)
+	case t.base.ErrorHandler != nil:
+		t.base.ErrorHandler(w, r, err)
+	default:
+		DefaultErrorHandler(w, r, err)
+	}
+}
@@ -0,0 +1,95 @@
+package templatehandler
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// eventsHandler streams a named template of the page as Server-Sent Events, rendering it again every interval.
+type eventsHandler struct {
+	t        *TemplateHandler
+	name     string
+	interval time.Duration
+	f        func(w http.ResponseWriter, r *http.Request) map[string]interface{}
+}
+
+// Events returns a handler that streams the named template, e.g. "counter", as Server-Sent Events named after it. The
+// template is rendered with the input f returns, as by a dynamic handler, as soon as a client connects and then every
+// interval until it disconnects. An event is only sent when the rendered template has changed. Listen for it with
+//
+//	new EventSource("/counter").addEventListener("counter", e => { el.innerHTML = e.data })
+//
+// Responses are streamed, so don't serve Events behind a handler that buffers them, such as http.TimeoutHandler.
+func (t *TemplateHandler) Events(name string, interval time.Duration, f func(w http.ResponseWriter, r *http.Request) map[string]interface{}) http.Handler {
+	return &eventsHandler{
+		t:        t,
+		name:     name,
+		interval: interval,
+		f:        f,
+	}
+}
+
+func (e *eventsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rc := http.NewResponseController(w)
+	locale := e.t.locale(r)
+	render := func() ([]byte, error) {
+		b, err := e.t.renderTemplate(locale, e.name, withRequest(e.f(w, r), r))
+		return withNonce(r, b), err
+	}
+
+	// Render once before starting the stream, so that a broken template still gets an error response.
+	b, err := render()
+	if err != nil {
+		e.t.handleError(w, r, err)
+		return
+	}
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	// Keep proxies such as nginx from buffering the stream.
+	h.Set("X-Accel-Buffering", "no")
+	e.t.varyLocale(w)
+
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+	var last []byte
+	for {
+		if !bytes.Equal(b, last) {
+			if err := writeEvent(w, e.name, b); err != nil {
+				return
+			}
+			if err := rc.Flush(); err != nil {
+				logError(r, fmt.Errorf("events can't be streamed: %v", err))
+				return
+			}
+			last = b
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+		if b, err = render(); err != nil {
+			// The stream has started, so the error can only be logged. The client reconnects on its own.
+			logError(r, err)
+			return
+		}
+	}
+}
+
+// writeEvent writes data as a single event named name, one data field per line.
+func writeEvent(w http.ResponseWriter, name string, data []byte) error {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "event: %s\n", name)
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		buf.WriteString("data: ")
+		buf.Write(bytes.TrimSuffix(line, []byte("\r")))
+		buf.WriteByte('\n')
+	}
+	buf.WriteByte('\n')
+	_, err := w.Write(buf.Bytes())
+	return err
+}
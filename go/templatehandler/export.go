@@ -0,0 +1,110 @@
+package templatehandler
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// RenderToFile renders the page with its own input, as a static handler would serve it, and writes it to name.
+func (t *TemplateHandler) RenderToFile(name string) error {
+	b, err := t.Render(nil)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(name, b, 0644)
+}
+
+// Export writes a static copy of the site served by h to dir, so that it can be deployed to a plain file host. It
+// requests each of paths ("/" if none are given), and every page on the same site that they link to with <a href>,
+// in turn. A page at /about is written to dir/about/index.html, and one whose path has an extension, such as
+// /feed.xml, to that path. Pages that don't respond with a 200 are skipped.
+func Export(h http.Handler, dir string, paths ...string) error {
+	if len(paths) == 0 {
+		paths = []string{"/"}
+	}
+
+	seen := make(map[string]bool)
+	queue := append([]string(nil), paths...)
+	for len(queue) > 0 {
+		p := queue[0]
+		queue = queue[1:]
+		if seen[p] {
+			continue
+		}
+		seen[p] = true
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", p, nil))
+		if rec.Code != http.StatusOK {
+			continue
+		}
+
+		name := filepath.Join(dir, filepath.FromSlash(p))
+		if path.Ext(p) == "" {
+			name = filepath.Join(name, "index.html")
+		}
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(name, rec.Body.Bytes(), 0644); err != nil {
+			return err
+		}
+
+		if t, _, _ := mime.ParseMediaType(rec.Header().Get("Content-Type")); t == "text/html" {
+			links, err := localLinks(p, rec.Body.Bytes())
+			if err != nil {
+				return fmt.Errorf("could not read links from %q: %v", p, err)
+			}
+			queue = append(queue, links...)
+		}
+	}
+	return nil
+}
+
+// localLinks returns the paths of the pages on the same site that the page at p links to.
+func localLinks(p string, page []byte) ([]string, error) {
+	base := &url.URL{Path: p}
+	var links []string
+	z := html.NewTokenizer(bytes.NewReader(page))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			if z.Err() == io.EOF {
+				return links, nil
+			}
+			return nil, z.Err()
+		case html.StartTagToken, html.SelfClosingTagToken:
+			tok := z.Token()
+			if tok.DataAtom != atom.A {
+				continue
+			}
+			for _, a := range tok.Attr {
+				if a.Key != "href" {
+					continue
+				}
+				u, err := url.Parse(a.Val)
+				if err != nil || u.Scheme != "" || u.Host != "" {
+					continue
+				}
+				if l := base.ResolveReference(u).Path; strings.HasPrefix(l, "/") {
+					links = append(links, l)
+				}
+			}
+		}
+	}
+}
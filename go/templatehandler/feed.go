@@ -0,0 +1,127 @@
+package templatehandler
+
+import (
+	"encoding/xml"
+	"html/template"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+)
+
+// FeedEntry is an item in a feed served by Feed.
+type FeedEntry struct {
+	Title string
+
+	// URL is where the entry can be read. A URL relative to the site, such as "/about", is made absolute with the host
+	// the feed is requested from. It is also the entry's id, so it must not change.
+	URL string
+
+	Updated time.Time
+
+	// Summary is a short plain text description of the entry, and Content, if set, its full content as HTML.
+	Summary string
+	Content template.HTML
+}
+
+// Feed returns a handler that serves the entries returned by entries, newest first, as an Atom feed titled title
+// and written by author, so that feed readers can follow what the site adds. Entries is called for every request.
+func Feed(title, author string, entries func() ([]FeedEntry, error)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		es, err := entries()
+		if err != nil {
+			DefaultErrorHandler(w, r, err)
+			return
+		}
+		b, err := renderFeed(r, title, author, es)
+		if err != nil {
+			DefaultErrorHandler(w, r, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+		w.Header().Set("Cache-Control", CachePolicy{MaxAge: time.Hour}.String())
+		writeCompressed(w, r, b)
+	})
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Author  atomAuthor  `xml:"author"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomText struct {
+	Type string `xml:"type,attr,omitempty"`
+	Body string `xml:",chardata"`
+}
+
+type atomEntry struct {
+	Title   string    `xml:"title"`
+	ID      string    `xml:"id"`
+	Updated string    `xml:"updated"`
+	Link    atomLink  `xml:"link"`
+	Summary *atomText `xml:"summary,omitempty"`
+	Content *atomText `xml:"content,omitempty"`
+}
+
+// renderFeed renders the Atom feed of entries served for r.
+func renderFeed(r *http.Request, title, author string, entries []FeedEntry) ([]byte, error) {
+	self := &url.URL{Scheme: scheme(r), Host: r.Host, Path: r.URL.Path}
+	entries = append([]FeedEntry(nil), entries...)
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Updated.After(entries[j].Updated) })
+
+	// A feed with no entries has never been updated, as far as readers can tell.
+	updated := time.Unix(0, 0)
+	if len(entries) > 0 {
+		updated = entries[0].Updated
+	}
+	f := atomFeed{
+		Title:   title,
+		ID:      self.String(),
+		Updated: updated.UTC().Format(time.RFC3339),
+		Links: []atomLink{
+			{Rel: "self", Href: self.String()},
+			{Href: self.ResolveReference(&url.URL{Path: "/"}).String()},
+		},
+		Author: atomAuthor{Name: author},
+	}
+	for _, e := range entries {
+		u, err := url.Parse(e.URL)
+		if err != nil {
+			return nil, err
+		}
+		link := self.ResolveReference(u).String()
+		ae := atomEntry{
+			Title:   e.Title,
+			ID:      link,
+			Updated: e.Updated.UTC().Format(time.RFC3339),
+			Link:    atomLink{Href: link},
+		}
+		if e.Summary != "" {
+			ae.Summary = &atomText{Body: e.Summary}
+		}
+		if e.Content != "" {
+			ae.Content = &atomText{Type: "html", Body: string(e.Content)}
+		}
+		f.Entries = append(f.Entries, ae)
+	}
+
+	b, err := xml.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), b...), nil
+}
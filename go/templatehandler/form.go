@@ -0,0 +1,243 @@
+package templatehandler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"unicode/utf8"
+)
+
+// FormKey is the input key under which pages served by a form handler find the submitted form, so that templates can
+// use e.g. {{ .Form.Values.name }} and {{ with .Form.Errors.name }}{{ . }}{{ end }}.
+const FormKey = "Form"
+
+// FormState is a submitted form given to a page under FormKey.
+type FormState struct {
+	Values map[string]string
+	Errors FormErrors
+}
+
+// FormErrors maps the names of invalid fields to what is wrong with them.
+type FormErrors map[string]string
+
+// Validator checks the value of a form field, returning an error describing the problem if it is invalid.
+type Validator func(value string) error
+
+// Rules are the validators for each field of a form, run in order until one fails.
+type Rules map[string][]Validator
+
+// Required fails for empty values. The other validators let empty values through, so that fields are optional unless
+// they are Required.
+func Required() Validator {
+	return func(v string) error {
+		if v == "" {
+			return errors.New("is required")
+		}
+		return nil
+	}
+}
+
+// MaxLength fails for values longer than n characters.
+func MaxLength(n int) Validator {
+	return func(v string) error {
+		if utf8.RuneCountInString(v) > n {
+			return fmt.Errorf("must be at most %d characters", n)
+		}
+		return nil
+	}
+}
+
+// Matches fails for values that don't match re, with msg as the error.
+func Matches(re *regexp.Regexp, msg string) Validator {
+	return func(v string) error {
+		if v != "" && !re.MatchString(v) {
+			return errors.New(msg)
+		}
+		return nil
+	}
+}
+
+// MaxFormBytes is the largest body ParseForm reads.
+var MaxFormBytes int64 = 1 << 20
+
+// ParseForm reads the fields of r's body, either a form or a JSON object, and checks them against rules. The errors
+// are nil if every field is valid; err is only set if the body couldn't be read, and is an *http.MaxBytesError if it
+// was longer than MaxFormBytes.
+func ParseForm(r *http.Request, rules Rules) (values map[string]string, errs FormErrors, err error) {
+	r.Body = http.MaxBytesReader(nil, r.Body, MaxFormBytes)
+	values = make(map[string]string)
+	if isJSON(r) {
+		in := make(map[string]interface{})
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			return nil, nil, fmt.Errorf("could not parse JSON form: %w", err)
+		}
+		for k, v := range in {
+			if v != nil {
+				values[k] = fmt.Sprint(v)
+			}
+		}
+	} else {
+		if err := r.ParseForm(); err != nil {
+			return nil, nil, err
+		}
+		for k := range r.PostForm {
+			values[k] = r.PostForm.Get(k)
+		}
+	}
+
+	for name, validators := range rules {
+		for _, v := range validators {
+			if err := v(values[name]); err != nil {
+				if errs == nil {
+					errs = make(FormErrors)
+				}
+				errs[name] = err.Error()
+				break
+			}
+		}
+	}
+	return values, errs, nil
+}
+
+func isJSON(r *http.Request) bool {
+	t, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return t == "application/json"
+}
+
+// DecodeForm copies values into the struct dst points to. Fields are matched by their `form` tag, or by name if they
+// have none, and may be strings, bools, ints, uints or floats.
+func DecodeForm(values map[string]string, dst interface{}) error {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return errors.New("templatehandler: DecodeForm needs a pointer to a struct")
+	}
+	v = v.Elem()
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Type().Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		name := f.Tag.Get("form")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		s, ok := values[name]
+		if !ok || s == "" {
+			continue
+		}
+
+		fv := v.Field(i)
+		switch fv.Kind() {
+		case reflect.String:
+			fv.SetString(s)
+		case reflect.Bool:
+			b, err := strconv.ParseBool(s)
+			if err != nil {
+				return fmt.Errorf("field %q: %v", name, err)
+			}
+			fv.SetBool(b)
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			n, err := strconv.ParseInt(s, 10, fv.Type().Bits())
+			if err != nil {
+				return fmt.Errorf("field %q: %v", name, err)
+			}
+			fv.SetInt(n)
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			n, err := strconv.ParseUint(s, 10, fv.Type().Bits())
+			if err != nil {
+				return fmt.Errorf("field %q: %v", name, err)
+			}
+			fv.SetUint(n)
+		case reflect.Float32, reflect.Float64:
+			n, err := strconv.ParseFloat(s, fv.Type().Bits())
+			if err != nil {
+				return fmt.Errorf("field %q: %v", name, err)
+			}
+			fv.SetFloat(n)
+		default:
+			return fmt.Errorf("field %q: unsupported type %s", name, fv.Type())
+		}
+	}
+	return nil
+}
+
+// formHandler renders a page with a form on GET, and handles its submission on POST. Like dynamicHandler it renders on
+// every request, and the input includes the request under RequestKey.
+type formHandler struct {
+	headerHooks
+	methodSet
+
+	t      *TemplateHandler
+	rules  Rules
+	submit func(w http.ResponseWriter, r *http.Request, values map[string]string)
+}
+
+func (f *formHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !f.allow(w, r) {
+		return
+	}
+	state := &FormState{Values: make(map[string]string)}
+	code := http.StatusOK
+
+	if r.Method == http.MethodPost {
+		values, errs, err := ParseForm(r, f.rules)
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if errs == nil {
+			f.submit(w, r, values)
+			return
+		}
+		if isJSON(r) {
+			b, err := json.Marshal(map[string]interface{}{"errors": errs})
+			if err != nil {
+				f.t.handleError(w, r, err)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			w.Write(b)
+			return
+		}
+		state = &FormState{Values: values, Errors: errs}
+		code = http.StatusUnprocessableEntity
+	}
+
+	f.t.varyLocale(w)
+	f.t.writePreloads(w, r)
+	b, err := f.t.render(w, r, withRequest(map[string]interface{}{FormKey: state}, r))
+	if err != nil {
+		f.t.handleError(w, r, err)
+		return
+	}
+	f.applyHeaders(w.Header())
+	writeCompressedStatus(w, r, code, b)
+}
+
+// Form returns a handler for a page with a form. GET requests render the page; POST requests have their form or JSON
+// body checked against rules, and if every field is valid are passed on to submit, which writes the response (usually a
+// redirect). Otherwise the page is rendered again with a 422 status, the submitted values and their errors, or for a
+// JSON body, the errors are sent back as JSON. Bodies longer than MaxFormBytes get a 413.
+func (t *TemplateHandler) Form(rules Rules, submit func(w http.ResponseWriter, r *http.Request, values map[string]string)) *formHandler {
+	return &formHandler{
+		headerHooks: headerHooks{defaultCache: DefaultDynamicCache},
+		methodSet:   methodSet{defaultMethods: []string{http.MethodGet, http.MethodPost}},
+		t:           t,
+		rules:       rules,
+		submit:      submit,
+	}
+}
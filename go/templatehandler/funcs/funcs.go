@@ -0,0 +1,211 @@
+// Package funcs is a small library of template helpers. Every Base includes them, so page templates can use them
+// without registering anything; funcs a Base is given with WithFuncs take precedence over these.
+//
+// The helpers take the value they act on last, so that they read well in pipelines:
+//
+//	{{ .Title | lower | slugify }}
+//	{{ .Summary | truncate 140 }}
+//	{{ .Author | default "Anonymous" }}
+//	{{ .Published | date "January 2, 2006" }}
+package funcs
+
+import (
+	"fmt"
+	"html/template"
+	"reflect"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+)
+
+// Map returns the helpers, keyed by the name templates call them by.
+func Map() template.FuncMap {
+	return template.FuncMap{
+		"upper":     strings.ToUpper,
+		"lower":     strings.ToLower,
+		"title":     Title,
+		"slugify":   Slugify,
+		"default":   Default,
+		"dict":      Dict,
+		"join":      Join,
+		"truncate":  Truncate,
+		"safeCSS":   SafeCSS,
+		"safeJS":    SafeJS,
+		"plainText": PlainText,
+		"now":       time.Now,
+		"date":      Date,
+	}
+}
+
+// Title upper-cases the first letter of each word in s.
+func Title(s string) string {
+	var b strings.Builder
+	start := true
+	for _, r := range s {
+		if start {
+			r = unicode.ToTitle(r)
+		}
+		b.WriteRune(r)
+		start = unicode.IsSpace(r) || r == '-'
+	}
+	return b.String()
+}
+
+// Slugify turns s into a lower-case string of letters, digits and dashes, suitable for a URL path or an element id.
+func Slugify(s string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(s) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+			continue
+		}
+		dash = true
+	}
+	return b.String()
+}
+
+// Default returns v, or def if v is empty: missing, false, zero, or an empty string, slice or map.
+func Default(def, v interface{}) interface{} {
+	if isEmpty(v) {
+		return def
+	}
+	return v
+}
+
+func isEmpty(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		return rv.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return rv.IsNil()
+	}
+	return rv.IsZero()
+}
+
+// Dict builds a map from alternating keys and values, to pass more than one value to a template:
+//
+//	{{ template "card" dict "Title" .Title "Href" "/about" }}
+func Dict(pairs ...interface{}) (map[string]interface{}, error) {
+	if len(pairs)%2 != 0 {
+		return nil, fmt.Errorf("dict: odd number of arguments %d", len(pairs))
+	}
+	m := make(map[string]interface{}, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		k, ok := pairs[i].(string)
+		if !ok {
+			return nil, fmt.Errorf("dict: key %v is a %T, not a string", pairs[i], pairs[i])
+		}
+		m[k] = pairs[i+1]
+	}
+	return m, nil
+}
+
+// Join joins the elements of list, which may be a slice of any type, with sep between them.
+func Join(sep string, list interface{}) (string, error) {
+	if list == nil {
+		return "", nil
+	}
+	rv := reflect.ValueOf(list)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return "", fmt.Errorf("join: %T is not a list", list)
+	}
+	parts := make([]string, rv.Len())
+	for i := range parts {
+		parts[i] = fmt.Sprint(rv.Index(i).Interface())
+	}
+	return strings.Join(parts, sep), nil
+}
+
+// Truncate shortens s to at most n characters, ending it with an ellipsis if anything was cut. It prefers to cut at
+// a space, so that words aren't split.
+func Truncate(n int, s string) string {
+	if n <= 0 || utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	runes := []rune(s)
+	cut := string(runes[:n-1])
+	if i := strings.LastIndexFunc(cut, unicode.IsSpace); i > len(cut)/2 {
+		cut = cut[:i]
+	}
+	return strings.TrimRightFunc(cut, unicode.IsSpace) + "…"
+}
+
+// SafeCSS marks s as trusted CSS, so that it isn't escaped. Only use it with CSS the site itself provides.
+func SafeCSS(s string) template.CSS {
+	return template.CSS(s)
+}
+
+// SafeJS marks s as a trusted JavaScript expression, so that it isn't escaped. Only use it with scripts the site
+// itself provides.
+func SafeJS(s string) template.JS {
+	return template.JS(s)
+}
+
+// PlainText returns the text of s, an HTML fragment such as a template.HTML, without its tags and with its entities
+// decoded, for a page's plain text variant: {{ .Summary | plainText }}.
+func PlainText(s interface{}) string {
+	var b strings.Builder
+	z := html.NewTokenizer(strings.NewReader(fmt.Sprint(s)))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return b.String()
+		case html.TextToken:
+			b.Write(z.Text())
+		}
+	}
+}
+
+// dateLayouts are the layouts Time tries, in order, when given a string.
+var dateLayouts = []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02"}
+
+// Date formats v, converted by Time, with layout, as time.Time.Format does. A nil *time.Time formats as "".
+func Date(layout string, v interface{}) (string, error) {
+	if p, ok := v.(*time.Time); ok && p == nil {
+		return "", nil
+	}
+	t, err := Time(v)
+	if err != nil {
+		return "", fmt.Errorf("date: %v", err)
+	}
+	return t.Format(layout), nil
+}
+
+// Time converts v to a time.Time. Besides a time.Time, v may be a string in RFC 3339 or YYYY-MM-DD form, as a page's
+// input provides, or a number of seconds since the Unix epoch.
+func Time(v interface{}) (time.Time, error) {
+	switch v := v.(type) {
+	case time.Time:
+		return v, nil
+	case *time.Time:
+		if v != nil {
+			return *v, nil
+		}
+	case string:
+		for _, l := range dateLayouts {
+			if t, err := time.Parse(l, v); err == nil {
+				return t, nil
+			}
+		}
+		return time.Time{}, fmt.Errorf("can't parse %q as a date", v)
+	case int:
+		return time.Unix(int64(v), 0), nil
+	case int64:
+		return time.Unix(v, 0), nil
+	case float64:
+		return time.Unix(int64(v), 0), nil
+	}
+	return time.Time{}, fmt.Errorf("%T is not a date", v)
+}
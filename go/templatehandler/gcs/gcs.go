@@ -0,0 +1,391 @@
+// Package gcs serves a site's templates and data from a Google Cloud Storage bucket, so that content can be changed by
+// uploading files to the bucket rather than deploying the site again.
+//
+// A Bucket is an fs.FS over a copy of the bucket's objects kept in memory. Give it to templatehandler.NewBaseFS and
+// NewFS, and load data files from it with templatehandler.DataFileFS or {"$file": ...} references. It is a
+// templatehandler.Refresher, so Base.Reload, such as from templatehandler.ReloadHandler, fetches the latest objects
+// before re-reading the templates; only those that changed since the last Refresh are downloaded again.
+//
+// It speaks the Cloud Storage JSON API, and only the calls listing and downloading objects need.
+package gcs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mconbere/quitlikeapro/go/templatehandler"
+)
+
+// DefaultEndpoint is the address of the Cloud Storage JSON API.
+const DefaultEndpoint = "https://storage.googleapis.com/"
+
+// metadataTokenURL is where the metadata server hands out access tokens for the service account App Engine runs as.
+const metadataTokenURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+
+// errNotRefreshed is returned by Open before the first Refresh.
+var errNotRefreshed = errors.New("gcs: bucket has not been refreshed")
+
+// Bucket is the objects in the Cloud Storage bucket Name, as they were at the last Refresh.
+type Bucket struct {
+	// Name is the bucket's name, such as quitlikeapro-content.
+	Name string
+
+	// Prefix, if set, limits the files to the objects whose names start with it, such as "site/", and is cut from
+	// their names.
+	Prefix string
+
+	// Endpoint is the API's address, such as http://localhost:4443/ for an emulator. If empty, DefaultEndpoint.
+	Endpoint string
+
+	// Token returns the OAuth2 access token to call the API with. If nil, calls aren't authenticated, which only works
+	// for public buckets and emulators.
+	Token func(ctx context.Context) (string, error)
+
+	// Client makes the calls. If nil, http.DefaultClient.
+	Client *http.Client
+
+	// refreshMu is held while refreshing, so that only one Refresh lists and downloads at a time.
+	refreshMu sync.Mutex
+
+	mu    sync.RWMutex
+	files map[string]*object
+}
+
+var (
+	_ fs.FS                     = (*Bucket)(nil)
+	_ templatehandler.Refresher = (*Bucket)(nil)
+)
+
+// New returns the objects under prefix in the bucket name, authenticated as the service account App Engine runs as.
+// Call Refresh before reading from it.
+func New(name, prefix string) *Bucket {
+	return &Bucket{Name: name, Prefix: prefix, Token: (&metadataToken{}).get}
+}
+
+// NewURL is like New, but for the objects at a gs:// URL, such as gs://quitlikeapro-content/site/, whose path is the
+// prefix.
+func NewURL(rawurl string) (*Bucket, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "gs" || u.Host == "" {
+		return nil, fmt.Errorf("gcs: %q isn't a gs://bucket/prefix URL", rawurl)
+	}
+	return New(u.Host, strings.TrimPrefix(u.Path, "/")), nil
+}
+
+// object is a downloaded object.
+type object struct {
+	generation string
+	updated    time.Time
+	data       []byte
+}
+
+// listing is a page of the response to listing a bucket's objects.
+type listing struct {
+	Items []struct {
+		Name       string    `json:"name"`
+		Generation string    `json:"generation"`
+		Updated    time.Time `json:"updated"`
+	} `json:"items"`
+	NextPageToken string `json:"nextPageToken"`
+}
+
+// Refresh lists the bucket's objects and downloads those that are new or have changed since the last Refresh, then
+// swaps them in as the bucket's files. Objects whose names end in a slash, which the console creates as folders, are
+// left out. If it fails, the files are left as they were.
+func (b *Bucket) Refresh(ctx context.Context) error {
+	b.refreshMu.Lock()
+	defer b.refreshMu.Unlock()
+	b.mu.RLock()
+	old := b.files
+	b.mu.RUnlock()
+
+	files := make(map[string]*object)
+	token := ""
+	for {
+		q := url.Values{"prefix": {b.Prefix}, "fields": {"items(name,generation,updated),nextPageToken"}}
+		if token != "" {
+			q.Set("pageToken", token)
+		}
+		var l listing
+		if err := b.get(ctx, "storage/v1/b/"+url.PathEscape(b.Name)+"/o?"+q.Encode(), func(r io.Reader) error {
+			return json.NewDecoder(r).Decode(&l)
+		}); err != nil {
+			return fmt.Errorf("gcs: listing %q: %v", b.Name, err)
+		}
+		for _, item := range l.Items {
+			name := strings.TrimPrefix(item.Name, b.Prefix)
+			if strings.HasSuffix(name, "/") || !fs.ValidPath(name) || name == "." {
+				continue
+			}
+			if o, ok := old[name]; ok && o.generation == item.Generation {
+				files[name] = o
+				continue
+			}
+			o := &object{generation: item.Generation, updated: item.Updated}
+			obj := "storage/v1/b/" + url.PathEscape(b.Name) + "/o/" + url.PathEscape(item.Name) + "?" +
+				url.Values{"alt": {"media"}, "generation": {item.Generation}}.Encode()
+			if err := b.get(ctx, obj, func(r io.Reader) error {
+				var err error
+				o.data, err = io.ReadAll(r)
+				return err
+			}); err != nil {
+				return fmt.Errorf("gcs: downloading %q: %v", item.Name, err)
+			}
+			files[name] = o
+		}
+		if token = l.NextPageToken; token == "" {
+			break
+		}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.files = files
+	return nil
+}
+
+// get makes a GET request to the API for the resource at name, and reads the response body with read.
+func (b *Bucket) get(ctx context.Context, name string, read func(io.Reader) error) error {
+	endpoint := b.Endpoint
+	if endpoint == "" {
+		endpoint = DefaultEndpoint
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint+name, nil)
+	if err != nil {
+		return err
+	}
+	if b.Token != nil {
+		token, err := b.Token(ctx)
+		if err != nil {
+			return fmt.Errorf("could not get an access token: %v", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	client := b.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return read(resp.Body)
+}
+
+// Open opens the file or directory name, from the objects as they were at the last Refresh. Directories are the
+// prefixes of the objects' names up to a slash.
+func (b *Bucket) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	b.mu.RLock()
+	files := b.files
+	b.mu.RUnlock()
+	if files == nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: errNotRefreshed}
+	}
+
+	if o, ok := files[name]; ok {
+		info := &fileInfo{name: path.Base(name), size: int64(len(o.data)), modTime: o.updated}
+		return &file{info: info, Reader: bytes.NewReader(o.data)}, nil
+	}
+	prefix := name + "/"
+	if name == "." {
+		prefix = ""
+	}
+	children := make(map[string]*fileInfo)
+	for n, o := range files {
+		rest, ok := strings.CutPrefix(n, prefix)
+		if !ok {
+			continue
+		}
+		if child, _, isDir := strings.Cut(rest, "/"); isDir {
+			children[child] = &fileInfo{name: child, dir: true}
+		} else {
+			children[child] = &fileInfo{name: child, size: int64(len(o.data)), modTime: o.updated}
+		}
+	}
+	if len(children) == 0 && name != "." {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	d := &dir{info: &fileInfo{name: path.Base(name), dir: true}}
+	for _, c := range children {
+		d.entries = append(d.entries, fs.FileInfoToDirEntry(c))
+	}
+	sort.Slice(d.entries, func(i, j int) bool { return d.entries[i].Name() < d.entries[j].Name() })
+	return d, nil
+}
+
+// fileInfo describes a file or directory of a Bucket.
+type fileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+func (fi *fileInfo) Name() string       { return fi.name }
+func (fi *fileInfo) Size() int64        { return fi.size }
+func (fi *fileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *fileInfo) IsDir() bool        { return fi.dir }
+func (fi *fileInfo) Sys() interface{}   { return nil }
+
+func (fi *fileInfo) Mode() fs.FileMode {
+	if fi.dir {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
+
+// file is an open object.
+type file struct {
+	info *fileInfo
+	*bytes.Reader
+}
+
+func (f *file) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *file) Close() error               { return nil }
+
+// dir is an open directory, listing the entries below it.
+type dir struct {
+	info    *fileInfo
+	entries []fs.DirEntry
+}
+
+func (d *dir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *dir) Close() error               { return nil }
+
+func (d *dir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.name, Err: errors.New("is a directory")}
+}
+
+func (d *dir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(d.entries) {
+		n = len(d.entries)
+	}
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
+
+// metadataTokenTimeout is how long a metadataToken waits for the metadata server, which is on the instance's own
+// network and so answers quickly when it answers at all.
+const metadataTokenTimeout = 5 * time.Second
+
+// metadataToken gets access tokens from the metadata server, keeping each until shortly before it expires. Once a
+// token is close to expiring a new one is fetched in the background, and the old one returned meanwhile; only calls
+// with no token left to use wait for the fetch.
+type metadataToken struct {
+	mu      sync.Mutex
+	token   string
+	refresh time.Time
+	expires time.Time
+	err     error
+	// fetching is closed when the fetch in flight finishes, and is nil when there's none.
+	fetching chan struct{}
+}
+
+func (m *metadataToken) get(ctx context.Context) (string, error) {
+	m.mu.Lock()
+	now := time.Now()
+	usable := m.token != "" && now.Before(m.expires)
+	if usable && now.Before(m.refresh) {
+		defer m.mu.Unlock()
+		return m.token, nil
+	}
+	if m.fetching == nil {
+		m.fetching = make(chan struct{})
+		go m.fetch(m.fetching)
+	}
+	token, fetching := m.token, m.fetching
+	m.mu.Unlock()
+	if usable {
+		return token, nil
+	}
+
+	select {
+	case <-fetching:
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.token == "" || !time.Now().Before(m.expires) {
+		return "", m.err
+	}
+	return m.token, nil
+}
+
+// fetch fetches a token from the metadata server, and closes done once it's kept. If it fails, the last token is
+// kept for as long as it can still be used.
+func (m *metadataToken) fetch(done chan struct{}) {
+	ctx, cancel := context.WithTimeout(context.Background(), metadataTokenTimeout)
+	defer cancel()
+	token, ttl, err := fetchMetadataToken(ctx)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	if err == nil {
+		// Leave a minute for the calls made with it, and start fetching the next one five minutes before that.
+		m.token, m.expires, m.refresh = token, now.Add(ttl-time.Minute), now.Add(ttl-6*time.Minute)
+	} else {
+		// Try again no more than once a minute while the last token lasts.
+		m.refresh = now.Add(time.Minute)
+	}
+	m.err, m.fetching = err, nil
+	close(done)
+}
+
+// fetchMetadataToken gets an access token from the metadata server, and how long it can be used for.
+func fetchMetadataToken(ctx context.Context) (string, time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, metadataTokenURL, nil)
+	if err != nil {
+		return "", 0, err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", 0, fmt.Errorf("metadata server: %s", resp.Status)
+	}
+	var t struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&t); err != nil {
+		return "", 0, fmt.Errorf("metadata server: %v", err)
+	}
+	return t.AccessToken, time.Duration(t.ExpiresIn) * time.Second, nil
+}
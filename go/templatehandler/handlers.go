@@ -1,53 +1,523 @@
 package templatehandler
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
 	"fmt"
+	"log"
 	"net/http"
+	"strconv"
+	"sync"
+	"time"
 )
 
-// dynamicHandler serves responses based on the http request.
+// dynamicHandler serves responses based on the http request, compressed if the client accepts it. The page's input
+// includes the request itself under RequestKey.
 type dynamicHandler struct {
+	headerHooks
+	methodSet
+
+	// OutputCache, if set, caches the rendered page rather than rendering it for every request. GET and HEAD
+	// requests are served from it; cached pages are rendered in full rather than streamed.
+	OutputCache *OutputCache
+
 	t *TemplateHandler
 	f func(w http.ResponseWriter, r *http.Request) map[string]interface{}
 }
 
 func (d *dynamicHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	b, err := d.t.render(w, r, d.f(w, r))
+	if a := d.t.logAccess(w, r); a != nil {
+		w = a
+		defer a.log()
+	}
+	if !d.allow(w, r) {
+		return
+	}
+	if d.t.ServeJSON {
+		if wantsJSON(r) {
+			d.t.serveJSON(w, r, d.f(w, r))
+			return
+		}
+		addVary(w.Header(), "Accept")
+	}
+
+	d.t.varyLocale(w)
+	d.t.writePreloads(w, r)
+	oc := d.OutputCache
+	if r.Method != "GET" && r.Method != "HEAD" {
+		oc = nil
+	}
+	var key string
+	if oc != nil {
+		var ok bool
+		if key, ok = oc.cached(d, w, r); ok {
+			cacheHit(w)
+			return
+		}
+	}
+
+	input := withRequest(d.f(w, r), r)
+	// A HEAD response needs the length of the whole page, so only GETs are streamed.
+	if d.t.Stream && d.t.variant(r) == "base" && oc == nil && r.Method != "HEAD" {
+		d.t.stream(w, r, input, d.applyHeaders)
+		return
+	}
+
+	b, err := d.t.render(w, r, input)
 	if err != nil {
+		d.t.handleError(w, r, err)
 		return
 	}
-	w.Write(b)
+	if oc != nil {
+		if err := oc.Store.Set(key, b, oc.TTL); err != nil {
+			logError(r, err)
+		}
+		oc.vary(w.Header())
+	}
+	d.applyHeaders(w.Header())
+	writeCompressed(w, r, b)
 }
 
 func (t *TemplateHandler) Dynamic(f func(w http.ResponseWriter, r *http.Request) map[string]interface{}) *dynamicHandler {
 	return &dynamicHandler{
-		t: t,
-		f: f,
+		headerHooks: headerHooks{defaultCache: DefaultDynamicCache},
+		methodSet:   methodSet{defaultMethods: []string{http.MethodGet, http.MethodPost}},
+		t:           t,
+		f:           f,
 	}
 }
 
-// staticHandler serves responses based on a provided map of input (or nil), and caches the response.
+// fragmentHandler serves a single named template from the page, without the base wrapper around it, so that clients
+// can fetch part of a page for partial updates. Like dynamicHandler it renders on every request, and the input
+// includes the request under RequestKey.
+type fragmentHandler struct {
+	t    *TemplateHandler
+	name string
+}
+
+func (f *fragmentHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.t.varyLocale(w)
+	b, err := f.t.renderTemplate(f.t.locale(r), f.name, withRequest(nil, r))
+	if err != nil {
+		f.t.handleError(w, r, err)
+		return
+	}
+	// Fragments are often too short for the content type to be sniffed reliably.
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	writeCompressed(w, r, b)
+}
+
+// Fragment returns a handler that renders just the named template, e.g. "content", with the page's input.
+func (t *TemplateHandler) Fragment(name string) http.Handler {
+	return &fragmentHandler{
+		t:    t,
+		name: name,
+	}
+}
+
+// writeCompressed writes b to w, compressed if the client accepts it.
+func writeCompressed(w http.ResponseWriter, r *http.Request, b []byte) {
+	writeCompressedStatus(w, r, http.StatusOK, b)
+}
+
+// writeCompressedStatus is like writeCompressed, but responds with the given status code.
+func writeCompressedStatus(w http.ResponseWriter, r *http.Request, code int, b []byte) {
+	b = withNonce(r, b)
+	body := b
+	enc := negotiateEncoding(r)
+	if enc != "" {
+		var err error
+		if body, err = compress(enc, b); err != nil {
+			body, enc = b, ""
+		}
+	}
+	setEncodingHeaders(w.Header(), enc, b)
+	writeBody(w, r, code, body)
+}
+
+// writeBody writes the response with its Content-Length, leaving the body out for HEAD requests.
+func writeBody(w http.ResponseWriter, r *http.Request, code int, body []byte) {
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(code)
+	if r.Method != "HEAD" {
+		w.Write(body)
+	}
+}
+
+// staticHandler serves responses based on a provided map of input (or nil), and caches the response, one per locale
+// for translated pages. The response is rendered once, on the first request or by PreRender, however many requests
+// arrive at the same time, and again after Invalidate or once TTL has passed; when live reloading is on it is rendered
+// fresh every time. An expired response is rendered again in the background, and served while that happens.
+//
+// Responses carry an ETag and Last-Modified header, so conditional requests from repeat visitors get a 304. Compressed
+// variants of the response are cached alongside it as clients ask for them.
 type staticHandler struct {
+	headerHooks
+	methodSet
+
 	t *TemplateHandler
 	m map[string]interface{}
-	c []byte
+
+	// renders has an entry for every locale and variant of the page up front, so that it is never written to.
+	renders map[renderKey]*staticRender
+
+	// DisableValidators turns off the ETag and Last-Modified headers, and with them 304 responses.
+	DisableValidators bool
+
+	// TTL is how long a rendered response is served before it is rendered again. If zero, it is kept until Invalidate
+	// is called. Requests arriving once it has passed are served the expired response while one goroutine renders the
+	// next; if that fails, the error is logged and the expired response kept for another TTL.
+	TTL time.Duration
+
+	// Load, if set, is called each time the page is rendered, and the input it returns merged over the handler's, so
+	// that a re-render picks up fresh data. Its context is the request's when a request waits for the render, and has
+	// no deadline otherwise, so Load should set its own for any calls it makes.
+	Load func(ctx context.Context) (map[string]interface{}, error)
+
+	// Store, if set, shares rendered responses between instances of the site: a response that isn't cached locally is
+	// taken from the Store if another instance has rendered it, and stored there when rendered, for TTL. Invalidate
+	// deletes it from the Store too, though other instances keep their local copy until their TTL passes. Responses
+	// are stored under the page's Name, so give each handler of the same page with different input a page of its own.
+	// It isn't used while live reloading.
+	Store Store
 }
 
 func (s *staticHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if s.c == nil {
-		b, err := s.t.render(w, r, s.m)
-		if err != nil {
-			panic(fmt.Errorf("could not render static template: %v", err))
+	if a := s.t.logAccess(w, r); a != nil {
+		w = a
+		defer a.log()
+	}
+	if !s.allow(w, r) {
+		return
+	}
+	if s.t.ServeJSON {
+		if wantsJSON(r) {
+			input, err := s.input(r.Context())
+			if err != nil {
+				s.t.handleError(w, r, err)
+				return
+			}
+			s.t.serveJSON(w, r, input)
+			return
 		}
-		s.c = b
+		addVary(w.Header(), "Accept")
 	}
 
-	w.Write(s.c)
+	s.t.varyLocale(w)
+	s.t.varyVariant(w)
+	s.t.writePreloads(w, r)
+	key := renderKey{locale: s.t.locale(r), variant: s.t.variant(r)}
+	if _, ok := s.renders[key]; !ok {
+		key.locale = ""
+	}
+	if _, ok := s.renders[key]; !ok {
+		// The variant was added to the page by a live reload after the handler was created.
+		key.variant = "base"
+	}
+	c := s.renders[key]
+	hit, err := s.ensureRendered(r.Context(), key)
+	if err != nil {
+		s.t.handleError(w, r, err)
+		return
+	}
+	if hit {
+		cacheHit(w)
+	}
+
+	c.mu.Lock()
+	page, body, etag, modTime := c.c, c.c, c.etag, c.modTime
+	if c.nonce {
+		// The page differs for every request, so neither its compressed copies nor validators can be reused.
+		c.mu.Unlock()
+		setVariantHeaders(w.Header(), key.variant)
+		s.applyHeaders(w.Header())
+		writeCompressed(w, r, page)
+		return
+	}
+	enc := negotiateEncoding(r)
+	if enc != "" {
+		z, ok := c.z[enc]
+		if !ok {
+			var err error
+			if z, err = compress(enc, c.c); err != nil {
+				c.mu.Unlock()
+				s.t.handleError(w, r, fmt.Errorf("could not compress static template: %v", err))
+				return
+			}
+			c.z[enc] = z
+		}
+		body, etag = z, etag+"-"+enc
+	}
+	c.mu.Unlock()
+	setVariantHeaders(w.Header(), key.variant)
+	s.applyHeaders(w.Header())
+	setEncodingHeaders(w.Header(), enc, page)
+
+	if s.DisableValidators {
+		writeBody(w, r, http.StatusOK, body)
+		return
+	}
+	w.Header().Set("ETag", strconv.Quote(etag))
+	if enc != "" {
+		setEncodedLength(w.Header(), r, len(body))
+	}
+	http.ServeContent(w, r, "", modTime, bytes.NewReader(body))
+}
+
+// PreRender renders and caches the page in every locale and variant now, rather than on the first request for it, so that a page
+// that fails to render can be caught at startup.
+func (s *staticHandler) PreRender() error {
+	for key := range s.renders {
+		if _, err := s.ensureRendered(context.Background(), key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Invalidate drops the cached responses, so that the page is rendered again on its next request. A render already
+// under way when it's called is neither kept as current nor stored.
+func (s *staticHandler) Invalidate() {
+	for key, c := range s.renders {
+		c.mu.Lock()
+		c.current = false
+		c.generation++
+		c.mu.Unlock()
+		if s.Store != nil {
+			if err := s.Store.Delete(s.storeKey(key)); err != nil {
+				log.Printf("templatehandler: could not invalidate stored page=%q error=%q", s.t.Name, err)
+			}
+		}
+	}
+}
+
+// ensureRendered makes sure there is a cached response for key to serve, rendering it if it has never been rendered,
+// has been invalidated, or if live reloading is on, and starting to render it again in the background if it has
+// expired. Only one goroutine renders it at a time; others wait for it, for as long as ctx allows, and the render's
+// I/O is done without holding the response's lock. It reports whether the cached response could be used.
+func (s *staticHandler) ensureRendered(ctx context.Context, key renderKey) (hit bool, err error) {
+	c := s.renders[key]
+	for {
+		c.mu.Lock()
+		if c.usable(s, key) {
+			c.mu.Unlock()
+			s.t.staticCache(true)
+			return true, nil
+		}
+		if rendering := c.rendering; rendering != nil {
+			// Another request is rendering the response; it may be usable once that's done.
+			c.mu.Unlock()
+			select {
+			case <-rendering:
+			case <-ctx.Done():
+				return false, ctx.Err()
+			}
+			continue
+		}
+		done, generation := make(chan struct{}), c.generation
+		c.rendering = done
+		c.mu.Unlock()
+
+		s.t.staticCache(false)
+		renderedAt, err := c.update(ctx, s, key, generation)
+
+		c.mu.Lock()
+		if err == nil && c.generation == generation {
+			c.current, c.renderedAt = true, renderedAt
+		}
+		c.rendering = nil
+		close(done)
+		c.mu.Unlock()
+		return false, err
+	}
+}
+
+// usable reports whether the cached response can be served as it is: it has been rendered, live reloading is off, and
+// either it hasn't expired or it has and a goroutine is rendering it again, which it starts if none is. c.mu must be
+// held.
+func (c *staticRender) usable(s *staticHandler, key renderKey) bool {
+	if !c.current || s.t.base.LiveReload {
+		return false
+	}
+	if s.TTL <= 0 || time.Since(c.renderedAt) < s.TTL {
+		return true
+	}
+	if c.rendering == nil {
+		c.rendering = make(chan struct{})
+		go c.refresh(s, key, c.generation, c.rendering)
+	}
+	return true
+}
+
+// refresh renders the expired response for key again, while requests are served the expired one, and closes done
+// when it's finished.
+func (c *staticRender) refresh(s *staticHandler, key renderKey, generation int, done chan struct{}) {
+	renderedAt, err := c.update(context.Background(), s, key, generation)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil {
+		log.Printf("templatehandler: could not render expired page=%q error=%q", s.t.Name, err)
+		renderedAt = time.Now()
+	}
+	if c.current && c.generation == generation {
+		c.renderedAt = renderedAt
+	}
+	c.rendering = nil
+	close(done)
+}
+
+// input returns the handler's input, with the input from Load merged over it.
+func (s *staticHandler) input(ctx context.Context) (map[string]interface{}, error) {
+	if s.Load == nil {
+		return s.m, nil
+	}
+	in, err := s.Load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return mergeMap(s.m, in), nil
+}
+
+// renderKey identifies one of the responses a static handler caches.
+type renderKey struct {
+	locale  string
+	variant string
+}
+
+// staticRender is a cached static response, and the compressed variants of it served so far.
+type staticRender struct {
+	// mu guards the fields below. current and renderedAt say whether the response needs rendering again, and
+	// rendering, when it isn't nil, is closed once the render under way finishes. generation counts the calls to
+	// Invalidate, so that a render started before one isn't taken as current.
+	mu         sync.Mutex
+	current    bool
+	renderedAt time.Time
+	rendering  chan struct{}
+	generation int
+
+	c []byte
+	z map[string][]byte
+
+	etag    string
+	modTime time.Time
+
+	// nonce is whether the response uses {{ cspNonce }}, and so must be served differently to every request.
+	nonce bool
+}
+
+// update renders the response for key, or takes it from the handler's Store, replaces the cached one if it has
+// changed, and returns when it was rendered. It's only stored if Invalidate hasn't been called since generation.
+func (c *staticRender) update(ctx context.Context, s *staticHandler, key renderKey, generation int) (time.Time, error) {
+	useStore := s.Store != nil && !s.t.base.LiveReload
+	if useStore {
+		if b, renderedAt, modTime, ok := s.load(key); ok {
+			c.set(b, modTime)
+			return renderedAt, nil
+		}
+	}
+
+	input, err := s.input(ctx)
+	if err != nil {
+		return time.Time{}, err
+	}
+	b, err := s.t.renderVariant(key.locale, key.variant, input)
+	if err != nil {
+		return time.Time{}, err
+	}
+	now := time.Now()
+	modTime := c.set(b, now)
+	c.mu.Lock()
+	invalidated := c.generation != generation
+	c.mu.Unlock()
+	if useStore && !invalidated {
+		s.save(key, b, now, modTime)
+	}
+	return now, nil
+}
+
+// set replaces the cached response with b if it has changed, as of modTime, and returns the response's modification
+// time.
+func (c *staticRender) set(b []byte, modTime time.Time) time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !bytes.Equal(b, c.c) {
+		sum := sha256.Sum256(b)
+		c.c = b
+		c.nonce = bytes.Contains(b, nonceMarkerBytes)
+		c.z = make(map[string][]byte)
+		c.etag = fmt.Sprintf("%x", sum[:16])
+		c.modTime = modTime
+	}
+	return c.modTime
+}
+
+// storeKey is the key the response for key is kept under in the handler's Store.
+func (s *staticHandler) storeKey(key renderKey) string {
+	sum := sha256.Sum256([]byte(s.t.Name + "\x00" + key.locale + "\x00" + key.variant))
+	return fmt.Sprintf("templatehandler:static:%x", sum)
+}
+
+// load returns the response for key from the handler's Store, with the times it was rendered and last changed.
+// Stored responses start with those times, in Unix nanoseconds, on a line of their own.
+func (s *staticHandler) load(key renderKey) (b []byte, renderedAt, modTime time.Time, ok bool) {
+	v, err := s.Store.Get(s.storeKey(key))
+	if err != nil {
+		if err != ErrCacheMiss {
+			log.Printf("templatehandler: could not load stored page=%q error=%q", s.t.Name, err)
+		}
+		return nil, time.Time{}, time.Time{}, false
+	}
+	header, body, found := bytes.Cut(v, []byte("\n"))
+	var rendered, modified int64
+	if _, err := fmt.Sscanf(string(header), "%d %d", &rendered, &modified); !found || err != nil {
+		return nil, time.Time{}, time.Time{}, false
+	}
+	renderedAt = time.Unix(0, rendered)
+	if s.TTL > 0 && time.Since(renderedAt) >= s.TTL {
+		return nil, time.Time{}, time.Time{}, false
+	}
+	return body, renderedAt, time.Unix(0, modified), true
+}
+
+// save puts the response for key in the handler's Store.
+func (s *staticHandler) save(key renderKey, b []byte, renderedAt, modTime time.Time) {
+	v := append([]byte(fmt.Sprintf("%d %d\n", renderedAt.UnixNano(), modTime.UnixNano())), b...)
+	if err := s.Store.Set(s.storeKey(key), v, s.TTL); err != nil {
+		log.Printf("templatehandler: could not store page=%q error=%q", s.t.Name, err)
+	}
 }
 
 func (t *TemplateHandler) Static(m map[string]interface{}) *staticHandler {
-	return &staticHandler{
-		t: t,
-		m: m,
+	variants := []string{"base"}
+	if tmpl, _, err := t.current(""); err == nil {
+		for _, v := range []string{textVariant, ampVariant} {
+			if tmpl.Lookup(v) != nil {
+				variants = append(variants, v)
+			}
+		}
+	}
+
+	cache := DefaultStaticCache
+	if t.base.LiveReload {
+		cache = CachePolicy{NoCache: true}
+	}
+
+	s := &staticHandler{
+		headerHooks: headerHooks{defaultCache: cache},
+		methodSet:   methodSet{defaultMethods: []string{http.MethodGet}},
+		t:           t,
+		m:           m,
+		renders:     make(map[renderKey]*staticRender),
+	}
+	for _, l := range append([]string{""}, t.base.locales()...) {
+		for _, v := range variants {
+			s.renders[renderKey{locale: l, variant: v}] = &staticRender{}
+		}
 	}
+	t.base.pagesMu.Lock()
+	t.base.statics = append(t.base.statics, s)
+	t.base.pagesMu.Unlock()
+	return s
 }
@@ -0,0 +1,156 @@
+package templatehandler_test
+
+import (
+	"bytes"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/mconbere/quitlikeapro/go/templatehandler"
+	"github.com/mconbere/quitlikeapro/go/templatehandler/templatehandlertest"
+)
+
+// pages are the templates the tests build their handlers from. Each broken page fails partway through its content,
+// after some of the page has been written.
+var pages = fstest.MapFS{
+	"base.html":    {Data: []byte(`{{ define "base" }}<html><body>{{ template "content" . }}</body></html>{{ end }}`)},
+	"missing.html": {Data: []byte(`{{ define "content" }}<p>Before</p>{{ template "missing" . }}{{ end }}`)},
+	"failing.html": {Data: []byte(`{{ define "content" }}<p>Before</p>{{ fail }}{{ end }}`)},
+	"error.html":   {Data: []byte(`{{ define "content" }}<h1>{{ .StatusText }}</h1>{{ end }}`)},
+	"text.html":    {Data: []byte(`{{ define "content" }}<p>Hello</p>{{ end }}{{ define "text" }}Hello{{ end }}`)},
+}
+
+var errFail = errors.New("fail failed")
+
+func newBase(t *testing.T) *templatehandler.Base {
+	t.Helper()
+	base, err := templatehandler.NewBaseFS(pages, "base.html", templatehandler.WithFuncs(template.FuncMap{
+		"fail": func() (string, error) { return "", errFail },
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base
+}
+
+// captureLog returns what's logged for the rest of the test.
+func captureLog(t *testing.T) *bytes.Buffer {
+	var b bytes.Buffer
+	log.SetOutput(&b)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	return &b
+}
+
+// serve requests /page from a dynamic handler for the page built from tmpl, streamed if stream is set.
+func serve(t *testing.T, base *templatehandler.Base, tmpl string, stream bool) *httptest.ResponseRecorder {
+	t.Helper()
+	h, err := templatehandler.NewFS(base, pages, tmpl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Stream = stream
+	w := httptest.NewRecorder()
+	h.Dynamic(func(http.ResponseWriter, *http.Request) map[string]interface{} { return nil }).
+		ServeHTTP(w, httptest.NewRequest("GET", "/page", nil))
+	return w
+}
+
+// assertFailed checks that the response is a 500 with a body, rather than an empty 200 or the broken page, and that
+// the failure was logged with the request's path and want in the error.
+func assertFailed(t *testing.T, w *httptest.ResponseRecorder, logged *bytes.Buffer, want string) {
+	t.Helper()
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+	if w.Body.Len() == 0 {
+		t.Error("response has no body")
+	}
+	if strings.Contains(w.Body.String(), "Before") {
+		t.Errorf("response has the broken page's content: %q", w.Body)
+	}
+	if l := logged.String(); !strings.Contains(l, `path="/page"`) || !strings.Contains(l, want) {
+		t.Errorf("log = %q, want the path and %q", l, want)
+	}
+}
+
+func TestDynamicMissingTemplate(t *testing.T) {
+	logged := captureLog(t)
+	w := serve(t, newBase(t), "missing.html", false)
+	assertFailed(t, w, logged, "missing")
+}
+
+func TestDynamicFuncError(t *testing.T) {
+	logged := captureLog(t)
+	w := serve(t, newBase(t), "failing.html", false)
+	assertFailed(t, w, logged, errFail.Error())
+}
+
+func TestDynamicStreamedFuncError(t *testing.T) {
+	logged := captureLog(t)
+	w := serve(t, newBase(t), "failing.html", true)
+	assertFailed(t, w, logged, errFail.Error())
+}
+
+func TestDynamicErrorPage(t *testing.T) {
+	logged := captureLog(t)
+	base := newBase(t)
+	base.ErrorHandler = templatehandler.ErrorPage(templatehandler.Must(templatehandler.NewFS(base, pages, "error.html")))
+	w := serve(t, base, "failing.html", false)
+	assertFailed(t, w, logged, errFail.Error())
+	templatehandlertest.AssertText(t, w.Body.Bytes(), "h1", http.StatusText(http.StatusInternalServerError))
+}
+
+func TestFormCacheControl(t *testing.T) {
+	form := templatehandler.Must(templatehandler.NewFS(newBase(t), pages, "error.html")).Form(templatehandler.Rules{
+		"name": {templatehandler.Required()},
+	}, func(w http.ResponseWriter, r *http.Request, values map[string]string) {})
+	for _, r := range []*http.Request{
+		httptest.NewRequest("GET", "/", nil),
+		httptest.NewRequest("POST", "/", strings.NewReader("name=")),
+	} {
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		form.ServeHTTP(w, r)
+		if got, want := w.Header().Get("Cache-Control"), templatehandler.DefaultDynamicCache.String(); got != want {
+			t.Errorf("%s: Cache-Control = %q, want %q", r.Method, got, want)
+		}
+	}
+}
+
+func TestNegotiatedHeaders(t *testing.T) {
+	page := templatehandler.Must(templatehandler.NewFS(newBase(t), pages, "text.html"))
+	page.ServeJSON = true
+	for name, h := range map[string]http.Handler{
+		"static":  page.Static(nil),
+		"dynamic": page.Dynamic(func(http.ResponseWriter, *http.Request) map[string]interface{} { return nil }),
+	} {
+		for _, enc := range []string{"br", "gzip", "identity"} {
+			responses := map[string]*httptest.ResponseRecorder{}
+			for _, method := range []string{"GET", "HEAD"} {
+				r := httptest.NewRequest(method, "/page", nil)
+				r.Header.Set("Accept-Encoding", enc)
+				w := httptest.NewRecorder()
+				h.ServeHTTP(w, r)
+				responses[method] = w
+			}
+			get, head := responses["GET"], responses["HEAD"]
+			if got, want := head.Header().Get("Content-Length"), strconv.Itoa(get.Body.Len()); got != want {
+				t.Errorf("%s %s: HEAD Content-Length = %q, want the GET body's %s", name, enc, got, want)
+			}
+			seen := map[string]bool{}
+			for _, v := range get.Header().Values("Vary") {
+				if seen[v] {
+					t.Errorf("%s %s: Vary lists %q more than once: %q", name, enc, v, get.Header().Values("Vary"))
+				}
+				seen[v] = true
+			}
+		}
+	}
+}
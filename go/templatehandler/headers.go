@@ -0,0 +1,57 @@
+package templatehandler
+
+import (
+	"net/http"
+	"strings"
+)
+
+// headerHooks let a handler's users set response headers, such as Cache-Control or Content-Language, without wrapping
+// it. They only apply to successful responses, not to errors.
+type headerHooks struct {
+	// Cache is the policy sent as the Cache-Control header. If nil, the handler's default is used: DefaultStaticCache
+	// for static handlers (or no-cache with live reloading on) and DefaultDynamicCache for dynamic ones and forms.
+	Cache *CachePolicy
+
+	defaultCache CachePolicy
+
+	// Headers are set on the response, replacing any of the same name the handler set itself.
+	Headers map[string]string
+
+	// BeforeWrite, if set, is called with the response headers just before the response is written, after Headers.
+	BeforeWrite func(http.Header)
+}
+
+func (hh *headerHooks) applyHeaders(h http.Header) {
+	cache := hh.defaultCache
+	if hh.Cache != nil {
+		cache = *hh.Cache
+	}
+	h.Set("Cache-Control", cache.String())
+	for k, v := range hh.Headers {
+		h.Set(k, v)
+	}
+	if hh.BeforeWrite != nil {
+		hh.BeforeWrite(h)
+	}
+}
+
+// addVary adds names to h's Vary header, leaving out any it already lists, since several parts of a handler may each
+// say that the response depends on the same request header.
+func addVary(h http.Header, names ...string) {
+	for _, name := range names {
+		if !varies(h, name) {
+			h.Add("Vary", name)
+		}
+	}
+}
+
+func varies(h http.Header, name string) bool {
+	for _, v := range h.Values("Vary") {
+		for _, listed := range strings.Split(v, ",") {
+			if listed = strings.TrimSpace(listed); listed == "*" || strings.EqualFold(listed, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
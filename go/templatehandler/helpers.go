@@ -5,19 +5,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"html/template"
+	"reflect"
 
-	"github.com/russross/blackfriday"
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
 )
 
 func Markdown(t *template.Template) func(string, interface{}) (template.HTML, error) {
-	return func(name string, in interface{}) (template.HTML, error) {
-		var b bytes.Buffer
-		if err := t.ExecuteTemplate(&b, name, in); err != nil {
-			return "", err
-		}
-		out := blackfriday.MarkdownCommon(b.Bytes())
-		return template.HTML(out), nil
-	}
+	return MarkdownWith(t, MarkdownOptions{})
 }
 
 func mergeMap(base, in map[string]interface{}) map[string]interface{} {
@@ -31,12 +26,79 @@ func mergeMap(base, in map[string]interface{}) map[string]interface{} {
 	return out
 }
 
-func jsonFromTmpl(t *template.Template, name string) (map[string]interface{}, error) {
+// MergeStrategy is how a page's input is combined with the input it extends.
+type MergeStrategy int
+
+const (
+	// MergeShallow replaces each top-level key with the page's value, except MetaKey, which is merged key by key.
+	MergeShallow MergeStrategy = iota
+
+	// MergeDeep merges objects key by key at every level, so that a page can add one key under "meta" without
+	// repeating the rest. Other values, lists included, are replaced.
+	MergeDeep
+
+	// MergeDeepAppend is like MergeDeep, but appends a page's lists to the lists they extend.
+	MergeDeepAppend
+)
+
+// merge returns base with in merged over it. Neither is modified.
+func (m MergeStrategy) merge(base, in map[string]interface{}) map[string]interface{} {
+	if m == MergeShallow {
+		out := mergeMap(base, in)
+		// A page's MetaKey adds to the base's rather than replacing it, so that pages needn't repeat its defaults.
+		if bm, ok := base[MetaKey].(map[string]interface{}); ok {
+			if im, ok := in[MetaKey].(map[string]interface{}); ok {
+				out[MetaKey] = mergeMap(bm, im)
+			}
+		}
+		return out
+	}
+	out := make(map[string]interface{})
+	for k, v := range base {
+		out[k] = v
+	}
+	for k, v := range in {
+		out[k] = m.mergeValue(out[k], v)
+	}
+	return out
+}
+
+func (m MergeStrategy) mergeValue(base, in interface{}) interface{} {
+	if bm, ok := base.(map[string]interface{}); ok {
+		if im, ok := in.(map[string]interface{}); ok {
+			return m.merge(bm, im)
+		}
+	}
+	if m == MergeDeepAppend && base != nil && in != nil {
+		bv, iv := reflect.ValueOf(base), reflect.ValueOf(in)
+		if bv.Kind() == reflect.Slice && bv.Type() == iv.Type() {
+			out := reflect.MakeSlice(bv.Type(), 0, bv.Len()+iv.Len())
+			return reflect.AppendSlice(reflect.AppendSlice(out, bv), iv).Interface()
+		}
+	}
+	return in
+}
+
+// inputFormats are the templates a page can provide its input in, and how each is decoded. If a page defines more
+// than one, they are merged in this order.
+var inputFormats = []struct {
+	name      string
+	format    string
+	unmarshal func([]byte, interface{}) error
+}{
+	{"input", "json", json.Unmarshal},
+	{"input_yaml", "yaml", yaml.Unmarshal},
+	{"input_toml", "toml", toml.Unmarshal},
+}
+
+func inputFromTmpl(t *template.Template, name, format string, unmarshal func([]byte, interface{}) error) (map[string]interface{}, error) {
 	var base bytes.Buffer
-	t.ExecuteTemplate(&base, name, nil)
+	if err := t.ExecuteTemplate(&base, name, nil); err != nil {
+		return nil, err
+	}
 	input := make(map[string]interface{})
-	if err := json.Unmarshal(base.Bytes(), &input); err != nil {
-		return nil, fmt.Errorf("json unmarshaling of template %q failed: %v", name, err)
+	if err := unmarshal(base.Bytes(), &input); err != nil {
+		return nil, fmt.Errorf("%s unmarshaling of template %q failed: %v", format, name, err)
 	}
 	return input, nil
 }
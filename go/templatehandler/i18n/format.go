@@ -0,0 +1,237 @@
+package i18n
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Format is how a locale writes dates and numbers.
+type Format struct {
+	// Decimal and Group separate a number's fraction from its whole part, and its thousands from each other.
+	Decimal string
+	Group   string
+
+	// Dates are time.Format layouts for the date styles "short", "medium", "long" and "full". Month and day names in
+	// them are written in the locale's language.
+	Dates map[string]string
+
+	Months      [12]string
+	ShortMonths [12]string
+	Days        [7]string
+	ShortDays   [7]string
+}
+
+var english = &Format{
+	Decimal: ".",
+	Group:   ",",
+	Dates: map[string]string{
+		"short":  "1/2/06",
+		"medium": "Jan 2, 2006",
+		"long":   "January 2, 2006",
+		"full":   "Monday, January 2, 2006",
+	},
+	Months:      [12]string{"January", "February", "March", "April", "May", "June", "July", "August", "September", "October", "November", "December"},
+	ShortMonths: [12]string{"Jan", "Feb", "Mar", "Apr", "May", "Jun", "Jul", "Aug", "Sep", "Oct", "Nov", "Dec"},
+	Days:        [7]string{"Sunday", "Monday", "Tuesday", "Wednesday", "Thursday", "Friday", "Saturday"},
+	ShortDays:   [7]string{"Sun", "Mon", "Tue", "Wed", "Thu", "Fri", "Sat"},
+}
+
+// localeFormats are the locales Formats knows, by locale or language.
+var localeFormats = map[string]*Format{
+	"en": english,
+	"en-GB": {
+		Decimal: ".",
+		Group:   ",",
+		Dates: map[string]string{
+			"short":  "02/01/2006",
+			"medium": "2 Jan 2006",
+			"long":   "2 January 2006",
+			"full":   "Monday, 2 January 2006",
+		},
+		Months: english.Months, ShortMonths: english.ShortMonths, Days: english.Days, ShortDays: english.ShortDays,
+	},
+	"fr": {
+		Decimal: ",",
+		Group:   "\u202f",
+		Dates: map[string]string{
+			"short":  "02/01/2006",
+			"medium": "2 Jan 2006",
+			"long":   "2 January 2006",
+			"full":   "Monday 2 January 2006",
+		},
+		Months:      [12]string{"janvier", "février", "mars", "avril", "mai", "juin", "juillet", "août", "septembre", "octobre", "novembre", "décembre"},
+		ShortMonths: [12]string{"janv.", "févr.", "mars", "avr.", "mai", "juin", "juil.", "août", "sept.", "oct.", "nov.", "déc."},
+		Days:        [7]string{"dimanche", "lundi", "mardi", "mercredi", "jeudi", "vendredi", "samedi"},
+		ShortDays:   [7]string{"dim.", "lun.", "mar.", "mer.", "jeu.", "ven.", "sam."},
+	},
+	"de": {
+		Decimal: ",",
+		Group:   ".",
+		Dates: map[string]string{
+			"short":  "02.01.06",
+			"medium": "02.01.2006",
+			"long":   "2. January 2006",
+			"full":   "Monday, 2. January 2006",
+		},
+		Months:      [12]string{"Januar", "Februar", "März", "April", "Mai", "Juni", "Juli", "August", "September", "Oktober", "November", "Dezember"},
+		ShortMonths: [12]string{"Jan.", "Feb.", "März", "Apr.", "Mai", "Juni", "Juli", "Aug.", "Sept.", "Okt.", "Nov.", "Dez."},
+		Days:        [7]string{"Sonntag", "Montag", "Dienstag", "Mittwoch", "Donnerstag", "Freitag", "Samstag"},
+		ShortDays:   [7]string{"So.", "Mo.", "Di.", "Mi.", "Do.", "Fr.", "Sa."},
+	},
+	"es": {
+		Decimal: ",",
+		Group:   ".",
+		Dates: map[string]string{
+			"short":  "2/1/06",
+			"medium": "2 Jan 2006",
+			"long":   "2 de January de 2006",
+			"full":   "Monday, 2 de January de 2006",
+		},
+		Months:      [12]string{"enero", "febrero", "marzo", "abril", "mayo", "junio", "julio", "agosto", "septiembre", "octubre", "noviembre", "diciembre"},
+		ShortMonths: [12]string{"ene", "feb", "mar", "abr", "may", "jun", "jul", "ago", "sept", "oct", "nov", "dic"},
+		Days:        [7]string{"domingo", "lunes", "martes", "miércoles", "jueves", "viernes", "sábado"},
+		ShortDays:   [7]string{"dom", "lun", "mar", "mié", "jue", "vie", "sáb"},
+	},
+	"it": {
+		Decimal: ",",
+		Group:   ".",
+		Dates: map[string]string{
+			"short":  "02/01/06",
+			"medium": "2 Jan 2006",
+			"long":   "2 January 2006",
+			"full":   "Monday 2 January 2006",
+		},
+		Months:      [12]string{"gennaio", "febbraio", "marzo", "aprile", "maggio", "giugno", "luglio", "agosto", "settembre", "ottobre", "novembre", "dicembre"},
+		ShortMonths: [12]string{"gen", "feb", "mar", "apr", "mag", "giu", "lug", "ago", "set", "ott", "nov", "dic"},
+		Days:        [7]string{"domenica", "lunedì", "martedì", "mercoledì", "giovedì", "venerdì", "sabato"},
+		ShortDays:   [7]string{"dom", "lun", "mar", "mer", "gio", "ven", "sab"},
+	},
+	"pt": {
+		Decimal: ",",
+		Group:   ".",
+		Dates: map[string]string{
+			"short":  "02/01/2006",
+			"medium": "2 de Jan de 2006",
+			"long":   "2 de January de 2006",
+			"full":   "Monday, 2 de January de 2006",
+		},
+		Months:      [12]string{"janeiro", "fevereiro", "março", "abril", "maio", "junho", "julho", "agosto", "setembro", "outubro", "novembro", "dezembro"},
+		ShortMonths: [12]string{"jan", "fev", "mar", "abr", "mai", "jun", "jul", "ago", "set", "out", "nov", "dez"},
+		Days:        [7]string{"domingo", "segunda-feira", "terça-feira", "quarta-feira", "quinta-feira", "sexta-feira", "sábado"},
+		ShortDays:   [7]string{"dom", "seg", "ter", "qua", "qui", "sex", "sáb"},
+	},
+	"nl": {
+		Decimal: ",",
+		Group:   ".",
+		Dates: map[string]string{
+			"short":  "02-01-2006",
+			"medium": "2 Jan 2006",
+			"long":   "2 January 2006",
+			"full":   "Monday 2 January 2006",
+		},
+		Months:      [12]string{"januari", "februari", "maart", "april", "mei", "juni", "juli", "augustus", "september", "oktober", "november", "december"},
+		ShortMonths: [12]string{"jan", "feb", "mrt", "apr", "mei", "jun", "jul", "aug", "sep", "okt", "nov", "dec"},
+		Days:        [7]string{"zondag", "maandag", "dinsdag", "woensdag", "donderdag", "vrijdag", "zaterdag"},
+		ShortDays:   [7]string{"zo", "ma", "di", "wo", "do", "vr", "za"},
+	},
+	"ja": {
+		Decimal: ".",
+		Group:   ",",
+		Dates: map[string]string{
+			"short":  "2006/01/02",
+			"medium": "2006/01/02",
+			"long":   "2006年1月2日",
+			"full":   "2006年1月2日Monday",
+		},
+		Months:      [12]string{"1月", "2月", "3月", "4月", "5月", "6月", "7月", "8月", "9月", "10月", "11月", "12月"},
+		ShortMonths: [12]string{"1月", "2月", "3月", "4月", "5月", "6月", "7月", "8月", "9月", "10月", "11月", "12月"},
+		Days:        [7]string{"日曜日", "月曜日", "火曜日", "水曜日", "木曜日", "金曜日", "土曜日"},
+		ShortDays:   [7]string{"日", "月", "火", "水", "木", "金", "土"},
+	},
+}
+
+// FormatFor returns the Format for locale, falling back to its language (so "fr-CA" uses "fr") and then to US
+// English.
+func FormatFor(locale string) *Format {
+	if f, ok := localeFormats[locale]; ok {
+		return f
+	}
+	if i := strings.IndexByte(locale, '-'); i > 0 {
+		if f, ok := localeFormats[locale[:i]]; ok {
+			return f
+		}
+	}
+	return english
+}
+
+// Date formats t in the given style, one of "short", "medium", "long" or "full". Any other style is used as a
+// time.Format layout, with month and day names still written in the locale's language.
+func (f *Format) Date(t time.Time, style string) string {
+	layout, ok := f.Dates[style]
+	if !ok {
+		layout = style
+	}
+
+	// time.Format only knows English names, so write those parts of the layout here and leave the rest to it.
+	var b strings.Builder
+	for layout != "" {
+		i, token := nextName(layout)
+		b.WriteString(t.Format(layout[:i]))
+		if token == "" {
+			break
+		}
+		switch token {
+		case "January":
+			b.WriteString(f.Months[t.Month()-1])
+		case "Jan":
+			b.WriteString(f.ShortMonths[t.Month()-1])
+		case "Monday":
+			b.WriteString(f.Days[t.Weekday()])
+		case "Mon":
+			b.WriteString(f.ShortDays[t.Weekday()])
+		}
+		layout = layout[i+len(token):]
+	}
+	return b.String()
+}
+
+// nextName returns the index of the first month or day name in layout, and the name, or len(layout) and "" if there
+// is none.
+func nextName(layout string) (int, string) {
+	for i := 0; i < len(layout); i++ {
+		for _, token := range []string{"January", "Jan", "Monday", "Mon"} {
+			if strings.HasPrefix(layout[i:], token) {
+				return i, token
+			}
+		}
+	}
+	return len(layout), ""
+}
+
+// Number formats v with the locale's separators, rounded to decimals places, or with as many as it needs if decimals
+// is negative.
+func (f *Format) Number(v float64, decimals int) string {
+	if math.IsInf(v, 0) || math.IsNaN(v) {
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	s := strconv.FormatFloat(math.Abs(v), 'f', decimals, 64)
+	whole, frac, _ := strings.Cut(s, ".")
+
+	var b strings.Builder
+	if v < 0 && strings.Trim(s, "0.") != "" {
+		b.WriteByte('-')
+	}
+	for i, d := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			b.WriteString(f.Group)
+		}
+		b.WriteRune(d)
+	}
+	if frac != "" {
+		b.WriteString(f.Decimal)
+		b.WriteString(frac)
+	}
+	return b.String()
+}
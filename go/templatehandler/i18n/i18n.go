@@ -0,0 +1,188 @@
+// Package i18n loads translated message catalogs and picks the locale to use for a request.
+//
+// A catalog is a directory with one file per locale, named after the locale and written in JSON, TOML or YAML, e.g.
+// en.json, fr.toml or pt-BR.yaml. Each file maps message keys to messages; nested objects are flattened into dotted
+// keys, so {"nav": {"about": "About"}} defines the key "nav.about".
+package i18n
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultCookie is the cookie a visitor's chosen locale is read from, unless Catalog.Cookie says otherwise.
+const DefaultCookie = "locale"
+
+var formats = map[string]func([]byte, interface{}) error{
+	".json": json.Unmarshal,
+	".toml": toml.Unmarshal,
+	".yaml": yaml.Unmarshal,
+	".yml":  yaml.Unmarshal,
+}
+
+// Catalog holds the messages for a set of locales.
+type Catalog struct {
+	// Default is the locale used when a request doesn't ask for one the catalog has, and to look up messages missing
+	// from other locales.
+	Default string
+
+	// Cookie is the name of the cookie a visitor's chosen locale is read from. If empty, DefaultCookie is used.
+	Cookie string
+
+	messages map[string]map[string]string
+}
+
+// LoadDir loads a catalog from the files in dir, with def as its default locale.
+func LoadDir(dir, def string) (*Catalog, error) {
+	return LoadFS(os.DirFS(dir), ".", def)
+}
+
+// LoadFS is like LoadDir, but reads the catalog from dir in fsys.
+func LoadFS(fsys fs.FS, dir, def string) (*Catalog, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Catalog{
+		Default:  def,
+		messages: make(map[string]map[string]string),
+	}
+	for _, e := range entries {
+		ext := path.Ext(e.Name())
+		unmarshal, ok := formats[ext]
+		if e.IsDir() || !ok {
+			continue
+		}
+		b, err := fs.ReadFile(fsys, path.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		m := make(map[string]interface{})
+		if err := unmarshal(b, &m); err != nil {
+			return nil, fmt.Errorf("could not parse message catalog %q: %v", e.Name(), err)
+		}
+		messages := make(map[string]string)
+		flatten("", m, messages)
+		c.messages[strings.TrimSuffix(e.Name(), ext)] = messages
+	}
+
+	if _, ok := c.messages[def]; !ok {
+		return nil, fmt.Errorf("no message catalog for default locale %q", def)
+	}
+	return c, nil
+}
+
+func flatten(prefix string, in map[string]interface{}, out map[string]string) {
+	for k, v := range in {
+		if prefix != "" {
+			k = prefix + "." + k
+		}
+		if m, ok := v.(map[string]interface{}); ok {
+			flatten(k, m, out)
+			continue
+		}
+		out[k] = fmt.Sprint(v)
+	}
+}
+
+// Locales returns the locales the catalog has messages for, sorted.
+func (c *Catalog) Locales() []string {
+	var out []string
+	for l := range c.messages {
+		out = append(out, l)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Translate returns the message for key in locale, falling back to the locale's language (so "fr-CA" falls back to
+// "fr"), then to the default locale, and finally to the key itself. If args are given the message is used as a format
+// string for them.
+func (c *Catalog) Translate(locale, key string, args ...interface{}) string {
+	msg, ok := c.lookup(locale, key)
+	if !ok {
+		msg, ok = c.lookup(c.Default, key)
+	}
+	if !ok {
+		msg = key
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(msg, args...)
+	}
+	return msg
+}
+
+func (c *Catalog) lookup(locale, key string) (string, bool) {
+	if msg, ok := c.messages[locale][key]; ok {
+		return msg, true
+	}
+	if i := strings.IndexByte(locale, '-'); i > 0 {
+		msg, ok := c.messages[locale[:i]][key]
+		return msg, ok
+	}
+	return "", false
+}
+
+// Negotiate picks the locale to serve r in: the one named by the locale cookie if the catalog has it, otherwise the
+// best match for the Accept-Language header, otherwise the default.
+func (c *Catalog) Negotiate(r *http.Request) string {
+	name := c.Cookie
+	if name == "" {
+		name = DefaultCookie
+	}
+	if cookie, err := r.Cookie(name); err == nil {
+		if l, ok := c.match(cookie.Value); ok {
+			return l
+		}
+	}
+
+	best, bestQ := c.Default, 0.0
+	for _, part := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		params := strings.Split(part, ";")
+		tag := strings.TrimSpace(params[0])
+		q := 1.0
+		for _, p := range params[1:] {
+			p = strings.TrimSpace(p)
+			if strings.HasPrefix(p, "q=") {
+				if v, err := strconv.ParseFloat(p[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		if q <= bestQ {
+			continue
+		}
+		if l, ok := c.match(tag); ok {
+			best, bestQ = l, q
+		}
+	}
+	return best
+}
+
+// match finds the catalog locale for tag, either exactly (ignoring case) or by its language alone.
+func (c *Catalog) match(tag string) (string, bool) {
+	for l := range c.messages {
+		if strings.EqualFold(l, tag) {
+			return l, true
+		}
+	}
+	if i := strings.IndexByte(tag, '-'); i > 0 {
+		for l := range c.messages {
+			if strings.EqualFold(l, tag[:i]) {
+				return l, true
+			}
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,382 @@
+// Package idtoken checks the OpenID Connect ID tokens Google signs for the service accounts that call a site, such as
+// Cloud Scheduler jobs and Pub/Sub push subscriptions, and the assertions Identity-Aware Proxy adds to the requests it
+// lets through, so that endpoints only they should reach can refuse everyone else without a shared secret.
+//
+// A token is a JWT signed with one of Google's keys, which a Verifier fetches from KeysURL and keeps until the response
+// says they expire, refreshing them in the background. Wrap handlers with Bearer, which takes the token from the
+// request's Authorization header, or IAP, which takes it from IAP's X-Goog-Iap-Jwt-Assertion.
+package idtoken
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mconbere/quitlikeapro/go/templatehandler"
+)
+
+// GoogleKeysURL is where Google publishes the keys it signs service accounts' ID tokens with, as a JWK set.
+const GoogleKeysURL = "https://www.googleapis.com/oauth2/v3/certs"
+
+// GoogleIssuers are the issuers of service accounts' ID tokens.
+var GoogleIssuers = []string{"https://accounts.google.com", "accounts.google.com"}
+
+// IAPKeysURL is where Google publishes the keys Identity-Aware Proxy signs its assertions with, as a JWK set.
+const IAPKeysURL = "https://www.gstatic.com/iap/verify/public_key-jwk"
+
+// IAPIssuers are the issuers of Identity-Aware Proxy's assertions.
+var IAPIssuers = []string{"https://cloud.google.com/iap"}
+
+// keysTimeout is how long fetching the keys may take. It's done in the background, but a request with a token signed
+// by a key that isn't known yet waits for it.
+const keysTimeout = 5 * time.Second
+
+// keysTTL is how long keys are kept when their response doesn't say. Google's say about six hours.
+const keysTTL = time.Hour
+
+// keysRetry is how long after a failed or unhelpful fetch the keys are fetched again, so that tokens with made up key
+// IDs can't have every request fetch them.
+const keysRetry = time.Minute
+
+// leeway is how far the clocks of the signer and server may disagree about when a token expires or was issued.
+const leeway = 30 * time.Second
+
+// ErrInvalid is returned, wrapped, for a token that isn't valid, as opposed to one whose keys couldn't be fetched.
+var ErrInvalid = errors.New("idtoken: invalid token")
+
+// Claims are the claims of a verified token that say who it was issued to.
+type Claims struct {
+	Issuer        string `json:"iss"`
+	Audience      string `json:"aud"`
+	Subject       string `json:"sub"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Expires       int64  `json:"exp"`
+	IssuedAt      int64  `json:"iat"`
+}
+
+// Verifier verifies tokens signed with the keys at KeysURL, by one of Issuers, for Audience.
+type Verifier struct {
+	KeysURL  string
+	Issuers  []string
+	Audience string
+
+	// Client fetches the keys. If nil, a client with a keysTimeout timeout is used.
+	Client *http.Client
+
+	mu      sync.Mutex
+	keys    map[string]crypto.PublicKey
+	refresh time.Time
+	err     error
+	// fetching is closed when the fetch in flight finishes, and is nil when there's none.
+	fetching chan struct{}
+}
+
+// NewGoogle returns a Verifier for the ID tokens of Google service accounts, which must have been issued for
+// audience, such as the URL a Cloud Scheduler job or Pub/Sub push subscription is set to call.
+func NewGoogle(audience string) *Verifier {
+	return &Verifier{KeysURL: GoogleKeysURL, Issuers: GoogleIssuers, Audience: audience}
+}
+
+// NewIAP returns a Verifier for the assertions of Identity-Aware Proxy, which must have been issued for audience: for
+// App Engine, "/projects/PROJECT_NUMBER/apps/PROJECT_ID".
+func NewIAP(audience string) *Verifier {
+	return &Verifier{KeysURL: IAPKeysURL, Issuers: IAPIssuers, Audience: audience}
+}
+
+// Verify returns the claims of token if it's signed by one of the verifier's keys, by one of its issuers, for its
+// audience, and hasn't expired. It fails with an error wrapping ErrInvalid if not.
+func (v *Verifier) Verify(ctx context.Context, token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: not a JWT", ErrInvalid)
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	key, err := v.key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(header.Alg, key, parts[0]+"."+parts[1], sig); err != nil {
+		return nil, err
+	}
+
+	var c Claims
+	if err := decodeSegment(parts[1], &c); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	switch {
+	case !contains(v.Issuers, c.Issuer):
+		return nil, fmt.Errorf("%w: issued by %q", ErrInvalid, c.Issuer)
+	case v.Audience == "" || c.Audience != v.Audience:
+		return nil, fmt.Errorf("%w: issued for %q", ErrInvalid, c.Audience)
+	case now.After(time.Unix(c.Expires, 0).Add(leeway)):
+		return nil, fmt.Errorf("%w: expired", ErrInvalid)
+	case now.Before(time.Unix(c.IssuedAt, 0).Add(-leeway)):
+		return nil, fmt.Errorf("%w: issued in the future", ErrInvalid)
+	}
+	return &c, nil
+}
+
+// key returns the key with the given ID. Keys are served from memory, and fetched again in the background once
+// they're due a refresh; only a key ID that isn't known waits for a fetch, and then no more than once a keysRetry.
+func (v *Verifier) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	v.mu.Lock()
+	key, ok := v.keys[kid]
+	due := !time.Now().Before(v.refresh)
+	if due && v.fetching == nil {
+		v.fetching = make(chan struct{})
+		go v.fetch(v.fetching)
+	}
+	fetching := v.fetching
+	v.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+	if fetching == nil {
+		return nil, fmt.Errorf("%w: unknown key %q", ErrInvalid, kid)
+	}
+
+	select {
+	case <-fetching:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if key, ok := v.keys[kid]; ok {
+		return key, nil
+	}
+	if v.err != nil {
+		return nil, fmt.Errorf("idtoken: could not fetch keys: %v", v.err)
+	}
+	return nil, fmt.Errorf("%w: unknown key %q", ErrInvalid, kid)
+}
+
+// fetch fetches the keys, and closes done once they're kept. If it fails, the last keys are kept.
+func (v *Verifier) fetch(done chan struct{}) {
+	ctx, cancel := context.WithTimeout(context.Background(), keysTimeout)
+	defer cancel()
+	keys, ttl, err := v.fetchKeys(ctx)
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if err == nil {
+		v.keys = keys
+	}
+	v.refresh = time.Now().Add(max(ttl, keysRetry))
+	v.err, v.fetching = err, nil
+	close(done)
+}
+
+// fetchKeys gets the JWK set at KeysURL, and how long it can be kept for.
+func (v *Verifier) fetchKeys(ctx context.Context) (map[string]crypto.PublicKey, time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.KeysURL, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	client := v.Client
+	if client == nil {
+		client = &http.Client{Timeout: keysTimeout}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("%s: %s", v.KeysURL, resp.Status)
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, 0, fmt.Errorf("%s: %v", v.KeysURL, err)
+	}
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		key, err := k.publicKey()
+		if err != nil {
+			return nil, 0, fmt.Errorf("%s: key %q: %v", v.KeysURL, k.Kid, err)
+		}
+		keys[k.Kid] = key
+	}
+	return keys, maxAge(resp.Header.Get("Cache-Control")), nil
+}
+
+// jwk is a public key in a JWK set.
+type jwk struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+// verifySignature checks that sig is key's signature of signed with the algorithm alg.
+func verifySignature(alg string, key crypto.PublicKey, signed string, sig []byte) error {
+	hash := sha256.Sum256([]byte(signed))
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if alg == "RS256" && rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], sig) == nil {
+			return nil
+		}
+	case *ecdsa.PublicKey:
+		// A JWS ECDSA signature is r and s, each 32 bytes for P-256, one after the other.
+		if alg == "ES256" && len(sig) == 64 {
+			r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+			if ecdsa.Verify(key, hash[:], r, s) {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("%w: bad %s signature", ErrInvalid, alg)
+}
+
+// decodeSegment decodes the base64url JSON of a token's header or claims into v.
+func decodeSegment(s string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	return nil
+}
+
+// maxAge returns the max-age of a Cache-Control header, or keysTTL if it has none.
+func maxAge(cacheControl string) time.Duration {
+	for _, d := range strings.Split(cacheControl, ",") {
+		if v, ok := strings.CutPrefix(strings.TrimSpace(d), "max-age="); ok {
+			if s, err := strconv.Atoi(v); err == nil {
+				return time.Duration(s) * time.Second
+			}
+		}
+	}
+	return keysTTL
+}
+
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Bearer returns middleware that only lets through requests carrying, as a bearer token, a token v verifies for one
+// of the given service accounts' emails. Other requests get a 401, or a 503 if the keys couldn't be fetched. With no
+// emails, every request is refused, since any Google account can get a token for any audience.
+func Bearer(v *Verifier, emails ...string) templatehandler.Middleware {
+	return require(v, func(r *http.Request) string {
+		token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		return token
+	}, func(c *Claims) bool {
+		return c.EmailVerified && contains(emails, c.Email)
+	})
+}
+
+// IAP returns middleware that checks the assertion Identity-Aware Proxy puts on each request it lets through, which
+// v, such as from NewIAP, must verify for one of the given users' emails, or for anyone IAP lets through if there are
+// none, since IAP's own access policy then says who can reach the app. Requests with a bad assertion get a 401, or a
+// 503 if the keys couldn't be fetched. Requests without one, which didn't come through IAP, are handed to or, such as
+// templatehandler.BearerToken, or refused with a 401 if or is nil.
+func IAP(v *Verifier, or templatehandler.Middleware, emails ...string) templatehandler.Middleware {
+	checked := require(v, func(r *http.Request) string {
+		return r.Header.Get("X-Goog-Iap-Jwt-Assertion")
+	}, func(c *Claims) bool {
+		return len(emails) == 0 || contains(emails, c.Email)
+	})
+	return func(h http.Handler) http.Handler {
+		iap := checked(h)
+		other := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		}))
+		if or != nil {
+			other = or(h)
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("X-Goog-Iap-Jwt-Assertion") != "" {
+				iap.ServeHTTP(w, r)
+				return
+			}
+			other.ServeHTTP(w, r)
+		})
+	}
+}
+
+// require returns middleware that only lets through requests whose token, as found by token, v verifies with claims
+// allow accepts.
+func require(v *Verifier, token func(r *http.Request) string, allow func(c *Claims) bool) templatehandler.Middleware {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			c, err := v.Verify(r.Context(), token(r))
+			switch {
+			case err == nil && allow(c):
+				h.ServeHTTP(w, r)
+			case err == nil || errors.Is(err, ErrInvalid):
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			default:
+				log.Printf("idtoken: could not verify token path=%q error=%q", r.URL.Path, err)
+				http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			}
+		})
+	}
+}
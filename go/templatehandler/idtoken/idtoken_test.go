@@ -0,0 +1,192 @@
+package idtoken
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sign returns a JWT of claims signed by key, with the key ID kid.
+func sign(t *testing.T, key *rsa.PrivateKey, kid string, claims map[string]interface{}) string {
+	t.Helper()
+	segment := func(v interface{}) string {
+		b, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	signed := segment(map[string]string{"alg": "RS256", "kid": kid}) + "." + segment(claims)
+	hash := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hash[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestBearer(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kid": "k1",
+			"kty": "RSA",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	}))
+	defer keys.Close()
+
+	v := NewGoogle("https://example.com/tasks/")
+	v.KeysURL = keys.URL
+	h := Bearer(v, "scheduler@example.iam.gserviceaccount.com")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	claims := func(change func(c map[string]interface{})) map[string]interface{} {
+		c := map[string]interface{}{
+			"iss":            "https://accounts.google.com",
+			"aud":            "https://example.com/tasks/",
+			"email":          "scheduler@example.iam.gserviceaccount.com",
+			"email_verified": true,
+			"iat":            time.Now().Unix(),
+			"exp":            time.Now().Add(time.Hour).Unix(),
+		}
+		if change != nil {
+			change(c)
+		}
+		return c
+	}
+	// A valid token with the claims of another, which it's not the signature of.
+	valid, other := sign(t, key, "k1", claims(nil)), sign(t, key, "k1", claims(func(c map[string]interface{}) { c["sub"] = "x" }))
+	tampered := valid[:strings.Index(valid, ".")] + other[strings.Index(other, "."):strings.LastIndex(other, ".")] + valid[strings.LastIndex(valid, "."):]
+	for _, tt := range []struct {
+		name  string
+		token string
+		code  int
+	}{
+		{"valid", valid, http.StatusOK},
+		{"none", "", http.StatusUnauthorized},
+		{"unknown key", sign(t, key, "k2", claims(nil)), http.StatusUnauthorized},
+		{"audience", sign(t, key, "k1", claims(func(c map[string]interface{}) { c["aud"] = "https://evil.example/" })), http.StatusUnauthorized},
+		{"issuer", sign(t, key, "k1", claims(func(c map[string]interface{}) { c["iss"] = "https://evil.example" })), http.StatusUnauthorized},
+		{"email", sign(t, key, "k1", claims(func(c map[string]interface{}) { c["email"] = "someone@example.com" })), http.StatusUnauthorized},
+		{"expired", sign(t, key, "k1", claims(func(c map[string]interface{}) { c["exp"] = time.Now().Add(-time.Hour).Unix() })), http.StatusUnauthorized},
+		{"tampered", tampered, http.StatusUnauthorized},
+	} {
+		r := httptest.NewRequest("POST", "/tasks/sitemap", nil)
+		if tt.token != "" {
+			r.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tt.code {
+			t.Errorf("%s token: got %d, want %d", tt.name, w.Code, tt.code)
+		}
+	}
+}
+
+func TestBearerKeysUnavailable(t *testing.T) {
+	keys := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusInternalServerError)
+	}))
+	defer keys.Close()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v := NewGoogle("aud")
+	v.KeysURL = keys.URL
+	h := Bearer(v, "a@example.com")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	r := httptest.NewRequest("POST", "/", nil)
+	r.Header.Set("Authorization", "Bearer "+sign(t, key, "k1", map[string]interface{}{"aud": "aud"}))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("with the keys unavailable: got %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+}
+
+func TestIAP(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kid": "iap1",
+			"kty": "EC",
+			"crv": "P-256",
+			"x":   base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+			"y":   base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+		}}})
+	}))
+	defer keys.Close()
+	assertion := func(email string) string {
+		segment := func(v interface{}) string {
+			b, _ := json.Marshal(v)
+			return base64.RawURLEncoding.EncodeToString(b)
+		}
+		signed := segment(map[string]string{"alg": "ES256", "kid": "iap1"}) + "." + segment(map[string]interface{}{
+			"iss":   "https://cloud.google.com/iap",
+			"aud":   "/projects/1/apps/site",
+			"email": email,
+			"iat":   time.Now().Unix(),
+			"exp":   time.Now().Add(10 * time.Minute).Unix(),
+		})
+		hash := sha256.Sum256([]byte(signed))
+		r, s, err := ecdsa.Sign(rand.Reader, key, hash[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig := append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+		return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+	}
+
+	// An assertion with a signature of the right length that isn't key's.
+	forged := assertion("a@example.com")
+	forged = forged[:strings.LastIndex(forged, ".")+1] + strings.Repeat("A", 86)
+
+	v := NewIAP("/projects/1/apps/site")
+	v.KeysURL = keys.URL
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	bearer := func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusTeapot) })
+	}
+	for _, tt := range []struct {
+		name      string
+		h         http.Handler
+		assertion string
+		code      int
+	}{
+		{"anyone IAP admits", IAP(v, nil)(ok), assertion("a@example.com"), http.StatusOK},
+		{"listed user", IAP(v, nil, "a@example.com")(ok), assertion("a@example.com"), http.StatusOK},
+		{"unlisted user", IAP(v, nil, "a@example.com")(ok), assertion("b@example.com"), http.StatusUnauthorized},
+		{"forged", IAP(v, bearer)(ok), forged, http.StatusUnauthorized},
+		{"no assertion", IAP(v, nil)(ok), "", http.StatusUnauthorized},
+		{"no assertion, with a fallback", IAP(v, bearer)(ok), "", http.StatusTeapot},
+	} {
+		r := httptest.NewRequest("POST", "/_admin/reload", nil)
+		if tt.assertion != "" {
+			r.Header.Set("X-Goog-Iap-Jwt-Assertion", tt.assertion)
+		}
+		w := httptest.NewRecorder()
+		tt.h.ServeHTTP(w, r)
+		if w.Code != tt.code {
+			t.Errorf("%s: got %d, want %d", tt.name, w.Code, tt.code)
+		}
+	}
+}
@@ -0,0 +1,256 @@
+// Package images serves resized copies of a site's images, and renders <img> tags with a srcset that lets browsers
+// pick the smallest copy that fits, so that screenshots and photos aren't downloaded at full size on small screens.
+//
+// Copies are made the first time they are asked for, and kept in memory. Mount a Set at its URL and give the Base the
+// funcs from Set.Funcs:
+//
+//	set := images.New(os.DirFS("static"), "/static/", "/images/")
+//	mux.Handle(set.URL, set)
+//	base, err := templatehandler.NewBase("templates/base.html", templatehandler.WithFuncs(set.Funcs()))
+//
+// Pages then include an image under static/ with {{ img "img/screenshots/emacs.png" "Quitting Emacs" }}. JPEG and
+// PNG images are supported.
+package images
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultWidths are the widths a Set resizes images to when its Widths are unset.
+var DefaultWidths = []int{320, 640, 960, 1280}
+
+// DefaultSizes is the sizes attribute of images whose template doesn't give one: the full width of the viewport.
+const DefaultSizes = "100vw"
+
+// Set is a directory of images and the resized copies made of them.
+type Set struct {
+	// FS holds the original images.
+	FS fs.FS
+
+	// StaticURL is where the original images are served from, e.g. "/static/".
+	StaticURL string
+
+	// URL is where the Set is mounted to serve the copies, e.g. "/images/". A copy of name resized to width w is
+	// served at URL + w + "/" + name.
+	URL string
+
+	// Widths are the widths images are resized to, in pixels. If nil, DefaultWidths are used. Images are only ever
+	// made smaller, so an image has no copies as wide as it is or wider.
+	Widths []int
+
+	mu      sync.Mutex
+	configs map[string]image.Config
+	copies  map[string][]byte
+}
+
+// New returns a Set of the images in fsys, served at staticURL, with resized copies served at url.
+func New(fsys fs.FS, staticURL, url string) *Set {
+	return &Set{FS: fsys, StaticURL: staticURL, URL: url}
+}
+
+func (s *Set) widths() []int {
+	if s.Widths == nil {
+		return DefaultWidths
+	}
+	return s.Widths
+}
+
+// config returns the dimensions of the image name, reading them from its header the first time.
+func (s *Set) config(name string) (image.Config, error) {
+	s.mu.Lock()
+	c, ok := s.configs[name]
+	s.mu.Unlock()
+	if ok {
+		return c, nil
+	}
+
+	f, err := s.FS.Open(name)
+	if err != nil {
+		return image.Config{}, err
+	}
+	defer f.Close()
+	c, _, err = image.DecodeConfig(f)
+	if err != nil {
+		return image.Config{}, fmt.Errorf("images: %s: %v", name, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.configs == nil {
+		s.configs = make(map[string]image.Config)
+	}
+	s.configs[name] = c
+	return c, nil
+}
+
+// Funcs returns the template funcs for including images in pages: {{ img name alt }} renders an <img> for the image
+// name in the Set, with a srcset listing its resized copies alongside the original. An optional third argument sets
+// the sizes attribute, e.g. {{ img "img/emacs.png" "Quitting Emacs" "(min-width: 992px) 50vw, 100vw" }}.
+func (s *Set) Funcs() template.FuncMap {
+	return template.FuncMap{"img": s.img}
+}
+
+func (s *Set) img(name, alt string, sizes ...string) (template.HTML, error) {
+	name = strings.TrimPrefix(name, "/")
+	c, err := s.config(name)
+	if err != nil {
+		return "", err
+	}
+
+	var srcset []string
+	for _, w := range s.widths() {
+		if w < c.Width {
+			srcset = append(srcset, fmt.Sprintf("%s %dw", imageURL(s.URL, strconv.Itoa(w), name), w))
+		}
+	}
+	src := imageURL(s.StaticURL, name)
+	srcset = append(srcset, fmt.Sprintf("%s %dw", src, c.Width))
+	sz := DefaultSizes
+	if len(sizes) > 0 {
+		sz = sizes[0]
+	}
+
+	return template.HTML(fmt.Sprintf(`<img src="%s" srcset="%s" sizes="%s" width="%d" height="%d" alt="%s" loading="lazy" decoding="async">`,
+		template.HTMLEscapeString(src),
+		template.HTMLEscapeString(strings.Join(srcset, ", ")),
+		template.HTMLEscapeString(sz),
+		c.Width, c.Height,
+		template.HTMLEscapeString(alt),
+	)), nil
+}
+
+// imageURL joins the path elems onto the URL prefix and escapes the result, commas included, which would otherwise
+// split a srcset entry.
+func imageURL(prefix string, elem ...string) string {
+	u := (&url.URL{Path: path.Join(append([]string{prefix}, elem...)...)}).EscapedPath()
+	return strings.ReplaceAll(u, ",", "%2C")
+}
+
+// ServeHTTP serves the resized copies of the Set's images, making each the first time it is asked for.
+func (s *Set) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p := strings.TrimPrefix(r.URL.Path, s.URL)
+	width, name, ok := strings.Cut(p, "/")
+	wd, err := strconv.Atoi(width)
+	if !ok || err != nil || !contains(s.widths(), wd) || !fs.ValidPath(name) {
+		http.NotFound(w, r)
+		return
+	}
+	fi, err := fs.Stat(s.FS, name)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	b, err := s.copy(name, wd)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	// The copy only changes with the original, whose modification time ServeContent sends for revalidation.
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	http.ServeContent(w, r, name, fi.ModTime(), bytes.NewReader(b))
+}
+
+// copy returns the image name resized to width, encoded in its original format.
+func (s *Set) copy(name string, width int) ([]byte, error) {
+	key := strconv.Itoa(width) + "/" + name
+	s.mu.Lock()
+	b, ok := s.copies[key]
+	s.mu.Unlock()
+	if ok {
+		return b, nil
+	}
+
+	f, err := s.FS.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	src, format, err := image.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("images: %s: %v", name, err)
+	}
+	if width >= src.Bounds().Dx() {
+		return nil, fmt.Errorf("images: %s is no wider than %d pixels", name, width)
+	}
+
+	dst := resize(src, width)
+	var buf bytes.Buffer
+	switch format {
+	case "jpeg":
+		err = jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 85})
+	case "png":
+		err = png.Encode(&buf, dst)
+	default:
+		err = fmt.Errorf("images: %s: unsupported format %q", name, format)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.copies == nil {
+		s.copies = make(map[string][]byte)
+	}
+	s.copies[key] = buf.Bytes()
+	return buf.Bytes(), nil
+}
+
+// resize scales src down to width, keeping its aspect ratio, by averaging the source pixels that each pixel of the
+// copy covers.
+func resize(src image.Image, width int) image.Image {
+	sb := src.Bounds()
+	height := (sb.Dy()*width + sb.Dx()/2) / sb.Dx()
+	if height < 1 {
+		height = 1
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0, y1 := span(sb.Min.Y, sb.Dy(), height, y)
+		for x := 0; x < width; x++ {
+			x0, x1 := span(sb.Min.X, sb.Dx(), width, x)
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					// RGBA is premultiplied by alpha, so transparent pixels don't darken their neighbours.
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r, g, b, a, n = r+uint64(cr), g+uint64(cg), b+uint64(cb), a+uint64(ca), n+1
+				}
+			}
+			dst.SetRGBA(x, y, color.RGBA{uint8(r / n >> 8), uint8(g / n >> 8), uint8(b / n >> 8), uint8(a / n >> 8)})
+		}
+	}
+	return dst
+}
+
+// span returns the range of source pixels, of size in all starting at min, covered by pixel i of the n in the copy.
+func span(min, size, n, i int) (int, int) {
+	lo, hi := min+i*size/n, min+(i+1)*size/n
+	if hi == lo {
+		hi++
+	}
+	return lo, hi
+}
+
+func contains(list []int, v int) bool {
+	for _, e := range list {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,106 @@
+// Package lint checks page templates for the mistakes that would otherwise only show up when a page is served: parse
+// errors, input blocks that aren't valid JSON, YAML or TOML, templates that are used but never defined, input keys the
+// page reads but nothing supplies, and pages that fail to render. cmd/thlint runs it from the command line; tests can
+// call Check directly.
+package lint
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+
+	"github.com/mconbere/quitlikeapro/go/templatehandler"
+	"github.com/mconbere/quitlikeapro/go/templatehandler/i18n"
+)
+
+// Problem is something wrong with one page.
+type Problem struct {
+	// Page is the page template the problem was found in.
+	Page string
+
+	// Err describes the problem.
+	Err error
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("%s: %v", p.Page, p.Err)
+}
+
+// Check loads each of pages on base and reports every problem it finds, in the order of pages. Each page is rendered
+// with its own input over the base's, and in every locale of the base's Catalog, so the base's input should hold
+// representative values for anything pages are normally given at request time.
+func Check(base *templatehandler.Base, pages ...string) []Problem {
+	var problems []Problem
+	for _, p := range pages {
+		for _, err := range checkPage(base, p) {
+			problems = append(problems, Problem{Page: p, Err: err})
+		}
+	}
+	return problems
+}
+
+func checkPage(base *templatehandler.Base, page string) []error {
+	// Parse errors and invalid input blocks are reported by New, and nothing more can be checked without the page.
+	h, err := templatehandler.New(base, page)
+	if err != nil {
+		return []error{err}
+	}
+
+	var errs []error
+	d, err := h.Diagnostics()
+	if err != nil {
+		return []error{err}
+	}
+	for _, name := range sortedKeys(d.Missing) {
+		errs = append(errs, fmt.Errorf("template %q is used by %s but not defined", name, strings.Join(d.Missing[name], ", ")))
+	}
+	for _, key := range sortedKeys(d.MissingInput) {
+		errs = append(errs, fmt.Errorf("input %q is used by %s but not supplied", key, strings.Join(d.MissingInput[key], ", ")))
+	}
+
+	locales := []string{""}
+	if base.Catalog != nil {
+		locales = append(locales, base.Catalog.Locales()...)
+	}
+	for _, l := range locales {
+		if err := render(h, base.Catalog, l); err != nil {
+			if l != "" {
+				err = fmt.Errorf("rendering in %s: %v", l, err)
+			}
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// render serves the page once, as a dynamic page, in locale, and returns the error if it fails to render.
+func render(h *templatehandler.TemplateHandler, c *i18n.Catalog, locale string) error {
+	var renderErr error
+	h.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		renderErr = err
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	if locale != "" {
+		cookie := c.Cookie
+		if cookie == "" {
+			cookie = i18n.DefaultCookie
+		}
+		r.AddCookie(&http.Cookie{Name: cookie, Value: locale})
+	}
+	noInput := func(w http.ResponseWriter, r *http.Request) map[string]interface{} { return nil }
+	h.Dynamic(noInput).ServeHTTP(httptest.NewRecorder(), r)
+	return renderErr
+}
+
+func sortedKeys(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,139 @@
+package templatehandler
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	texttemplate "text/template"
+
+	"github.com/mconbere/quitlikeapro/go/templatehandler/funcs"
+	"github.com/mconbere/quitlikeapro/go/templatehandler/i18n"
+)
+
+// LocaleKey is the key in a localized page's input that holds the locale it is rendered in, e.g. for
+// <html lang="{{ .Locale }}">.
+const LocaleKey = "Locale"
+
+// localized is a page's template and input for one locale.
+type localized struct {
+	tmpl  *template.Template
+	text  *texttemplate.Template
+	input map[string]interface{}
+}
+
+// locales returns the locales pages created from b are translated into.
+func (b *Base) locales() []string {
+	if b.Catalog == nil {
+		return nil
+	}
+	return b.Catalog.Locales()
+}
+
+// translator returns the "t" func for locale. Without a locale it translates into the default locale of the catalog,
+// and without a catalog it returns the key as is.
+func (b *Base) translator(locale string) func(string, ...interface{}) string {
+	return func(key string, args ...interface{}) string {
+		if b.Catalog == nil {
+			if len(args) > 0 {
+				return fmt.Sprintf(key, args...)
+			}
+			return key
+		}
+		l := locale
+		if l == "" {
+			l = b.Catalog.Default
+		}
+		return b.Catalog.Translate(l, key, args...)
+	}
+}
+
+// format returns how locale writes dates and numbers. Without a locale it's the default locale of the catalog, and
+// without a catalog US English.
+func (b *Base) format(locale string) *i18n.Format {
+	if locale == "" && b.Catalog != nil {
+		locale = b.Catalog.Default
+	}
+	return i18n.FormatFor(locale)
+}
+
+// dateFormatter returns the "formatDate" func for locale, e.g. {{ formatDate .Updated "long" }}. The date may be
+// anything funcs.Time accepts, and the style is "short", "medium", "long", "full" or a time.Format layout.
+func (b *Base) dateFormatter(locale string) func(interface{}, string) (string, error) {
+	return func(v interface{}, style string) (string, error) {
+		t, err := funcs.Time(v)
+		if err != nil {
+			return "", fmt.Errorf("formatDate: %v", err)
+		}
+		return b.format(locale).Date(t, style), nil
+	}
+}
+
+// numberFormatter returns the "formatNumber" func for locale, e.g. {{ formatNumber .Count }}, or
+// {{ formatNumber .Price 2 }} for a fixed number of decimal places.
+func (b *Base) numberFormatter(locale string) func(interface{}, ...int) (string, error) {
+	return func(v interface{}, decimals ...int) (string, error) {
+		var f float64
+		switch v := v.(type) {
+		case int:
+			f = float64(v)
+		case int64:
+			f = float64(v)
+		case float64:
+			f = v
+		case json.Number:
+			var err error
+			if f, err = v.Float64(); err != nil {
+				return "", fmt.Errorf("formatNumber: %v", err)
+			}
+		default:
+			return "", fmt.Errorf("formatNumber: %T is not a number", v)
+		}
+		d := -1
+		if len(decimals) > 0 {
+			d = decimals[0]
+		}
+		return b.format(locale).Number(f, d), nil
+	}
+}
+
+// localize makes a copy of the built page tmpl for each locale in inputs, with the "t" func translating into that
+// locale and the given input.
+func (b *Base) localize(tmpl *template.Template, inputs map[string]map[string]interface{}) (map[string]localized, error) {
+	if len(inputs) == 0 {
+		return nil, nil
+	}
+	out := make(map[string]localized)
+	for l, in := range inputs {
+		t, err := tmpl.Clone()
+		if err != nil {
+			return nil, err
+		}
+		t.Funcs(b.pageFuncs(t, l))
+		text, err := b.textTemplate(t, l)
+		if err != nil {
+			return nil, err
+		}
+		out[l] = localized{
+			tmpl:  t,
+			text:  text,
+			input: mergeMap(in, map[string]interface{}{LocaleKey: l}),
+		}
+	}
+	return out, nil
+}
+
+// locale returns the locale to render the page in for r, or "" if the page isn't translated.
+func (t *TemplateHandler) locale(r *http.Request) string {
+	if t.base.Catalog == nil || r == nil {
+		return ""
+	}
+	return t.base.Catalog.Negotiate(r)
+}
+
+// varyLocale records on w that the response depends on the headers the locale is negotiated from.
+func (t *TemplateHandler) varyLocale(w http.ResponseWriter) {
+	if t.base.Catalog != nil {
+		addVary(w.Header(), "Accept-Language", "Cookie")
+	}
+}
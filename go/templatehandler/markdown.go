@@ -0,0 +1,149 @@
+package templatehandler
+
+import (
+	"bytes"
+	"html/template"
+	"regexp"
+	"strings"
+
+	"github.com/alecthomas/chroma/v2"
+	"github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/alecthomas/chroma/v2/styles"
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/russross/blackfriday"
+)
+
+// The flags blackfriday.MarkdownCommon uses, which MarkdownOptions falls back to.
+const (
+	commonHTMLFlags = blackfriday.HTML_USE_XHTML |
+		blackfriday.HTML_USE_SMARTYPANTS |
+		blackfriday.HTML_SMARTYPANTS_FRACTIONS |
+		blackfriday.HTML_SMARTYPANTS_DASHES |
+		blackfriday.HTML_SMARTYPANTS_LATEX_DASHES
+
+	commonExtensions = blackfriday.EXTENSION_NO_INTRA_EMPHASIS |
+		blackfriday.EXTENSION_TABLES |
+		blackfriday.EXTENSION_FENCED_CODE |
+		blackfriday.EXTENSION_AUTOLINK |
+		blackfriday.EXTENSION_STRIKETHROUGH |
+		blackfriday.EXTENSION_SPACE_HEADERS |
+		blackfriday.EXTENSION_HEADER_IDS |
+		blackfriday.EXTENSION_BACKSLASH_LINE_BREAK |
+		blackfriday.EXTENSION_DEFINITION_LISTS
+)
+
+// MarkdownOptions configures the "markdown" template func. The zero value renders like blackfriday.MarkdownCommon.
+type MarkdownOptions struct {
+	// Extensions are blackfriday EXTENSION_* flags, e.g. EXTENSION_HARD_LINE_BREAK or EXTENSION_FOOTNOTES. If zero,
+	// blackfriday's common extensions are used.
+	Extensions int
+
+	// HTMLFlags are blackfriday HTML_* flags. If zero, blackfriday's common flags are used.
+	HTMLFlags int
+
+	// HighlightStyle, if set, is the name of a chroma style (e.g. "monokai") used to syntax highlight fenced code
+	// blocks that name their language. The highlighting uses inline styles, so no extra CSS is needed.
+	HighlightStyle string
+
+	// Sanitizer, if set, cleans the rendered HTML before it is marked safe for the page. Set it whenever user
+	// influenced content can reach the markdown func.
+	Sanitizer Sanitizer
+
+	// TOC gives headings without an explicit {#id} an id made from their text, and gives each page built from a
+	// Markdown file its table of contents under TOCKey, unless its front matter sets TOCKey itself.
+	TOC bool
+}
+
+// Sanitizer cleans HTML that may contain untrusted content. A *bluemonday.Policy is a Sanitizer.
+type Sanitizer interface {
+	SanitizeBytes([]byte) []byte
+}
+
+// UGCSanitizer returns a Sanitizer for user generated content, based on bluemonday's UGC policy: it keeps the
+// formatting Markdown produces, including code block language classes and the inline styles syntax highlighting uses,
+// and strips anything that could run script.
+func UGCSanitizer() Sanitizer {
+	p := bluemonday.UGCPolicy()
+	p.AllowStyles("color", "background-color", "font-weight", "font-style", "text-decoration", "display").
+		OnElements("pre", "span")
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^language-[\w-]+$`)).OnElements("code")
+	return p
+}
+
+// MarkdownWith is like Markdown, but renders with the given options.
+func MarkdownWith(t *template.Template, opts MarkdownOptions) func(string, interface{}) (template.HTML, error) {
+	return func(name string, in interface{}) (template.HTML, error) {
+		var b bytes.Buffer
+		if err := t.ExecuteTemplate(&b, name, in); err != nil {
+			return "", err
+		}
+		return template.HTML(renderMarkdown(b.Bytes(), opts)), nil
+	}
+}
+
+// markdownFlags returns the blackfriday HTML flags and extensions opts renders with.
+func markdownFlags(opts MarkdownOptions) (flags, extensions int) {
+	flags, extensions = opts.HTMLFlags, opts.Extensions
+	if flags == 0 {
+		flags = commonHTMLFlags
+	}
+	if extensions == 0 {
+		extensions = commonExtensions
+	}
+	if opts.TOC {
+		extensions |= blackfriday.EXTENSION_AUTO_HEADER_IDS
+	}
+	return flags, extensions
+}
+
+func renderMarkdown(in []byte, opts MarkdownOptions) []byte {
+	flags, extensions := markdownFlags(opts)
+	renderer := blackfriday.HtmlRenderer(flags, "", "")
+	if opts.HighlightStyle != "" {
+		renderer = &highlightRenderer{
+			Renderer:  renderer,
+			style:     styles.Get(opts.HighlightStyle),
+			formatter: html.New(),
+		}
+	}
+	out := blackfriday.MarkdownOptions(in, renderer, blackfriday.Options{Extensions: extensions})
+	if opts.Sanitizer != nil {
+		out = opts.Sanitizer.SanitizeBytes(out)
+	}
+	return out
+}
+
+// highlightRenderer is a blackfriday renderer that syntax highlights code blocks in languages chroma knows, and
+// renders everything else as the wrapped renderer does.
+type highlightRenderer struct {
+	blackfriday.Renderer
+	style     *chroma.Style
+	formatter *html.Formatter
+}
+
+func (h *highlightRenderer) BlockCode(out *bytes.Buffer, text []byte, lang string) {
+	var lexer chroma.Lexer
+	if fields := strings.Fields(lang); len(fields) > 0 {
+		lexer = lexers.Get(strings.TrimPrefix(fields[0], "."))
+	}
+	if lexer == nil {
+		h.Renderer.BlockCode(out, text, lang)
+		return
+	}
+
+	it, err := chroma.Coalesce(lexer).Tokenise(nil, string(text))
+	if err != nil {
+		h.Renderer.BlockCode(out, text, lang)
+		return
+	}
+	var b bytes.Buffer
+	if err := h.formatter.Format(&b, h.style, it); err != nil {
+		h.Renderer.BlockCode(out, text, lang)
+		return
+	}
+	if out.Len() > 0 {
+		out.WriteByte('\n')
+	}
+	out.Write(b.Bytes())
+}
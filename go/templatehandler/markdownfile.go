@@ -0,0 +1,177 @@
+package templatehandler
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// frontMatterFormats are the delimiters that can open and close the front matter of a Markdown file.
+var frontMatterFormats = []struct {
+	delim     string
+	format    string
+	unmarshal func([]byte, interface{}) error
+}{
+	{"---", "yaml", yaml.Unmarshal},
+	{"+++", "toml", toml.Unmarshal},
+}
+
+// splitFrontMatter splits a Markdown file into its front matter, if any, and its body.
+func splitFrontMatter(b []byte) (fm []byte, format string, unmarshal func([]byte, interface{}) error, body []byte) {
+	for _, f := range frontMatterFormats {
+		open := []byte(f.delim + "\n")
+		if !bytes.HasPrefix(b, open) {
+			continue
+		}
+		rest := b[len(open):]
+		end := bytes.Index(rest, []byte("\n"+f.delim+"\n"))
+		if end < 0 {
+			continue
+		}
+		return rest[:end], f.format, f.unmarshal, rest[end+len(f.delim)+2:]
+	}
+	return nil, "", nil, b
+}
+
+func parseFrontMatter(name string, b []byte) (map[string]interface{}, error) {
+	fm, format, unmarshal, _ := splitFrontMatter(b)
+	input := make(map[string]interface{})
+	if fm == nil {
+		return input, nil
+	}
+	if err := unmarshal(fm, &input); err != nil {
+		return nil, fmt.Errorf("%s unmarshaling of front matter in %q failed: %v", format, name, err)
+	}
+	return input, nil
+}
+
+// markdownFile renders the Markdown file at name, read from the same filesystem as the base template, without its
+// front matter.
+func (b *Base) markdownFile(name string) (template.HTML, error) {
+	in, err := source{fsys: b.src.fsys, path: name}.readFile()
+	if err != nil {
+		return "", err
+	}
+	_, _, _, body := splitFrontMatter(in)
+	return template.HTML(renderMarkdown(body, b.Markdown)), nil
+}
+
+// MarkdownDir registers a static page on mux for every .md file under dir, read from the same filesystem as the base
+// template. The route is the file's path below dir without the extension, so dir/about.md is served at /about and
+// dir/docs/index.md at /docs/. Other paths below /docs/ are served by Base.NotFound.
+//
+// Each page is built from layouts, if any, followed by the Markdown file, which defines a "markdown" block with the
+// rendered file (and a "content" block that includes it, unless a layout defines "content" itself). The file's front
+// matter, YAML between "---" lines or TOML between "+++" lines, is merged into the page's input. Every page is rendered
+// before MarkdownDir returns, so a broken page is reported as an error. If the layouts define an "amp" template, each
+// page's AMP variant is registered under AMPPrefix too.
+//
+// A page whose front matter sets "Breadcrumb" is declared with TemplateHandler.Breadcrumb under that title, with the
+// front matter's "Parent" as its parent, or "/" if it has none.
+//
+// If the Base has a Sitemap, each page is listed in it, last modified when its file was, and with the front matter's
+// "ChangeFreq" and "Priority", if any. If the Base has API set, each page's input is served as JSON under APIPrefix.
+// If the Base's Markdown sets TOC, each page's input has the file's table of contents under TOCKey.
+func (b *Base) MarkdownDir(mux *http.ServeMux, dir string, layouts ...string) error {
+	var srcs []source
+	for _, l := range layouts {
+		srcs = append(srcs, source{fsys: b.src.fsys, path: l})
+	}
+
+	// Pages are rendered once they've all been declared, so that each has its full breadcrumb trail.
+	type pending struct {
+		path   string
+		static *staticHandler
+	}
+	var pages []pending
+
+	walk := func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || path.Ext(p) != ".md" {
+			return nil
+		}
+
+		rel := strings.TrimPrefix(filepath.ToSlash(p), filepath.ToSlash(dir))
+		route := "/" + strings.TrimPrefix(strings.TrimSuffix(rel, ".md"), "/")
+		index := path.Base(route) == "index"
+		if index {
+			route = strings.TrimSuffix(route, "index")
+		}
+
+		page := append(srcs[:len(srcs):len(srcs)], source{fsys: b.src.fsys, path: p, markdown: true})
+		h, err := newHandler(b, page)
+		if err != nil {
+			return err
+		}
+		if title, ok := h.Input["Breadcrumb"].(string); ok {
+			parent, ok := h.Input["Parent"].(string)
+			if !ok && route != "/" {
+				parent = "/"
+			}
+			h.Breadcrumb(route, title, parent)
+		}
+		if b.Sitemap != nil {
+			e := SitemapEntry{Path: route}
+			if fi, err := d.Info(); err == nil {
+				e.LastMod = fi.ModTime()
+			}
+			e.ChangeFreq, _ = h.Input["ChangeFreq"].(string)
+			switch v := h.Input["Priority"].(type) {
+			case float64:
+				e.Priority = v
+			case int:
+				e.Priority = float64(v)
+			case int64:
+				e.Priority = float64(v)
+			}
+			b.Sitemap.Add(e)
+		}
+		static := h.Static(nil)
+		pages = append(pages, pending{p, static})
+		routes := []string{route}
+		handlers := []http.Handler{static}
+		if h.Template.Lookup(ampVariant) != nil {
+			routes = append(routes, AMPPrefix+strings.TrimPrefix(route, "/"))
+			handlers = append(handlers, static)
+		}
+		if b.API {
+			routes = append(routes, APIPrefix+strings.TrimPrefix(route, "/"))
+			handlers = append(handlers, h.JSON())
+		}
+		for i, r := range routes {
+			if index {
+				// An index route ends in a slash, so keep it from serving every path below its directory.
+				mux.Handle(r, Exact(r, handlers[i], b.NotFound))
+			} else {
+				mux.Handle(r, handlers[i])
+			}
+		}
+		return nil
+	}
+
+	var err error
+	if b.src.fsys == nil {
+		err = filepath.WalkDir(dir, walk)
+	} else {
+		err = fs.WalkDir(b.src.fsys, dir, walk)
+	}
+	if err != nil {
+		return err
+	}
+	for _, p := range pages {
+		if err := p.static.PreRender(); err != nil {
+			return fmt.Errorf("could not render %q: %v", p.path, err)
+		}
+	}
+	return nil
+}
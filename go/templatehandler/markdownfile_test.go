@@ -0,0 +1,55 @@
+package templatehandler_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/mconbere/quitlikeapro/go/templatehandler"
+)
+
+// markdownPages is a site with an index page at /docs/ and a page below it.
+var markdownPages = fstest.MapFS{
+	"base.html":              {Data: []byte(`{{ define "base" }}<html><body>{{ template "content" . }}</body></html>{{ end }}`)},
+	"content/docs/index.md":  {Data: []byte("# Docs\n")},
+	"content/docs/guide.md":  {Data: []byte("# Guide\n")},
+	"content/docs/more/a.md": {Data: []byte("# A\n")},
+}
+
+// TestMarkdownDirIndex checks that an index page is served at its directory's path, which a {$} pattern isn't under
+// the Go 1.21 mux rules the GOPATH build and App Engine use, and that other paths below it go to Base.NotFound.
+func TestMarkdownDirIndex(t *testing.T) {
+	base, err := templatehandler.NewBaseFS(markdownPages, "base.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	base.NotFound = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, "not found page")
+	})
+	mux := http.NewServeMux()
+	if err := base.MarkdownDir(mux, "content"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		path string
+		code int
+		body string
+	}{
+		{"/docs/", http.StatusOK, "Docs"},
+		{"/docs/guide", http.StatusOK, "Guide"},
+		{"/docs/more/a", http.StatusOK, "A"},
+		{"/docs/nope", http.StatusNotFound, "not found page"},
+		{"/docs/more/", http.StatusNotFound, "not found page"},
+	} {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+		if w.Code != tt.code || !strings.Contains(w.Body.String(), tt.body) {
+			t.Errorf("GET %s = %d %q, want %d with %q", tt.path, w.Code, w.Body, tt.code, tt.body)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+// Package memcache is a templatehandler.Store backed by a memcached server, such as Memorystore for Memcached, so that
+// every instance of a site can share what the others have rendered.
+//
+// It speaks memcached's text protocol, and only the get, set and delete commands a Store needs.
+package memcache
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/mconbere/quitlikeapro/go/templatehandler"
+)
+
+// DefaultTimeout is how long a command may take when Client.Timeout is unset.
+const DefaultTimeout = 100 * time.Millisecond
+
+// maxIdle is how many connections a Client keeps open between commands.
+const maxIdle = 8
+
+// Client is a templatehandler.Store on the memcached server at Addr.
+type Client struct {
+	// Addr is the server's host:port.
+	Addr string
+
+	// Timeout bounds each command, including connecting. A cache slower than rendering isn't worth waiting for.
+	Timeout time.Duration
+
+	mu   sync.Mutex
+	idle []*conn
+}
+
+var _ templatehandler.Store = (*Client)(nil)
+
+// New returns a Client for the memcached server at addr.
+func New(addr string) *Client {
+	return &Client{Addr: addr}
+}
+
+type conn struct {
+	nc net.Conn
+	rw *bufio.ReadWriter
+}
+
+func (c *Client) timeout() time.Duration {
+	if c.Timeout <= 0 {
+		return DefaultTimeout
+	}
+	return c.Timeout
+}
+
+func (c *Client) get() (*conn, error) {
+	c.mu.Lock()
+	if n := len(c.idle); n > 0 {
+		cn := c.idle[n-1]
+		c.idle = c.idle[:n-1]
+		c.mu.Unlock()
+		return cn, nil
+	}
+	c.mu.Unlock()
+
+	nc, err := net.DialTimeout("tcp", c.Addr, c.timeout())
+	if err != nil {
+		return nil, err
+	}
+	return &conn{nc: nc, rw: bufio.NewReadWriter(bufio.NewReader(nc), bufio.NewWriter(nc))}, nil
+}
+
+// put returns cn for reuse, or closes it if the command on it failed, since the stream may be out of step.
+func (c *Client) put(cn *conn, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil || len(c.idle) >= maxIdle {
+		cn.nc.Close()
+		return
+	}
+	c.idle = append(c.idle, cn)
+}
+
+// do runs f on a connection with the command's deadline set.
+func (c *Client) do(f func(rw *bufio.ReadWriter) error) error {
+	cn, err := c.get()
+	if err != nil {
+		return err
+	}
+	cn.nc.SetDeadline(time.Now().Add(c.timeout()))
+	err = f(cn.rw)
+	if err == templatehandler.ErrCacheMiss {
+		c.put(cn, nil)
+	} else {
+		c.put(cn, err)
+	}
+	return err
+}
+
+// checkKey rejects keys memcached would, which would otherwise corrupt the command.
+func checkKey(key string) error {
+	if len(key) == 0 || len(key) > 250 {
+		return fmt.Errorf("memcache: key %q must be 1 to 250 bytes", key)
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] <= ' ' || key[i] == 0x7f {
+			return fmt.Errorf("memcache: key %q has spaces or control characters", key)
+		}
+	}
+	return nil
+}
+
+// Get returns the value stored under key, or templatehandler.ErrCacheMiss.
+func (c *Client) Get(key string) ([]byte, error) {
+	if err := checkKey(key); err != nil {
+		return nil, err
+	}
+	var value []byte
+	err := c.do(func(rw *bufio.ReadWriter) error {
+		fmt.Fprintf(rw, "get %s\r\n", key)
+		if err := rw.Flush(); err != nil {
+			return err
+		}
+		line, err := readLine(rw)
+		if err != nil {
+			return err
+		}
+		if line == "END" {
+			return templatehandler.ErrCacheMiss
+		}
+		// VALUE <key> <flags> <bytes>
+		var k string
+		var flags, size int
+		if _, err := fmt.Sscanf(line, "VALUE %s %d %d", &k, &flags, &size); err != nil {
+			return fmt.Errorf("memcache: unexpected reply %q", line)
+		}
+		value = make([]byte, size+2)
+		if _, err := io.ReadFull(rw, value); err != nil {
+			return err
+		}
+		value = value[:size]
+		if line, err := readLine(rw); err != nil || line != "END" {
+			return fmt.Errorf("memcache: unexpected reply %q: %v", line, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// Set stores value under key for ttl, rounded up to whole seconds, or until evicted if ttl is zero.
+func (c *Client) Set(key string, value []byte, ttl time.Duration) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	exp := int64(0)
+	if ttl > 0 {
+		exp = int64((ttl + time.Second - 1) / time.Second)
+		// memcached reads expirations over 30 days as Unix times.
+		if exp > 30*24*60*60 {
+			exp = time.Now().Add(ttl).Unix()
+		}
+	}
+	return c.do(func(rw *bufio.ReadWriter) error {
+		fmt.Fprintf(rw, "set %s 0 %d %d\r\n", key, exp, len(value))
+		rw.Write(value)
+		rw.WriteString("\r\n")
+		if err := rw.Flush(); err != nil {
+			return err
+		}
+		return expect(rw, "STORED")
+	})
+}
+
+// Delete removes key, if it's there.
+func (c *Client) Delete(key string) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	return c.do(func(rw *bufio.ReadWriter) error {
+		fmt.Fprintf(rw, "delete %s\r\n", key)
+		if err := rw.Flush(); err != nil {
+			return err
+		}
+		return expect(rw, "DELETED", "NOT_FOUND")
+	})
+}
+
+func readLine(rw *bufio.ReadWriter) (string, error) {
+	line, err := rw.ReadSlice('\n')
+	if err != nil {
+		return "", err
+	}
+	return string(bytes.TrimSuffix(line, []byte("\r\n"))), nil
+}
+
+// expect reads a reply line and fails unless it's one of want.
+func expect(rw *bufio.ReadWriter, want ...string) error {
+	line, err := readLine(rw)
+	if err != nil {
+		return err
+	}
+	for _, w := range want {
+		if line == w {
+			return nil
+		}
+	}
+	return fmt.Errorf("memcache: unexpected reply %q", line)
+}
@@ -0,0 +1,147 @@
+package templatehandler
+
+import (
+	"fmt"
+	"html/template"
+	"net/url"
+	"strings"
+)
+
+// MetaKey is the input key under which pages describe themselves for link previews, so that links shared on social
+// sites show the page's title, summary and picture. It's an object with any of "Title", "Description", "Image",
+// "Type", "Canonical", "Card" (the Twitter Card type) and "Twitter" (the site's @handle):
+//
+//	{{ define "input" }}{"Meta": {"Image": "/static/img/vim.png", "Type": "article"}}{{ end }}
+//
+// The base's input can set defaults for every page under the same key, which a page's keys are merged over one by one.
+// The "meta" func renders the resulting tags.
+const MetaKey = "Meta"
+
+// Meta is how a page is described in link previews, as OpenGraph and Twitter Card meta tags.
+type Meta struct {
+	Title       string
+	Description string
+	Image       string
+	Type        string
+	Canonical   string
+	SiteName    string
+	Card        string
+	Twitter     string
+}
+
+// metaFields are the keys of a MetaKey object, in the order they are looked up.
+var metaFields = []string{"Title", "Description", "Image", "Type", "Canonical", "Card", "Twitter"}
+
+// MetaFor returns the description of the page with the given input. Each field is taken from the MetaKey object,
+// falling back to the page's top-level "Title", "Description" and "Image", and then to the site configuration's
+// "Title" (as SiteName), "Image" and "Social.Twitter". The type defaults to "website", the card to "summary" and the
+// canonical URL to what the "canonicalURL" func returns. Image and Canonical are made absolute against the site's
+// BaseURL, or the request's origin without one, since previews are fetched from elsewhere.
+func MetaFor(input map[string]interface{}) Meta {
+	site, _ := input[SiteKey].(map[string]interface{})
+	social, _ := site["Social"].(map[string]interface{})
+	m := Meta{
+		Title:       metaString(input["Title"]),
+		Description: metaString(input["Description"]),
+		Image:       metaString(input["Image"]),
+		Type:        "website",
+		Card:        "summary",
+		SiteName:    metaString(site["Title"]),
+	}
+	if m.Image == "" {
+		m.Image = metaString(site["Image"])
+	}
+	m.Twitter = metaString(social["Twitter"])
+
+	req, _ := input[RequestKey].(*Request)
+	m.Canonical = canonicalURLFunc(input)
+
+	if fields, ok := input[MetaKey].(map[string]interface{}); ok {
+		for _, k := range metaFields {
+			v := metaString(fields[k])
+			if v == "" {
+				continue
+			}
+			switch k {
+			case "Title":
+				m.Title = v
+			case "Description":
+				m.Description = v
+			case "Image":
+				m.Image = v
+			case "Type":
+				m.Type = v
+			case "Canonical":
+				m.Canonical = v
+			case "Card":
+				m.Card = v
+			case "Twitter":
+				m.Twitter = v
+			}
+		}
+	}
+	if m.Twitter != "" && !strings.HasPrefix(m.Twitter, "@") {
+		m.Twitter = "@" + m.Twitter
+	}
+
+	origin := strings.TrimSuffix(metaString(site["BaseURL"]), "/")
+	if origin == "" && req != nil {
+		origin = (&url.URL{Scheme: req.scheme, Host: req.Host}).String()
+	}
+	m.Image = resolveURL(origin, m.Image)
+	m.Canonical = resolveURL(origin, m.Canonical)
+	return m
+}
+
+// metaString returns v as a string, or "" if it's missing.
+func metaString(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	return fmt.Sprint(v)
+}
+
+// resolveURL resolves ref against origin, if both are set and ref is valid.
+func resolveURL(origin, ref string) string {
+	if origin == "" || ref == "" {
+		return ref
+	}
+	o, err := url.Parse(origin)
+	if err != nil {
+		return ref
+	}
+	u, err := url.Parse(ref)
+	if err != nil {
+		return ref
+	}
+	return o.ResolveReference(u).String()
+}
+
+var metaTemplate = template.Must(template.New("meta").Parse(`
+{{- with .Title }}<meta property="og:title" content="{{ . }}">{{ end }}
+{{- with .Description }}
+<meta property="og:description" content="{{ . }}">{{ end }}
+<meta property="og:type" content="{{ .Type }}">
+{{- with .Canonical }}
+<meta property="og:url" content="{{ . }}">{{ end }}
+{{- with .Image }}
+<meta property="og:image" content="{{ . }}">{{ end }}
+{{- with .SiteName }}
+<meta property="og:site_name" content="{{ . }}">{{ end }}
+<meta name="twitter:card" content="{{ .Card }}">
+{{- with .Twitter }}
+<meta name="twitter:site" content="{{ . }}">{{ end }}
+{{- with .Title }}
+<meta name="twitter:title" content="{{ . }}">{{ end }}
+{{- with .Description }}
+<meta name="twitter:description" content="{{ . }}">{{ end }}
+{{- with .Image }}
+<meta name="twitter:image" content="{{ . }}">{{ end }}`))
+
+// meta is the "meta" func. It renders the OpenGraph and Twitter Card tags describing the page with the given input,
+// as returned by MetaFor, for the page's head: {{ meta . }}
+func meta(input map[string]interface{}) (template.HTML, error) {
+	var b strings.Builder
+	err := metaTemplate.Execute(&b, MetaFor(input))
+	return template.HTML(b.String()), err
+}
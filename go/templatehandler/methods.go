@@ -0,0 +1,61 @@
+package templatehandler
+
+import (
+	"net/http"
+	"strings"
+)
+
+// methodSet lets a handler's users choose which request methods it serves.
+type methodSet struct {
+	// Methods are the request methods the handler serves, and HEAD wherever GET is. OPTIONS requests are answered
+	// with them in the Allow header, and requests with any other method get a 405. If nil, the handler's default is
+	// used: GET for static handlers, and GET and POST for dynamic and form ones.
+	Methods []string
+
+	defaultMethods []string
+}
+
+// allowed returns the methods the handler serves, including the implied HEAD and OPTIONS.
+func (m *methodSet) allowed() []string {
+	methods := m.Methods
+	if methods == nil {
+		methods = m.defaultMethods
+	}
+	var allowed []string
+	for _, method := range methods {
+		allowed = append(allowed, method)
+		if method == http.MethodGet && !contains(methods, http.MethodHead) {
+			allowed = append(allowed, http.MethodHead)
+		}
+	}
+	if !contains(methods, http.MethodOptions) {
+		allowed = append(allowed, http.MethodOptions)
+	}
+	return allowed
+}
+
+// allow answers OPTIONS requests and rejects requests with a method the handler doesn't serve, and reports whether
+// the handler should go on to serve r.
+func (m *methodSet) allow(w http.ResponseWriter, r *http.Request) bool {
+	allowed := m.allowed()
+	switch {
+	case r.Method == http.MethodOptions && !contains(m.Methods, http.MethodOptions):
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		w.WriteHeader(http.StatusNoContent)
+		return false
+	case !contains(allowed, r.Method):
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return false
+	}
+	return true
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
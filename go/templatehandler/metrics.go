@@ -0,0 +1,70 @@
+package templatehandler
+
+import (
+	"expvar"
+	"time"
+)
+
+// Metrics receives measurements of how pages render, for reporting to a monitoring system. Pages are identified by
+// TemplateHandler.Name. Implementations must be safe for concurrent use.
+type Metrics interface {
+	// Rendered is called after each time a page is executed, with how long it took and the error if it failed.
+	Rendered(page string, d time.Duration, err error)
+
+	// StaticCache is called for each request to a static handler, with whether its cached response could be used.
+	StaticCache(page string, hit bool)
+}
+
+// ExpvarMetrics returns Metrics that publish counters per page under the given expvar name: "renders", "errors",
+// "render_nanos" (the total time spent rendering), "cache_hits" and "cache_misses".
+func ExpvarMetrics(name string) Metrics {
+	m := &expvarMetrics{
+		renders:     new(expvar.Map),
+		errors:      new(expvar.Map),
+		renderNanos: new(expvar.Map),
+		cacheHits:   new(expvar.Map),
+		cacheMisses: new(expvar.Map),
+	}
+	v := expvar.NewMap(name)
+	v.Set("renders", m.renders)
+	v.Set("errors", m.errors)
+	v.Set("render_nanos", m.renderNanos)
+	v.Set("cache_hits", m.cacheHits)
+	v.Set("cache_misses", m.cacheMisses)
+	return m
+}
+
+type expvarMetrics struct {
+	renders, errors, renderNanos *expvar.Map
+	cacheHits, cacheMisses       *expvar.Map
+}
+
+func (m *expvarMetrics) Rendered(page string, d time.Duration, err error) {
+	m.renders.Add(page, 1)
+	m.renderNanos.Add(page, int64(d))
+	if err != nil {
+		m.errors.Add(page, 1)
+	}
+}
+
+func (m *expvarMetrics) StaticCache(page string, hit bool) {
+	if hit {
+		m.cacheHits.Add(page, 1)
+	} else {
+		m.cacheMisses.Add(page, 1)
+	}
+}
+
+// rendered reports a render of the page that started at start to the Base's Metrics, if any.
+func (t *TemplateHandler) rendered(start time.Time, err error) {
+	if m := t.base.Metrics; m != nil {
+		m.Rendered(t.Name, time.Since(start), err)
+	}
+}
+
+// staticCache reports a static cache lookup to the Base's Metrics, if any.
+func (t *TemplateHandler) staticCache(hit bool) {
+	if m := t.base.Metrics; m != nil {
+		m.StaticCache(t.Name, hit)
+	}
+}
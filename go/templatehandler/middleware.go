@@ -0,0 +1,39 @@
+package templatehandler
+
+import "net/http"
+
+// Middleware wraps a handler with additional behavior, such as logging, auth or rate limiting.
+type Middleware func(http.Handler) http.Handler
+
+// Chain wraps h in each of the middleware in turn, so that the first one given sees the request first.
+func Chain(h http.Handler, middleware ...Middleware) http.Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		h = middleware[i](h)
+	}
+	return h
+}
+
+// With returns the handler wrapped in the given middleware, the first one outermost.
+func (d *dynamicHandler) With(middleware ...Middleware) http.Handler {
+	return Chain(d, middleware...)
+}
+
+// With returns the handler wrapped in the given middleware, the first one outermost.
+func (s *staticHandler) With(middleware ...Middleware) http.Handler {
+	return Chain(s, middleware...)
+}
+
+// With returns the handler wrapped in the given middleware, the first one outermost.
+func (f *formHandler) With(middleware ...Middleware) http.Handler {
+	return Chain(f, middleware...)
+}
+
+// With returns the handler wrapped in the given middleware, the first one outermost.
+func (j *jsonHandler) With(middleware ...Middleware) http.Handler {
+	return Chain(j, middleware...)
+}
+
+// With returns the handler wrapped in the given middleware, the first one outermost.
+func (h *funcHandler) With(middleware ...Middleware) http.Handler {
+	return Chain(h, middleware...)
+}
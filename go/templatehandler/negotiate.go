@@ -0,0 +1,223 @@
+package templatehandler
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// APIPrefix is the path prefix under which MarkdownDir serves each page's input as JSON, when the Base has API set,
+// e.g. /api/about for /about.
+const APIPrefix = "/api/"
+
+// wantsJSON reports whether r asks for the page data as JSON, either with a format=json query parameter or with an
+// Accept header that lists application/json but not text/html.
+func wantsJSON(r *http.Request) bool {
+	if r.URL.Query().Get("format") == "json" {
+		return true
+	}
+	var hasJSON, hasHTML bool
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		t, _, err := mime.ParseMediaType(part)
+		if err != nil {
+			continue
+		}
+		switch t {
+		case "application/json":
+			hasJSON = true
+		case "text/html":
+			hasHTML = true
+		}
+	}
+	return hasJSON && !hasHTML
+}
+
+// serveJSON responds with the page's merged input as JSON instead of rendering its templates.
+func (t *TemplateHandler) serveJSON(w http.ResponseWriter, r *http.Request, input map[string]interface{}) {
+	_, pageInput, err := t.current(t.locale(r))
+	if err != nil {
+		t.handleError(w, r, err)
+		return
+	}
+	b, err := json.Marshal(t.base.Merge.merge(pageInput, input))
+	if err != nil {
+		t.handleError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	addVary(w.Header(), "Accept")
+	t.varyLocale(w)
+	w.Write(b)
+}
+
+// jsonHandler serves a page's merged input as JSON, for clients that want the data behind the page rather than HTML.
+type jsonHandler struct {
+	headerHooks
+	methodSet
+	t *TemplateHandler
+}
+
+// JSON returns a handler that serves the page's merged input, its base's and its own, as JSON, like ServeJSON does
+// but at a URL of its own, so that a page can have a machine-readable twin, e.g. under APIPrefix. Like a static page it
+// is cacheable for DefaultStaticCache, or revalidated on every request with live reloading on, and answers conditional
+// requests with its ETag.
+func (t *TemplateHandler) JSON() *jsonHandler {
+	cache := DefaultStaticCache
+	if t.base.LiveReload {
+		cache = CachePolicy{NoCache: true}
+	}
+	return &jsonHandler{
+		headerHooks: headerHooks{defaultCache: cache},
+		methodSet:   methodSet{defaultMethods: []string{http.MethodGet}},
+		t:           t,
+	}
+}
+
+func (j *jsonHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if a := j.t.logAccess(w, r); a != nil {
+		w = a
+		defer a.log()
+	}
+	if !j.allow(w, r) {
+		return
+	}
+	_, input, err := j.t.current(j.t.locale(r))
+	if err != nil {
+		j.t.handleError(w, r, err)
+		return
+	}
+	b, err := json.Marshal(input)
+	if err != nil {
+		j.t.handleError(w, r, err)
+		return
+	}
+	j.t.varyLocale(w)
+	if err := writeJSON(w, r, &j.headerHooks, b); err != nil {
+		j.t.handleError(w, r, err)
+	}
+}
+
+// writeJSON responds with b, a marshaled JSON value, compressed if the client accepts it and with a strong ETag, so
+// that conditional requests get a 304. hh's headers are applied to the response. Responses to methods other than GET
+// and HEAD, such as a POST's result, aren't representations to revalidate or fetch in ranges of, so they get neither an
+// ETag nor Accept-Ranges.
+func writeJSON(w http.ResponseWriter, r *http.Request, hh *headerHooks, b []byte) error {
+	sum := sha256.Sum256(b)
+	etag := fmt.Sprintf("%x", sum[:16])
+
+	body := b
+	enc := negotiateEncoding(r)
+	if enc != "" {
+		var err error
+		if body, err = compress(enc, b); err != nil {
+			return fmt.Errorf("could not compress JSON: %v", err)
+		}
+		etag += "-" + enc
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	hh.applyHeaders(w.Header())
+	setEncodingHeaders(w.Header(), enc, b)
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.Write(body)
+		return nil
+	}
+	w.Header().Set("ETag", strconv.Quote(etag))
+	if enc != "" {
+		setEncodedLength(w.Header(), r, len(body))
+	}
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(body))
+	return nil
+}
+
+// ErrNotFound is returned by a JSONFunc's func when there is nothing at the requested URL, to respond with a 404.
+var ErrNotFound = errors.New("templatehandler: not found")
+
+// funcHandler serves the value its func returns for each request as JSON.
+type funcHandler struct {
+	headerHooks
+	methodSet
+
+	// OutputCache, if set, caches the JSON served to GET and HEAD requests, so that the func isn't called for every
+	// one of them. Errors aren't cached.
+	OutputCache *OutputCache
+
+	f func(r *http.Request) (interface{}, error)
+}
+
+// jsonFuncPage is the page name a JSONFunc's responses are cached under by its OutputCache.
+const jsonFuncPage = "templatehandler:JSONFunc"
+
+// JSONFunc returns a handler that serves the value f returns for each request as JSON, with an ETag and compression as
+// TemplateHandler.JSON has, for APIs over data that isn't a page's input. If f returns ErrNotFound the response is a
+// 404, and if it fails otherwise the error is logged and the response is a 500; either way the body is a JSON object
+// with an "error" message. By default it serves GET, and the response is cacheable for DefaultDynamicCache.
+func JSONFunc(f func(r *http.Request) (interface{}, error)) *funcHandler {
+	return &funcHandler{
+		headerHooks: headerHooks{defaultCache: DefaultDynamicCache},
+		methodSet:   methodSet{defaultMethods: []string{http.MethodGet}},
+		f:           f,
+	}
+}
+
+func (h *funcHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !h.allow(w, r) {
+		return
+	}
+	oc := h.OutputCache
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		oc = nil
+	}
+	var key string
+	if oc != nil {
+		key = oc.key(jsonFuncPage, "", "", r)
+		oc.vary(w.Header())
+		b, err := oc.Store.Get(key)
+		if err == nil {
+			if err := writeJSON(w, r, &h.headerHooks, b); err != nil {
+				logError(r, err)
+				jsonError(w, http.StatusInternalServerError)
+			}
+			return
+		}
+		if err != ErrCacheMiss {
+			logError(r, err)
+		}
+	}
+
+	v, err := h.f(r)
+	if err == nil {
+		var b []byte
+		if b, err = json.Marshal(v); err == nil {
+			if oc != nil {
+				if err := oc.Store.Set(key, b, oc.TTL); err != nil {
+					logError(r, err)
+				}
+			}
+			err = writeJSON(w, r, &h.headerHooks, b)
+		}
+	}
+	switch {
+	case err == ErrNotFound:
+		jsonError(w, http.StatusNotFound)
+	case err != nil:
+		logError(r, err)
+		jsonError(w, http.StatusInternalServerError)
+	}
+}
+
+// jsonError responds with code and a JSON object giving its status text as the "error".
+func jsonError(w http.ResponseWriter, code int) {
+	b, _ := json.Marshal(map[string]string{"error": http.StatusText(code)})
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", CachePolicy{NoStore: true}.String())
+	w.WriteHeader(code)
+	w.Write(b)
+}
@@ -0,0 +1,133 @@
+package templatehandler
+
+import (
+	"html/template"
+	"io/fs"
+	"net/http"
+
+	"github.com/mconbere/quitlikeapro/go/templatehandler/i18n"
+)
+
+// Option configures a Base as NewBase creates it.
+type Option func(*options)
+
+type options struct {
+	fsys      fs.FS
+	input     map[string]interface{}
+	funcs     []template.FuncMap
+	delims    [2]string
+	configure []func(*Base)
+	partials  []func(*Base) error
+}
+
+// WithInput sets the input every page created from the base starts from.
+func WithInput(input map[string]interface{}) Option {
+	return func(o *options) { o.input = input }
+}
+
+// WithFuncs makes funcs available to the base template and to every page template created from it. They are added
+// to the helpers from package funcs, replacing any with the same name.
+func WithFuncs(funcs template.FuncMap) Option {
+	return func(o *options) { o.funcs = append(o.funcs, funcs) }
+}
+
+// WithFS reads the base template, and its partials, from fsys rather than the local filesystem.
+func WithFS(fsys fs.FS) Option {
+	return func(o *options) { o.fsys = fsys }
+}
+
+// WithDelims sets the action delimiters of the base template and every page template created from it. Empty
+// delimiters mean the default "{{" and "}}".
+func WithDelims(left, right string) Option {
+	return func(o *options) { o.delims = [2]string{left, right} }
+}
+
+// WithBases layers the templates tmpls over the base template in order, so that a site's shell, nav, footer and shared
+// components can each be kept in a base file of their own rather than in one monolithic base. Each page is built
+// with them as its first layouts: a later file can redefine the blocks of the ones before it, and each file's input
+// block is merged over theirs. They are read from the same filesystem as the base template.
+func WithBases(tmpls ...string) Option {
+	return configure(func(b *Base) {
+		for _, tmpl := range tmpls {
+			b.layers = append(b.layers, source{fsys: b.src.fsys, path: tmpl})
+		}
+	})
+}
+
+// WithPartials adds the templates matching pattern to the base, as Base.ParseGlob does.
+func WithPartials(pattern string) Option {
+	return func(o *options) {
+		o.partials = append(o.partials, func(b *Base) error { return b.ParseGlob(pattern) })
+	}
+}
+
+// WithPartialsDir adds the templates in dir to the base, as Base.ParseDir does.
+func WithPartialsDir(dir string) Option {
+	return func(o *options) {
+		o.partials = append(o.partials, func(b *Base) error { return b.ParseDir(dir) })
+	}
+}
+
+func configure(f func(*Base)) Option {
+	return func(o *options) { o.configure = append(o.configure, f) }
+}
+
+// WithLiveReload sets Base.LiveReload.
+func WithLiveReload(on bool) Option {
+	return configure(func(b *Base) { b.LiveReload = on })
+}
+
+// WithStrict sets Base.StrictMode.
+func WithStrict(on bool) Option {
+	return configure(func(b *Base) { b.StrictMode = on })
+}
+
+// WithErrorHandler sets Base.ErrorHandler.
+func WithErrorHandler(h ErrorHandler) Option {
+	return configure(func(b *Base) { b.ErrorHandler = h })
+}
+
+// WithMarkdown sets Base.Markdown.
+func WithMarkdown(opts MarkdownOptions) Option {
+	return configure(func(b *Base) { b.Markdown = opts })
+}
+
+// WithOptionalBlocks sets Base.OptionalBlocks.
+func WithOptionalBlocks(names ...string) Option {
+	return configure(func(b *Base) { b.OptionalBlocks = names })
+}
+
+// WithNotFound sets Base.NotFound.
+func WithNotFound(h http.Handler) Option {
+	return configure(func(b *Base) { b.NotFound = h })
+}
+
+// WithMetrics sets Base.Metrics.
+func WithMetrics(m Metrics) Option {
+	return configure(func(b *Base) { b.Metrics = m })
+}
+
+// WithAccessLog sets Base.AccessLog.
+func WithAccessLog(l AccessLog) Option {
+	return configure(func(b *Base) { b.AccessLog = l })
+}
+
+// WithSharedCache sets Base.SharedCache.
+func WithSharedCache(s *VersionedStore) Option {
+	return configure(func(b *Base) { b.SharedCache = s })
+}
+
+// WithCDN sets Base.CDN.
+func WithCDN(c *CDN) Option {
+	return configure(func(b *Base) { b.CDN = c })
+}
+
+// WithCatalog sets Base.Catalog.
+func WithCatalog(c *i18n.Catalog) Option {
+	return configure(func(b *Base) { b.Catalog = c })
+}
+
+// WithMerge sets Base.Merge.
+func WithMerge(m MergeStrategy) Option {
+	return configure(func(b *Base) { b.Merge = m })
+}
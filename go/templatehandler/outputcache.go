@@ -0,0 +1,92 @@
+package templatehandler
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// OutputCache caches a dynamic handler's rendered pages, or a JSONFunc's responses, so that pages which rarely change
+// aren't rendered again for every request. A cached page is served without calling the handler's input func at all, so only use it for
+// handlers whose func doesn't write to the response itself.
+//
+// Pages are cached per path, locale and variant, and per each of the declared dimensions the page depends on. Any
+// other query parameters, cookies and headers are ignored, so declare everything the input func reads.
+type OutputCache struct {
+	// Store holds the rendered pages, e.g. NewMemoryStore(1000).
+	Store Store
+
+	// TTL is how long a rendered page is kept. Zero keeps it until the Store evicts it.
+	TTL time.Duration
+
+	// Query, Cookies and Headers are the names of the query parameters, cookies and request headers the page
+	// depends on. Cookies and Headers are added to the response's Vary header.
+	Query   []string
+	Cookies []string
+	Headers []string
+}
+
+// key returns the Store key for the page t renders for r.
+// key returns the key the response to r is cached under, for the page name in locale and variant.
+func (c *OutputCache) key(name, locale, variant string, r *http.Request) string {
+	h := sha256.New()
+	field := func(s string) {
+		io.WriteString(h, s)
+		h.Write([]byte{0})
+	}
+	field(name)
+	field(r.Host)
+	field(r.URL.Path)
+	field(locale)
+	field(variant)
+	q := r.URL.Query()
+	for _, name := range c.Query {
+		field(name)
+		for _, v := range q[name] {
+			field(v)
+		}
+	}
+	for _, name := range c.Cookies {
+		field(name)
+		if cookie, err := r.Cookie(name); err == nil {
+			field(cookie.Value)
+		}
+	}
+	for _, name := range c.Headers {
+		field(name)
+		for _, v := range r.Header.Values(name) {
+			field(v)
+		}
+	}
+	return fmt.Sprintf("templatehandler:%x", h.Sum(nil))
+}
+
+func (c *OutputCache) vary(h http.Header) {
+	if len(c.Cookies) > 0 {
+		addVary(h, "Cookie")
+	}
+	for _, name := range c.Headers {
+		addVary(h, name)
+	}
+}
+
+// cached serves r from the cache and reports whether it could. The key it looked up is returned for storing the page
+// rendered on a miss.
+func (c *OutputCache) cached(d *dynamicHandler, w http.ResponseWriter, r *http.Request) (key string, ok bool) {
+	key = c.key(d.t.Name, d.t.locale(r), d.t.variant(r), r)
+	b, err := c.Store.Get(key)
+	if err != nil {
+		if err != ErrCacheMiss {
+			logError(r, err)
+		}
+		return key, false
+	}
+	d.t.varyVariant(w)
+	setVariantHeaders(w.Header(), d.t.variant(r))
+	c.vary(w.Header())
+	d.applyHeaders(w.Header())
+	writeCompressed(w, r, b)
+	return key, true
+}
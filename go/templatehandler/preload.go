@@ -0,0 +1,101 @@
+package templatehandler
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// PreloadKey is the input key under which a page lists the assets it needs to render, so that they are sent to the
+// browser as Link: rel=preload headers ahead of the page. Each asset is either a URL, whose type is guessed from its
+// extension, or an object with "href" and "as", and optionally "type" and "crossorigin":
+//
+//	{{ define "input" }}{"Preload": ["/static/css/main.css", {"href": "/static/app.js", "as": "script"}]}{{ end }}
+const PreloadKey = "Preload"
+
+// preloadTypes maps asset extensions to the request destination they are preloaded as.
+var preloadTypes = map[string]string{
+	".css":   "style",
+	".js":    "script",
+	".mjs":   "script",
+	".woff":  "font",
+	".woff2": "font",
+	".ttf":   "font",
+	".otf":   "font",
+	".png":   "image",
+	".jpg":   "image",
+	".jpeg":  "image",
+	".gif":   "image",
+	".svg":   "image",
+	".webp":  "image",
+}
+
+// writePreloads adds a Link header to w for each asset the page's input lists under PreloadKey, and sends them
+// straight away as 103 Early Hints if EarlyHints is set.
+func (t *TemplateHandler) writePreloads(w http.ResponseWriter, r *http.Request) {
+	_, input, err := t.current(t.locale(r))
+	if err != nil {
+		// Rendering will fail too, and report the error.
+		return
+	}
+
+	var assets []interface{}
+	switch v := input[PreloadKey].(type) {
+	case []interface{}:
+		assets = v
+	case []string:
+		for _, s := range v {
+			assets = append(assets, s)
+		}
+	}
+	if len(assets) == 0 {
+		return
+	}
+
+	for _, a := range assets {
+		if link := preloadLink(a); link != "" {
+			w.Header().Add("Link", link)
+		}
+	}
+	if t.EarlyHints && r.ProtoAtLeast(1, 1) {
+		w.WriteHeader(http.StatusEarlyHints)
+	}
+}
+
+// preloadLink formats the Link header value for one asset, or returns "" if it isn't a valid asset.
+func preloadLink(asset interface{}) string {
+	var href, as, typ string
+	crossorigin := false
+	switch a := asset.(type) {
+	case string:
+		href = a
+	case map[string]interface{}:
+		href, _ = a["href"].(string)
+		as, _ = a["as"].(string)
+		typ, _ = a["type"].(string)
+		crossorigin, _ = a["crossorigin"].(bool)
+	}
+	if href == "" || strings.ContainsAny(href, "<>\r\n") {
+		return ""
+	}
+	if as == "" {
+		// Ignore any query, such as an asset's fingerprint.
+		p, _, _ := strings.Cut(href, "?")
+		p, _, _ = strings.Cut(p, "#")
+		as = preloadTypes[strings.ToLower(path.Ext(p))]
+	}
+	if as == "" {
+		return ""
+	}
+
+	link := fmt.Sprintf("<%s>; rel=preload; as=%s", href, as)
+	if typ != "" {
+		link += fmt.Sprintf("; type=%q", typ)
+	}
+	// Fonts are always fetched in CORS mode, so their preloads must be too, or the preload goes unused.
+	if crossorigin || as == "font" {
+		link += "; crossorigin"
+	}
+	return link
+}
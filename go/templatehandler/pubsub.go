@@ -0,0 +1,49 @@
+package templatehandler
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+// maxPubSubBytes is the most a push is read of: Pub/Sub's largest message, 10MB, in base64.
+const maxPubSubBytes = 14 << 20
+
+// PubSubMessage is a message delivered by a Pub/Sub push subscription.
+type PubSubMessage struct {
+	ID          string            `json:"messageId"`
+	Attributes  map[string]string `json:"attributes"`
+	Data        []byte            `json:"data"`
+	PublishTime time.Time         `json:"publishTime"`
+}
+
+// PubSub returns a handler for the POSTs of a Pub/Sub push subscription, which passes each message to f. A message
+// that f fails on is answered with a 500, so that Pub/Sub delivers it again later; one that can't be read is logged
+// and acknowledged, since it never will be. PubSub doesn't check who's posting; wrap it with idtoken.Bearer, set to
+// the subscription's service account.
+func PubSub(f func(ctx context.Context, m PubSubMessage) error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		var push struct {
+			Message      PubSubMessage `json:"message"`
+			Subscription string        `json:"subscription"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxPubSubBytes)).Decode(&push); err != nil {
+			log.Printf("templatehandler: dropping pubsub message error=%q", err)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if err := f(r.Context(), push.Message); err != nil {
+			log.Printf("templatehandler: pubsub message failed id=%s subscription=%q error=%q", push.Message.ID, push.Subscription, err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
@@ -0,0 +1,55 @@
+package templatehandler_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mconbere/quitlikeapro/go/templatehandler"
+)
+
+// TestPubSub checks that a push's message is decoded, and that only messages the func fails on are refused, so that
+// Pub/Sub doesn't deliver unreadable ones again forever.
+func TestPubSub(t *testing.T) {
+	var got templatehandler.PubSubMessage
+	h := templatehandler.PubSub(func(ctx context.Context, m templatehandler.PubSubMessage) error {
+		got = m
+		if m.Attributes["fail"] != "" {
+			return errors.New("failed")
+		}
+		return nil
+	})
+
+	for _, tt := range []struct {
+		body string
+		code int
+	}{
+		{`{"message": {"messageId": "1", "attributes": {"eventType": "OBJECT_FINALIZE"}, "data": "aGk="}, "subscription": "s"}`, http.StatusNoContent},
+		{`{"message": {"messageId": "2", "attributes": {"fail": "yes"}}}`, http.StatusInternalServerError},
+		{`not json`, http.StatusNoContent},
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("POST", "/events/pubsub", strings.NewReader(tt.body)))
+		if w.Code != tt.code {
+			t.Errorf("POST %s = %d, want %d", tt.body, w.Code, tt.code)
+		}
+	}
+	if got.ID != "2" {
+		t.Errorf("last message = %q, want 2", got.ID)
+	}
+
+	got = templatehandler.PubSubMessage{}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/", strings.NewReader(`{"message": {"messageId": "3", "attributes": {"a": "b"}, "data": "aGk="}}`)))
+	if got.ID != "3" || got.Attributes["a"] != "b" || string(got.Data) != "hi" {
+		t.Errorf("message = %+v, want ID 3, attribute a=b and data hi", got)
+	}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET = %d, want %d", w.Code, http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,109 @@
+package templatehandler
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxLimited is how many keys a RateLimit tracks before it forgets those whose allowance has come back in full.
+const maxLimited = 10000
+
+// RateLimit returns middleware that lets through at most limit requests with the same key every per, such as 10 an
+// hour for each ClientIP, and answers the rest with a 429 saying when to retry. Allowance comes back gradually, so a
+// client that has used it up may make another request after per/limit rather than waiting out the whole period.
+// Requests with an empty key aren't limited. It panics unless limit and per are positive.
+//
+// It counts in memory, so each instance of a site limits the requests it sees on its own.
+func RateLimit(limit int, per time.Duration, key func(r *http.Request) string) Middleware {
+	if limit <= 0 || per <= 0 {
+		panic("templatehandler: RateLimit needs a positive limit and period")
+	}
+	l := &rateLimiter{limit: float64(limit), interval: per / time.Duration(limit), buckets: make(map[string]*bucket)}
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			k := key(r)
+			if k == "" {
+				h.ServeHTTP(w, r)
+				return
+			}
+			if wait := l.take(k, time.Now()); wait > 0 {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+				return
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// rateLimiter keeps a token bucket for each key, holding up to limit tokens and gaining one every interval.
+type rateLimiter struct {
+	limit    float64
+	interval time.Duration
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	tokens float64
+	at     time.Time
+}
+
+// take spends one of key's tokens as of now, or returns how long until there is one to spend.
+func (l *rateLimiter) take(key string, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= maxLimited {
+			l.sweep(now)
+		}
+		b = &bucket{tokens: l.limit, at: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.limit, b.tokens+float64(now.Sub(b.at))/float64(l.interval))
+	b.at = now
+	if b.tokens < 1 {
+		return time.Duration((1 - b.tokens) * float64(l.interval))
+	}
+	b.tokens--
+	return 0
+}
+
+// sweep forgets the buckets that have filled up again, which are the same as new ones.
+func (l *rateLimiter) sweep(now time.Time) {
+	for k, b := range l.buckets {
+		if b.tokens+float64(now.Sub(b.at))/float64(l.interval) >= l.limit {
+			delete(l.buckets, k)
+		}
+	}
+}
+
+// ClientIP returns the address of the client making r, for keying a RateLimit by: the X-Appengine-User-Ip header App
+// Engine sets, or the address of the connection elsewhere. IPv6 addresses are cut to their /64 network, which is
+// usually a single household or machine's to pick addresses from as it likes.
+//
+// Only App Engine's front end can be trusted to set the header, so servers reached directly should remove it from
+// incoming requests.
+func ClientIP(r *http.Request) string {
+	addr := r.Header.Get("X-Appengine-User-Ip")
+	if addr == "" {
+		var err error
+		if addr, _, err = net.SplitHostPort(r.RemoteAddr); err != nil {
+			addr = r.RemoteAddr
+		}
+	}
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return addr
+	}
+	if ip.To4() == nil {
+		ip = ip.Mask(net.CIDRMask(64, 128))
+	}
+	return ip.String()
+}
@@ -0,0 +1,93 @@
+package templatehandler
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"runtime/debug"
+)
+
+// ErrorReporter is told about the panics Recover recovers, with the stack of the goroutine that panicked, so that they
+// can be forwarded to an error tracking service such as Error Reporting or Sentry. Implementations must be safe for
+// concurrent use.
+type ErrorReporter interface {
+	Report(r *http.Request, err error, stack []byte)
+}
+
+// LogReporter is an ErrorReporter that logs each panic along with its stack.
+var LogReporter ErrorReporter = logReporter{}
+
+type logReporter struct{}
+
+func (logReporter) Report(r *http.Request, err error, stack []byte) {
+	log.Printf("templatehandler: recovered method=%s path=%q error=%q\n%s", r.Method, r.URL.Path, err, stack)
+}
+
+// Recover returns Middleware that recovers panics in the handlers it wraps, reports them to rep and responds with eh,
+// e.g. an ErrorPage, so that a bug shows visitors the site's error page rather than a dropped connection. If eh is
+// nil, DefaultErrorHandler is used, and if rep is nil, LogReporter.
+//
+// If the handler had already started its response, the panic is only reported. http.ErrAbortHandler is passed on,
+// as the server expects.
+func Recover(eh ErrorHandler, rep ErrorReporter) Middleware {
+	if eh == nil {
+		eh = DefaultErrorHandler
+	}
+	if rep == nil {
+		rep = LogReporter
+	}
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rw := &recoverWriter{ResponseWriter: w}
+			defer func() {
+				v := recover()
+				if v == nil {
+					return
+				}
+				if v == http.ErrAbortHandler {
+					panic(v)
+				}
+				err, ok := v.(error)
+				if ok {
+					err = fmt.Errorf("panic: %w", err)
+				} else {
+					err = fmt.Errorf("panic: %v", v)
+				}
+				rep.Report(r, err, debug.Stack())
+				if rw.started {
+					return
+				}
+				// Drop whatever headers the handler set for the response it didn't get to write.
+				for k := range w.Header() {
+					delete(w.Header(), k)
+				}
+				eh(w, r, err)
+			}()
+			h.ServeHTTP(rw, r)
+		})
+	}
+}
+
+// recoverWriter notes whether a response has been started, after which an error page can't be written.
+type recoverWriter struct {
+	http.ResponseWriter
+	started bool
+}
+
+func (w *recoverWriter) WriteHeader(code int) {
+	// Informational responses such as 103 Early Hints don't start the real one.
+	if code >= 200 {
+		w.started = true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *recoverWriter) Write(b []byte) (int, error) {
+	w.started = true
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying ResponseWriter.
+func (w *recoverWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,105 @@
+package templatehandler
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// Redirect returns a handler that redirects every request to target with the given status code, keeping the
+// request's query string if target has none of its own.
+func Redirect(target string, code int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		to := target
+		if r.URL.RawQuery != "" && !strings.Contains(to, "?") {
+			to += "?" + r.URL.RawQuery
+		}
+		http.Redirect(w, r, to, code)
+	})
+}
+
+// RedirectRule is one redirect loaded by LoadRedirects: requests for the mux pattern From are sent to To with Code,
+// or a 301 if Code is zero.
+type RedirectRule struct {
+	From string `json:"from" yaml:"from" toml:"from"`
+	To   string `json:"to" yaml:"to" toml:"to"`
+	Code int    `json:"code" yaml:"code" toml:"code"`
+}
+
+// LoadRedirects reads redirect rules from a JSON, YAML or TOML file, chosen by its extension, with the rules in a
+// top-level "redirects" list:
+//
+//	redirects:
+//	  - from: /index.html
+//	    to: /
+func LoadRedirects(name string) ([]RedirectRule, error) {
+	var file struct {
+		Redirects []RedirectRule `json:"redirects" yaml:"redirects" toml:"redirects"`
+	}
+	if err := ReadData(name, &file); err != nil {
+		return nil, fmt.Errorf("could not load redirects: %v", err)
+	}
+	for i, rule := range file.Redirects {
+		if rule.From == "" || rule.To == "" {
+			return nil, fmt.Errorf("redirect %d in %q needs both from and to", i+1, name)
+		}
+		if rule.Code == 0 {
+			file.Redirects[i].Code = http.StatusMovedPermanently
+		} else if rule.Code < 300 || rule.Code > 399 {
+			return nil, fmt.Errorf("redirect %d in %q has non-redirect code %d", i+1, name, rule.Code)
+		}
+	}
+	return file.Redirects, nil
+}
+
+// HandleRedirects registers a Redirect on mux for each of rules.
+func HandleRedirects(mux *http.ServeMux, rules []RedirectRule) {
+	for _, rule := range rules {
+		mux.Handle(rule.From, Redirect(rule.To, rule.Code))
+	}
+}
+
+// Redirects serves redirect rules in front of a handler, as middleware, and can have its rules replaced while it
+// serves, such as when their file is read again, which HandleRedirects can't. Rules match as mux patterns do: a From
+// ending in a slash matches every path below it, and the longest matching From wins. Requests no rule matches are
+// passed on.
+type Redirects struct {
+	rules atomic.Pointer[[]RedirectRule]
+}
+
+// NewRedirects returns Redirects serving rules.
+func NewRedirects(rules []RedirectRule) *Redirects {
+	rd := &Redirects{}
+	rd.Set(rules)
+	return rd
+}
+
+// Set replaces the rules, for the requests that arrive from then on.
+func (rd *Redirects) Set(rules []RedirectRule) {
+	rd.rules.Store(&rules)
+}
+
+// Middleware returns h with the redirects in front of it.
+func (rd *Redirects) Middleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rule, ok := rd.match(r.URL.Path); ok {
+			Redirect(rule.To, rule.Code).ServeHTTP(w, r)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// match returns the rule for path, if any.
+func (rd *Redirects) match(path string) (RedirectRule, bool) {
+	var best RedirectRule
+	found := false
+	for _, rule := range *rd.rules.Load() {
+		matches := rule.From == path || strings.HasSuffix(rule.From, "/") && strings.HasPrefix(path, rule.From)
+		if matches && (!found || len(rule.From) > len(best.From)) {
+			best, found = rule, true
+		}
+	}
+	return best, found
+}
@@ -0,0 +1,35 @@
+package templatehandler_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mconbere/quitlikeapro/go/templatehandler"
+)
+
+// TestRedirectsSet checks that Redirects match as mux patterns do, and that rules Set while serving replace the old.
+func TestRedirectsSet(t *testing.T) {
+	rd := templatehandler.NewRedirects([]templatehandler.RedirectRule{
+		{From: "/old/", To: "/new", Code: http.StatusMovedPermanently},
+		{From: "/old/page", To: "/page", Code: http.StatusFound},
+	})
+	h := rd.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	check := func(path string, code int, location string) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != code || w.Header().Get("Location") != location {
+			t.Errorf("GET %s = %d to %q, want %d to %q", path, w.Code, w.Header().Get("Location"), code, location)
+		}
+	}
+	check("/old/page", http.StatusFound, "/page")
+	check("/old/other", http.StatusMovedPermanently, "/new")
+	check("/old", http.StatusTeapot, "")
+
+	rd.Set([]templatehandler.RedirectRule{{From: "/gone", To: "/", Code: http.StatusMovedPermanently}})
+	check("/old/page", http.StatusTeapot, "")
+	check("/gone", http.StatusMovedPermanently, "/")
+}
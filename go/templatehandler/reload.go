@@ -0,0 +1,320 @@
+package templatehandler
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	texttemplate "text/template"
+	"time"
+)
+
+func latestModTime(srcs ...source) (time.Time, error) {
+	var latest time.Time
+	for _, s := range srcs {
+		m, err := s.modTime()
+		if err != nil {
+			return time.Time{}, err
+		}
+		if m.After(latest) {
+			latest = m
+		}
+	}
+	return latest, nil
+}
+
+// current returns the template and input to render in locale with, first re-parsing them if live reloading is on and
+// any of the template files have changed. If the page isn't translated into locale, the untranslated page is returned.
+func (t *TemplateHandler) current(locale string) (*template.Template, map[string]interface{}, error) {
+	if !t.base.LiveReload {
+		// The page only changes on Base.Reload.
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		tmpl, input := t.pick(locale)
+		return tmpl, input, nil
+	}
+
+	latest, err := latestModTime(append(t.base.sources(), t.srcs...)...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if latest.After(t.modTime) {
+		base, err := t.base.parse()
+		if err != nil {
+			return nil, nil, err
+		}
+		s := t.base.snapshot()
+		s.tmpl = base
+		p, err := t.rebuild(s)
+		if err != nil {
+			return nil, nil, err
+		}
+		t.Template, t.Input, t.locales, t.text, t.modTime = p.tmpl, p.input, p.locales, p.text, latest
+	}
+	tmpl, input := t.pick(locale)
+	return tmpl, input, nil
+}
+
+// rebuild builds the page again on top of base, a freshly parsed base template.
+func (t *TemplateHandler) rebuild(s snapshot) (*page, error) {
+	p, err := build(t.base, s, t.srcs)
+	if err != nil {
+		return nil, err
+	}
+	if t.bundle != nil {
+		if err := t.bundle.apply(p); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// pick returns the page's template and input for locale, or the untranslated ones if there are none for it.
+func (t *TemplateHandler) pick(locale string) (*template.Template, map[string]interface{}) {
+	if l, ok := t.locales[locale]; ok {
+		return l.tmpl, l.input
+	}
+	return t.Template, t.Input
+}
+
+// currentText returns the text variant's template and the input to render it in locale with, as current does, or a nil
+// template if the page has no text variant.
+func (t *TemplateHandler) currentText(locale string) (*texttemplate.Template, map[string]interface{}, error) {
+	if _, _, err := t.current(locale); err != nil {
+		return nil, nil, err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_, input := t.pick(locale)
+	if l, ok := t.locales[locale]; ok {
+		return l.text, input, nil
+	}
+	return t.text, input, nil
+}
+
+// Refresher is implemented by filesystems that keep a copy of files stored elsewhere, such as a Cloud Storage bucket,
+// so that Reload can fetch the latest files before reading them.
+type Refresher interface {
+	// Refresh replaces the copy with the files as they are now.
+	Refresh(ctx context.Context) error
+}
+
+// refreshTimeout is how long Reload waits for the base's filesystem to refresh.
+const refreshTimeout = time.Minute
+
+// Reload runs the base's data loaders again and re-parses the base, its partials and every page created from it,
+// then drops the cached responses of their static handlers, so that a content fix can go live without restarting the
+// server. Data URLs are fetched again too, and if the base's filesystem is a Refresher it is refreshed first. If any
+// of it fails, the pages are left as they were and the error is returned, though a refreshed filesystem keeps the new
+// files.
+//
+// The pages are rebuilt before they're swapped in, so that UpdateInput isn't held up while the data is fetched. If it
+// swaps in new input meanwhile, the pages are rebuilt again with it, from the data already fetched.
+//
+// Pages cached by an OutputCache are only rendered again once their TTL has passed, unless it's kept in the base's
+// SharedCache.
+func (b *Base) Reload() error {
+	b.reloadMu.Lock()
+	defer b.reloadMu.Unlock()
+
+	if r, ok := b.src.fsys.(Refresher); ok {
+		ctx, cancel := context.WithTimeout(context.Background(), refreshTimeout)
+		defer cancel()
+		if err := r.Refresh(ctx); err != nil {
+			return fmt.Errorf("templatehandler: refreshing templates: %v", err)
+		}
+	}
+	loaded, err := b.runLoaders()
+	if err != nil {
+		return err
+	}
+	tmpl, err := b.parse()
+	if err != nil {
+		return err
+	}
+
+	b.updateMu.Lock()
+	s, updates := b.snapshot(), b.updates
+	b.updateMu.Unlock()
+	next := snapshot{tmpl: tmpl, input: mergeMap(s.input, loaded), fetched: &urlData{}}
+	built, err := b.buildPages(next, nil)
+	if err != nil {
+		return err
+	}
+
+	b.updateMu.Lock()
+	if b.updates != updates {
+		next.input, built = mergeMap(b.input(), loaded), nil
+	}
+	// Pages created while the others were being rebuilt are rebuilt now.
+	if built, err = b.buildPages(next, built); err != nil {
+		b.updateMu.Unlock()
+		return err
+	}
+	statics := b.publish(next, built)
+	b.updateMu.Unlock()
+	b.invalidate(statics)
+	return nil
+}
+
+// snapshot is what pages created from a base are built from: its parsed template, its input, and the data fetched for
+// the data URLs in them.
+type snapshot struct {
+	tmpl    *template.Template
+	input   map[string]interface{}
+	fetched *urlData
+}
+
+// snapshot returns what pages are built from now.
+func (b *Base) snapshot() snapshot {
+	b.inputMu.Lock()
+	defer b.inputMu.Unlock()
+	if b.fetched == nil {
+		b.fetched = &urlData{}
+	}
+	return snapshot{tmpl: b.Template, input: b.Input, fetched: b.fetched}
+}
+
+// buildPages builds every page created from the base again from s, and returns them by handler. Pages in built, which
+// must have been built from s, are kept as they are. If any page fails to build, the error is returned.
+func (b *Base) buildPages(s snapshot, built map[*TemplateHandler]*page) (map[*TemplateHandler]*page, error) {
+	b.pagesMu.Lock()
+	pages := b.pages
+	b.pagesMu.Unlock()
+
+	out := make(map[*TemplateHandler]*page, len(pages))
+	for _, t := range pages {
+		if p, ok := built[t]; ok {
+			out[t] = p
+			continue
+		}
+		p, err := t.rebuild(s)
+		if err != nil {
+			return nil, fmt.Errorf("templatehandler: reloading %q: %v", t.Name, err)
+		}
+		out[t] = p
+	}
+	return out, nil
+}
+
+// publish swaps in s and the pages built from it, and returns the static handlers created from the base, whose cached
+// responses are to be dropped once b.updateMu, which must be held, is released.
+func (b *Base) publish(s snapshot, built map[*TemplateHandler]*page) []*staticHandler {
+	b.inputMu.Lock()
+	b.Template, b.Input, b.fetched = s.tmpl, s.input, s.fetched
+	b.inputMu.Unlock()
+	b.updates++
+	for t, p := range built {
+		t.mu.Lock()
+		t.Template, t.Input, t.locales, t.text = p.tmpl, p.input, p.locales, p.text
+		t.mu.Unlock()
+	}
+	b.pagesMu.Lock()
+	defer b.pagesMu.Unlock()
+	return b.statics
+}
+
+// invalidate drops the cached responses of statics, and bumps the base's SharedCache.
+func (b *Base) invalidate(statics []*staticHandler) {
+	for _, s := range statics {
+		s.Invalidate()
+	}
+	if b.SharedCache != nil {
+		if err := b.SharedCache.Bump(); err != nil {
+			log.Printf("templatehandler: could not bump shared cache error=%q", err)
+		}
+	}
+	if b.CDN != nil {
+		b.CDN.purgeSite()
+	}
+}
+
+// SetInput sets key in the base's input to value, as UpdateInput does.
+func (b *Base) SetInput(key string, value interface{}) error {
+	return b.UpdateInput(func(input map[string]interface{}) { input[key] = value })
+}
+
+// UpdateInput calls f with a copy of the base's input to change, then swaps it in and rebuilds every page created
+// from the base with it, so that data refreshed in the background, such as from a datastore, shows up without
+// re-creating the pages. Static handlers' cached responses are dropped, as with Reload. If any page fails to build,
+// the input and pages are left as they were and the error is returned. Pages cached by an OutputCache are only
+// rendered again once their TTL has passed, unless it's kept in the base's SharedCache.
+//
+// It's safe to call while the pages are serving. Reload runs the data loaders again, so keys set by a loader are
+// replaced by what it loads.
+func (b *Base) UpdateInput(f func(input map[string]interface{})) error {
+	b.updateMu.Lock()
+	s := b.snapshot()
+	s.input = mergeMap(s.input, nil)
+	f(s.input)
+	built, err := b.buildPages(s, nil)
+	if err != nil {
+		b.updateMu.Unlock()
+		return err
+	}
+	statics := b.publish(s, built)
+	b.updateMu.Unlock()
+	b.invalidate(statics)
+	return nil
+}
+
+// InputValue returns the value of key in the base's input, or nil if it isn't set. Handlers should read the input
+// with it rather than from Input, which SetInput, UpdateInput and Reload replace.
+func (b *Base) InputValue(key string) interface{} {
+	b.inputMu.RLock()
+	defer b.inputMu.RUnlock()
+	return b.Input[key]
+}
+
+func (b *Base) input() map[string]interface{} {
+	b.inputMu.RLock()
+	defer b.inputMu.RUnlock()
+	return b.Input
+}
+
+func (b *Base) setInput(input map[string]interface{}) {
+	b.inputMu.Lock()
+	defer b.inputMu.Unlock()
+	b.Input = input
+}
+
+// ReloadHandler returns a handler that calls b.Reload for POST requests carrying token as a bearer token:
+//
+//	curl -X POST -H "Authorization: Bearer $TOKEN" https://example.com/_admin/reload
+//
+// Anyone with the token can make the server re-read its templates and data, so keep it secret. An empty token
+// refuses every request.
+func ReloadHandler(b *Base, token string) http.Handler {
+	return Chain(ReloadEndpoint(b), BearerToken(token))
+}
+
+// Reloader is something that can re-read what it serves without restarting, such as a Base.
+type Reloader interface {
+	Reload() error
+}
+
+// ReloadEndpoint returns a handler that calls b.Reload for POST requests, as ReloadHandler does, but lets anyone call
+// it: wrap it with middleware that checks who's calling, such as idtoken.IAP.
+func ReloadEndpoint(b Reloader) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		start := time.Now()
+		if err := b.Reload(); err != nil {
+			log.Printf("templatehandler: reload failed error=%q", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		log.Printf("templatehandler: reloaded duration=%v", time.Since(start))
+		fmt.Fprintln(w, "reloaded")
+	})
+}
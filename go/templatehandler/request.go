@@ -0,0 +1,92 @@
+package templatehandler
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// RequestKey is the reserved input key under which dynamic pages find the request being served, so that templates can
+// use e.g. {{ .Request.Path }} or {{ .Request.Query.Get "q" }}. Static pages are cached, so they don't get it.
+const RequestKey = "Request"
+
+// Request is the view of the current request given to dynamic pages under RequestKey.
+//
+// Its methods are the request-aware helpers for templates, e.g. {{ if .Request.IsActive "/about" }}class="active"{{ end }}.
+// They are methods rather than template funcs because a page's funcs are shared by all the requests it serves at once.
+// They are safe to call on a nil Request.
+type Request struct {
+	Method  string
+	Host    string
+	Path    string
+	Query   url.Values
+	Header  http.Header
+	Context context.Context
+
+	// Params are the path parameters a Router matched, e.g. {{ .Request.Params.slug }}.
+	Params map[string]string
+
+	scheme string
+}
+
+// withRequest returns a copy of input with r added under RequestKey.
+func withRequest(input map[string]interface{}, r *http.Request) map[string]interface{} {
+	return mergeMap(input, map[string]interface{}{
+		RequestKey: &Request{
+			Method:  r.Method,
+			Host:    r.Host,
+			Path:    r.URL.Path,
+			Query:   r.URL.Query(),
+			Header:  r.Header,
+			Context: r.Context(),
+			Params:  PathParams(r),
+			scheme:  scheme(r),
+		},
+	})
+}
+
+// CurrentPath returns the path of the request.
+func (r *Request) CurrentPath() string {
+	if r == nil {
+		return ""
+	}
+	return r.Path
+}
+
+// Param returns the first value of the named query parameter, or "" if there is none.
+func (r *Request) Param(name string) string {
+	if r == nil {
+		return ""
+	}
+	return r.Query.Get(name)
+}
+
+// IsActive reports whether the request is for p or, if p ends in a slash, for a path below it. It's meant for
+// highlighting the current section in navigation.
+func (r *Request) IsActive(p string) bool {
+	if r == nil {
+		return false
+	}
+	if strings.HasSuffix(p, "/") && p != "/" {
+		return strings.HasPrefix(r.Path, p)
+	}
+	return r.Path == p
+}
+
+// URL returns the absolute URL of the request without its query, e.g. for a canonical link. The scheme is https if
+// the request came over TLS, or a proxy in front of the server says it did with X-Forwarded-Proto.
+func (r *Request) URL() string {
+	if r == nil {
+		return ""
+	}
+	u := url.URL{Scheme: r.scheme, Host: r.Host, Path: r.Path}
+	return u.String()
+}
+
+func scheme(r *http.Request) string {
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		return "https"
+	}
+	return "http"
+}
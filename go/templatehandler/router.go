@@ -0,0 +1,165 @@
+package templatehandler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Router dispatches requests by path patterns with parameters, such as /quit/{slug}, which http.ServeMux can't
+// express. A segment written {name} matches any one path segment, and a last segment written {name...} matches the
+// rest of the path. When more than one pattern matches, the one with literal segments earliest wins, so /quit/new is
+// preferred over /quit/{slug} for /quit/new.
+//
+// The matched parameters are available to handlers from PathParams, and to dynamic pages' templates as
+// {{ .Request.Params.slug }}. Mount a Router on a ServeMux at the prefix its patterns share, e.g.
+// mux.Handle("/quit/", router).
+type Router struct {
+	// NotFound serves requests no pattern matches. If nil, they get a plain 404.
+	NotFound http.Handler
+
+	routes []route
+}
+
+// route is a parsed Router pattern.
+type route struct {
+	pattern  string
+	segments []segment
+	h        http.Handler
+}
+
+// segment is one segment of a pattern: a literal, or a parameter matching one segment or the rest of the path.
+type segment struct {
+	literal string
+	param   string
+	rest    bool
+}
+
+// NewRouter returns an empty Router.
+func NewRouter() *Router {
+	return &Router{}
+}
+
+// Handle registers h for pattern. It panics if the pattern is malformed, as http.ServeMux.Handle does.
+func (rt *Router) Handle(pattern string, h http.Handler) {
+	segments, err := parsePattern(pattern)
+	if err != nil {
+		panic(err)
+	}
+	rt.routes = append(rt.routes, route{pattern: pattern, segments: segments, h: h})
+}
+
+// HandleFunc registers f for pattern.
+func (rt *Router) HandleFunc(pattern string, f func(http.ResponseWriter, *http.Request)) {
+	rt.Handle(pattern, http.HandlerFunc(f))
+}
+
+func parsePattern(pattern string) ([]segment, error) {
+	if !strings.HasPrefix(pattern, "/") {
+		return nil, fmt.Errorf("templatehandler: router pattern %q must start with a slash", pattern)
+	}
+	parts := strings.Split(pattern[1:], "/")
+	segments := make([]segment, len(parts))
+	seen := make(map[string]bool)
+	for i, p := range parts {
+		if !strings.HasPrefix(p, "{") || !strings.HasSuffix(p, "}") {
+			if strings.ContainsAny(p, "{}") {
+				return nil, fmt.Errorf("templatehandler: router pattern %q has a malformed parameter %q", pattern, p)
+			}
+			segments[i] = segment{literal: p}
+			continue
+		}
+		name := p[1 : len(p)-1]
+		var rest bool
+		if name, rest = strings.CutSuffix(name, "..."); rest && i != len(parts)-1 {
+			return nil, fmt.Errorf("templatehandler: router pattern %q has %q before its last segment", pattern, p)
+		}
+		if name == "" || seen[name] {
+			return nil, fmt.Errorf("templatehandler: router pattern %q has an empty or repeated parameter %q", pattern, p)
+		}
+		seen[name] = true
+		segments[i] = segment{param: name, rest: rest}
+	}
+	return segments, nil
+}
+
+// match returns the parameters of path if it matches the route.
+func (rt route) match(path string) (map[string]string, bool) {
+	if !strings.HasPrefix(path, "/") {
+		return nil, false
+	}
+	parts := strings.Split(path[1:], "/")
+	params := make(map[string]string)
+	for i, s := range rt.segments {
+		if s.rest {
+			params[s.param] = strings.Join(parts[i:], "/")
+			return params, true
+		}
+		if i >= len(parts) {
+			return nil, false
+		}
+		switch {
+		case s.param != "":
+			if parts[i] == "" {
+				return nil, false
+			}
+			params[s.param] = parts[i]
+		case s.literal != parts[i]:
+			return nil, false
+		}
+	}
+	return params, len(parts) == len(rt.segments)
+}
+
+// moreSpecific reports whether rt should be preferred over other when both match: the first segment in which they
+// differ is a literal in rt, or a single-segment parameter where other matches the rest of the path.
+func (rt route) moreSpecific(other route) bool {
+	for i := 0; i < len(rt.segments) && i < len(other.segments); i++ {
+		a, b := rt.segments[i], other.segments[i]
+		if rank(a) != rank(b) {
+			return rank(a) < rank(b)
+		}
+	}
+	return len(rt.segments) > len(other.segments)
+}
+
+func rank(s segment) int {
+	switch {
+	case s.rest:
+		return 2
+	case s.param != "":
+		return 1
+	}
+	return 0
+}
+
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var best *route
+	var params map[string]string
+	for i := range rt.routes {
+		p, ok := rt.routes[i].match(r.URL.Path)
+		if ok && (best == nil || rt.routes[i].moreSpecific(*best)) {
+			best, params = &rt.routes[i], p
+		}
+	}
+	if best == nil {
+		if rt.NotFound != nil {
+			rt.NotFound.ServeHTTP(w, r)
+		} else {
+			http.NotFound(w, r)
+		}
+		return
+	}
+	best.h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), paramsKey{}, params)))
+}
+
+// paramsKey is the context key of the parameters a Router matched.
+type paramsKey struct{}
+
+// PathParams returns the parameters the Router serving r matched in its path, e.g. {"slug": "vim"} for /quit/vim on
+// /quit/{slug}, or nil if r wasn't routed by one.
+func PathParams(r *http.Request) map[string]string {
+	params, _ := r.Context().Value(paramsKey{}).(map[string]string)
+	return params
+}
@@ -0,0 +1,62 @@
+package templatehandler
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Canonical returns a handler that serves each page of mux at a single canonical URL, and permanently redirects GET
+// and HEAD requests for any other spelling of it there. The canonical path is lower case, has no trailing
+// "index.html", and ends in a slash only if mux has a subtree pattern for exactly that path, as MarkdownDir registers
+// for index pages, and none for the path without it. So /About/, /ABOUT and /about/index.html all redirect to /about, and /docs/index.html to /docs/.
+//
+// Only use it in front of a mux of pages: static files with upper case names would be redirected away from.
+func Canonical(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" && r.Method != "HEAD" {
+			mux.ServeHTTP(w, r)
+			return
+		}
+		if p := canonicalPath(mux, r); p != r.URL.Path {
+			u := *r.URL
+			u.Path = p
+			u.RawPath = ""
+			http.Redirect(w, r, u.RequestURI(), http.StatusMovedPermanently)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// canonicalPath returns the canonical form of r's path on mux.
+func canonicalPath(mux *http.ServeMux, r *http.Request) string {
+	p := cleanPath(r.URL.Path)
+	if p == "/" {
+		return p
+	}
+	// Use the path with a slash only if mux serves it as a subtree of its own, and not the path without one.
+	if routedTo(mux, r, p) {
+		return p
+	}
+	if routedTo(mux, r, p+"/") {
+		return p + "/"
+	}
+	return p
+}
+
+// routedTo reports whether mux has a pattern for exactly path, on r's host.
+func routedTo(mux *http.ServeMux, r *http.Request, path string) bool {
+	u := *r.URL
+	u.Path = path
+	_, pattern := mux.Handler(&http.Request{Method: r.Method, Host: r.Host, URL: &u})
+	return pattern == path
+}
+
+// cleanPath lower-cases in and removes any trailing "index.html" and slash.
+func cleanPath(in string) string {
+	out := strings.TrimSuffix(strings.TrimSuffix(strings.ToLower(in), "index.html"), "/")
+	if out == "" {
+		out = "/"
+	}
+	return out
+}
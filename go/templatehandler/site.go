@@ -0,0 +1,25 @@
+package templatehandler
+
+// SiteKey is the reserved input key under which pages find the site configuration loaded by WithSite, so that
+// templates can use e.g. {{ .Site.Title }} instead of each page repeating it.
+const SiteKey = "Site"
+
+// WithSite loads the site-wide configuration file name, written in JSON, YAML or TOML and chosen by its extension, and
+// adds it to the base's input under SiteKey. It holds whatever presentation metadata the templates want, such as:
+//
+//	Title: Quit Like a Pro
+//	BaseURL: https://example.com
+//	Author: Morgan Conbere
+//	Social:
+//	  GitHub: mconbere
+//	AnalyticsID: ""
+//
+// The file is read from the base's filesystem, once, when NewBase is called.
+func WithSite(name string) Option {
+	return configure(func(b *Base) {
+		b.loaders = append(b.loaders, namedLoader{SiteKey, func() (interface{}, error) {
+			site := make(map[string]interface{})
+			return site, readData(b.src.fsys, name, &site)
+		}})
+	})
+}
@@ -0,0 +1,172 @@
+package templatehandler
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+)
+
+// SitemapEntry is a page listed in a Sitemap.
+type SitemapEntry struct {
+	// Path is the page's path, e.g. "/about". It is made absolute with the host the sitemap is requested from.
+	Path string
+
+	// LastMod, ChangeFreq and Priority are optional hints for crawlers: when the page last changed, how often it
+	// changes ("daily", "weekly", "monthly" and so on), and how important it is relative to the site's other pages,
+	// from 0.1 to 1. They are left out of the sitemap when zero.
+	LastMod    time.Time
+	ChangeFreq string
+	Priority   float64
+}
+
+// Sitemap lists a site's pages for search engines, served as a sitemap.xml. Pages are added as they are registered
+// with Handle, by Base.MarkdownDir when it is the Base's Sitemap, or by funcs added with AddFunc, for pages made from
+// data that can change while the server runs.
+type Sitemap struct {
+	mu      sync.Mutex
+	entries map[string]SitemapEntry
+	funcs   []func() ([]SitemapEntry, error)
+
+	// generated are the entries the funcs returned at the last Refresh, and refreshed whether there's been one.
+	generated []SitemapEntry
+	refreshed bool
+}
+
+// NewSitemap returns an empty Sitemap.
+func NewSitemap() *Sitemap {
+	return &Sitemap{entries: make(map[string]SitemapEntry)}
+}
+
+// Add lists entries in the sitemap, replacing any already listed with the same path.
+func (s *Sitemap) Add(entries ...SitemapEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, e := range entries {
+		s.entries[e.Path] = e
+	}
+}
+
+// AddFunc lists the entries f returns in the sitemap. It is called each time the sitemap is served, or once Refresh
+// has been called, at each Refresh.
+func (s *Sitemap) AddFunc(f func() ([]SitemapEntry, error)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.funcs = append(s.funcs, f)
+}
+
+// Handle registers h on mux for pattern, and lists it in the sitemap with the hints in e. If e.Path is empty, the
+// pattern is used.
+func (s *Sitemap) Handle(mux *http.ServeMux, pattern string, h http.Handler, e SitemapEntry) {
+	mux.Handle(pattern, h)
+	if e.Path == "" {
+		e.Path = pattern
+	}
+	s.Add(e)
+}
+
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 urlset"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapURL struct {
+	Loc        string `xml:"loc"`
+	LastMod    string `xml:"lastmod,omitempty"`
+	ChangeFreq string `xml:"changefreq,omitempty"`
+	Priority   string `xml:"priority,omitempty"`
+}
+
+// Refresh calls the funcs added with AddFunc and keeps what they return, so that the sitemap is served without calling
+// them until the next Refresh, such as from a scheduled task. If any fails, the entries from the last are kept.
+func (s *Sitemap) Refresh() error {
+	s.mu.Lock()
+	funcs := s.funcs
+	s.mu.Unlock()
+	generated, err := generate(funcs)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.generated, s.refreshed = generated, true
+	return nil
+}
+
+// generate returns the entries funcs return.
+func generate(funcs []func() ([]SitemapEntry, error)) ([]SitemapEntry, error) {
+	var entries []SitemapEntry
+	for _, f := range funcs {
+		es, err := f()
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, es...)
+	}
+	return entries, nil
+}
+
+// Entries returns the entries listed in the sitemap, sorted by path.
+func (s *Sitemap) Entries() ([]SitemapEntry, error) {
+	s.mu.Lock()
+	entries := make(map[string]SitemapEntry, len(s.entries))
+	for p, e := range s.entries {
+		entries[p] = e
+	}
+	funcs, generated, refreshed := s.funcs, s.generated, s.refreshed
+	s.mu.Unlock()
+	if !refreshed {
+		var err error
+		if generated, err = generate(funcs); err != nil {
+			return nil, err
+		}
+	}
+	for _, e := range generated {
+		entries[e.Path] = e
+	}
+
+	sorted := make([]SitemapEntry, 0, len(entries))
+	for _, e := range entries {
+		sorted = append(sorted, e)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Path < sorted[j].Path })
+	return sorted, nil
+}
+
+func (s *Sitemap) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	entries, err := s.Entries()
+	if err != nil {
+		DefaultErrorHandler(w, r, err)
+		return
+	}
+
+	site := &url.URL{Scheme: scheme(r), Host: r.Host}
+	var set sitemapURLSet
+	for _, e := range entries {
+		u, err := url.Parse(e.Path)
+		if err != nil {
+			DefaultErrorHandler(w, r, err)
+			return
+		}
+		su := sitemapURL{Loc: site.ResolveReference(u).String(), ChangeFreq: e.ChangeFreq}
+		if !e.LastMod.IsZero() {
+			su.LastMod = e.LastMod.UTC().Format(time.RFC3339)
+		}
+		if e.Priority > 0 {
+			su.Priority = fmt.Sprintf("%.1f", e.Priority)
+		}
+		set.URLs = append(set.URLs, su)
+	}
+
+	b, err := xml.MarshalIndent(set, "", "  ")
+	if err != nil {
+		DefaultErrorHandler(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.Header().Set("Cache-Control", CachePolicy{MaxAge: time.Hour}.String())
+	writeCompressed(w, r, append([]byte(xml.Header), b...))
+}
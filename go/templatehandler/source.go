@@ -0,0 +1,113 @@
+package templatehandler
+
+import (
+	"fmt"
+	"html/template"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// source is a template file, read either from the local filesystem or, if fsys is set, from fsys. Its path may be a
+// glob pattern, as understood by template.ParseGlob, to parse every file it matches.
+//
+// A markdown source is a Markdown file rather than a template: it defines a "markdown" block that renders the file,
+// and a "content" block that includes it unless a layout before it already defines one. Its front matter is the
+// page's input.
+type source struct {
+	fsys     fs.FS
+	path     string
+	markdown bool
+}
+
+func (s source) parse(t *template.Template) (*template.Template, error) {
+	if s.markdown {
+		text := fmt.Sprintf("{{ define \"markdown\" }}{{ markdownFile %q }}{{ end }}", s.path)
+		if t.Lookup("content") == nil {
+			text += "{{ define \"content\" }}{{ template \"markdown\" . }}{{ end }}"
+		}
+		return t, parseInternal(t, text)
+	}
+	if s.fsys == nil {
+		if isGlob(s.path) {
+			return t.ParseGlob(s.path)
+		}
+		return t.ParseFiles(s.path)
+	}
+	return t.ParseFS(s.fsys, s.path)
+}
+
+func isGlob(path string) bool {
+	return strings.ContainsAny(path, "*?[")
+}
+
+func (s source) readFile() ([]byte, error) {
+	if s.fsys == nil {
+		return os.ReadFile(s.path)
+	}
+	return fs.ReadFile(s.fsys, s.path)
+}
+
+// frontMatter returns the input given by a markdown source's front matter, with its table of contents under TOCKey
+// if opts set TOC, or nil for other sources.
+func (s source) frontMatter(opts MarkdownOptions) (map[string]interface{}, error) {
+	if !s.markdown {
+		return nil, nil
+	}
+	b, err := s.readFile()
+	if err != nil {
+		return nil, err
+	}
+	input, err := parseFrontMatter(s.path, b)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := input[TOCKey]; opts.TOC && !ok {
+		_, _, _, body := splitFrontMatter(b)
+		input[TOCKey] = markdownTOC(body, opts)
+	}
+	return input, nil
+}
+
+func (s source) modTime() (time.Time, error) {
+	if isGlob(s.path) && !s.markdown {
+		return s.globModTime()
+	}
+
+	var fi fs.FileInfo
+	var err error
+	if s.fsys == nil {
+		fi, err = os.Stat(s.path)
+	} else {
+		fi, err = fs.Stat(s.fsys, s.path)
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return fi.ModTime(), nil
+}
+
+// globModTime returns the latest modification time of the files matching a glob source.
+func (s source) globModTime() (time.Time, error) {
+	var matches []string
+	var err error
+	if s.fsys == nil {
+		matches, err = filepath.Glob(s.path)
+	} else {
+		matches, err = fs.Glob(s.fsys, s.path)
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	if len(matches) == 0 {
+		return time.Time{}, fmt.Errorf("pattern matches no files: %#q", s.path)
+	}
+
+	var srcs []source
+	for _, m := range matches {
+		srcs = append(srcs, source{fsys: s.fsys, path: m})
+	}
+	return latestModTime(srcs...)
+}
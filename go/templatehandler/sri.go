@@ -0,0 +1,78 @@
+package templatehandler
+
+import (
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// WithSRI fetches each of urls, scripts and stylesheets served by someone else such as a CDN, when NewBase is called,
+// and computes its Subresource Integrity hash, so that pages can include it with the "sri" func:
+//
+//	{{ sri "https://ajax.googleapis.com/ajax/libs/jquery/3.2.1/jquery.min.js" }}
+//
+// renders a script tag, or a stylesheet link for CSS, with integrity and crossorigin attributes, and the browser
+// refuses the asset if it ever changes. NewBase fails if one can't be fetched.
+func WithSRI(urls ...string) Option {
+	return configure(func(b *Base) { b.sriURLs = append(b.sriURLs, urls...) })
+}
+
+// sriAsset is an asset fetched for WithSRI.
+type sriAsset struct {
+	integrity  string
+	stylesheet bool
+}
+
+// loadIntegrity fetches the base's WithSRI URLs and hashes them.
+func (b *Base) loadIntegrity() error {
+	for _, url := range b.sriURLs {
+		resp, err := dataClient.Get(url)
+		if err != nil {
+			return fmt.Errorf("templatehandler: fetching %q for sri: %v", url, err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("templatehandler: fetching %q for sri: %v", url, err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("templatehandler: fetching %q for sri: %s", url, resp.Status)
+		}
+
+		mt, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+		sum := sha512.Sum384(body)
+		if b.assets == nil {
+			b.assets = make(map[string]sriAsset)
+		}
+		b.assets[url] = sriAsset{
+			integrity:  "sha384-" + base64.StdEncoding.EncodeToString(sum[:]),
+			stylesheet: mt == "text/css" || path.Ext(resp.Request.URL.Path) == ".css",
+		}
+	}
+	return nil
+}
+
+// sri is the "sri" func.
+func (b *Base) sri(url string) (template.HTML, error) {
+	a, ok := b.assets[url]
+	if !ok {
+		return "", fmt.Errorf("sri: %q isn't one of the base's WithSRI URLs", url)
+	}
+	var tag strings.Builder
+	if a.stylesheet {
+		fmt.Fprintf(&tag, `<link rel="stylesheet" href="%s"`, template.HTMLEscapeString(url))
+	} else {
+		fmt.Fprintf(&tag, `<script src="%s"`, template.HTMLEscapeString(url))
+	}
+	fmt.Fprintf(&tag, ` integrity="%s" crossorigin="anonymous">`, a.integrity)
+	if !a.stylesheet {
+		tag.WriteString("</script>")
+	}
+	return template.HTML(tag.String()), nil
+}
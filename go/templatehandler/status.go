@@ -0,0 +1,70 @@
+package templatehandler
+
+import (
+	"io/fs"
+	"net/http"
+)
+
+// Exact returns a handler that serves requests for exactly path with h, and any others with fallback, or a plain 404
+// if fallback is nil. A mux pattern ending in a slash matches every path below it, so this keeps a page registered at
+// such a pattern, like "/", to its own path.
+func Exact(path string, h, fallback http.Handler) http.Handler {
+	if fallback == nil {
+		fallback = http.NotFoundHandler()
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != path {
+			fallback.ServeHTTP(w, r)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// statusHandler renders the page with a fixed status code, such as a 404 page. Like dynamicHandler it renders on every
+// request, and the input includes the request under RequestKey, as well as "Status" and "StatusText".
+type statusHandler struct {
+	t    *TemplateHandler
+	code int
+}
+
+func (s *statusHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.t.varyLocale(w)
+	b, err := s.t.render(w, r, withRequest(map[string]interface{}{
+		"Status":     s.code,
+		"StatusText": http.StatusText(s.code),
+	}, r))
+	if err != nil {
+		s.t.handleError(w, r, err)
+		return
+	}
+	writeCompressedStatus(w, r, s.code, b)
+}
+
+// Status returns a handler that renders the page with the given status code.
+func (t *TemplateHandler) Status(code int) http.Handler {
+	return &statusHandler{
+		t:    t,
+		code: code,
+	}
+}
+
+// NotFound returns a handler that renders the page made of tmpls, as with New, with a 404 status. Register it as a
+// mux's fallback so that unknown paths get the page rather than a plain error; a page at "/" itself can share the
+// route with Exact: mux.Handle("/", Exact("/", index, notFound)).
+func NotFound(base *Base, tmpls ...string) (http.Handler, error) {
+	t, err := New(base, tmpls...)
+	if err != nil {
+		return nil, err
+	}
+	return t.Status(http.StatusNotFound), nil
+}
+
+// NotFoundFS is like NotFound, but reads the page templates from fsys.
+func NotFoundFS(base *Base, fsys fs.FS, tmpls ...string) (http.Handler, error) {
+	t, err := NewFS(base, fsys, tmpls...)
+	if err != nil {
+		return nil, err
+	}
+	return t.Status(http.StatusNotFound), nil
+}
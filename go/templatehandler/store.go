@@ -0,0 +1,217 @@
+package templatehandler
+
+import (
+	"container/list"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrCacheMiss is returned by Store.Get when it has nothing for the key.
+var ErrCacheMiss = errors.New("templatehandler: cache miss")
+
+// Store holds rendered output by key, so that it can be reused rather than rendered again. Implementations must be
+// safe for concurrent use. NewMemoryStore keeps it in memory; package memcache shares it between instances.
+type Store interface {
+	// Get returns the value for key, or ErrCacheMiss if there is none.
+	Get(key string) ([]byte, error)
+
+	// Set stores value under key for ttl, or until evicted if ttl is zero.
+	Set(key string, value []byte, ttl time.Duration) error
+
+	// Delete removes key. Deleting a key that isn't there isn't an error.
+	Delete(key string) error
+}
+
+// memoryStore is a Store in memory that evicts the least recently used entry once it's full.
+type memoryStore struct {
+	max int
+
+	mu      sync.Mutex
+	lru     *list.List
+	entries map[string]*list.Element
+}
+
+type memoryEntry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+// NewMemoryStore returns a Store that keeps up to max entries in memory, evicting the least recently used beyond that.
+func NewMemoryStore(max int) Store {
+	return &memoryStore{
+		max:     max,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+func (m *memoryStore) Get(key string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	el, ok := m.entries[key]
+	if !ok {
+		return nil, ErrCacheMiss
+	}
+	e := el.Value.(*memoryEntry)
+	if !e.expires.IsZero() && time.Now().After(e.expires) {
+		m.remove(el)
+		return nil, ErrCacheMiss
+	}
+	m.lru.MoveToFront(el)
+	return e.value, nil
+}
+
+func (m *memoryStore) Set(key string, value []byte, ttl time.Duration) error {
+	e := &memoryEntry{key: key, value: value}
+	if ttl > 0 {
+		e.expires = time.Now().Add(ttl)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if el, ok := m.entries[key]; ok {
+		el.Value = e
+		m.lru.MoveToFront(el)
+		return nil
+	}
+	m.entries[key] = m.lru.PushFront(e)
+	for m.max > 0 && m.lru.Len() > m.max {
+		m.remove(m.lru.Back())
+	}
+	return nil
+}
+
+func (m *memoryStore) Delete(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if el, ok := m.entries[key]; ok {
+		m.remove(el)
+	}
+	return nil
+}
+
+func (m *memoryStore) remove(el *list.Element) {
+	m.lru.Remove(el)
+	delete(m.entries, el.Value.(*memoryEntry).key)
+}
+
+// versionTTL is how long a VersionedStore uses the version it has read before reading it again, and so how long
+// another instance's Bump takes to reach it.
+const versionTTL = 10 * time.Second
+
+// VersionedStore is a Store that keeps its values in another under keys prefixed with a version, itself kept in that
+// Store, so that Bump drops everything stored through it, on every instance sharing the Store, with a single write.
+// Give it to a Base with WithSharedCache to bump it whenever the base's data changes.
+type VersionedStore struct {
+	// Store holds the values and the version, such as a memcache.Client shared by every instance of the site.
+	Store Store
+
+	// Name is the key the version is kept under. Give deployments rendering different pages different names.
+	Name string
+
+	mu      sync.Mutex
+	version string
+	read    time.Time
+	// reading is closed when the read of the version in flight finishes, and is nil when there's none.
+	reading chan struct{}
+	err     error
+}
+
+var _ Store = (*VersionedStore)(nil)
+
+// NewVersionedStore returns a Store keeping values in s under a version kept in s under name.
+func NewVersionedStore(s Store, name string) *VersionedStore {
+	return &VersionedStore{Store: s, Name: name}
+}
+
+func (v *VersionedStore) Get(key string) ([]byte, error) {
+	version, err := v.current()
+	if err != nil {
+		return nil, err
+	}
+	return v.Store.Get(version + ":" + key)
+}
+
+func (v *VersionedStore) Set(key string, value []byte, ttl time.Duration) error {
+	version, err := v.current()
+	if err != nil {
+		return err
+	}
+	return v.Store.Set(version+":"+key, value, ttl)
+}
+
+func (v *VersionedStore) Delete(key string) error {
+	version, err := v.current()
+	if err != nil {
+		return err
+	}
+	return v.Store.Delete(version + ":" + key)
+}
+
+// Bump starts a new version, so that nothing stored under the old one is found again. The old values are left for
+// the Store to evict.
+func (v *VersionedStore) Bump() error {
+	version := newVersion()
+	if err := v.Store.Set(v.Name, []byte(version), 0); err != nil {
+		return err
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.version, v.read = version, time.Now()
+	return nil
+}
+
+// current returns the version to use. Once it's older than versionTTL it's read again in the background, and the old
+// one returned meanwhile; only calls before there is one wait for the read.
+func (v *VersionedStore) current() (string, error) {
+	v.mu.Lock()
+	if v.version != "" && time.Since(v.read) < versionTTL {
+		defer v.mu.Unlock()
+		return v.version, nil
+	}
+	if v.reading == nil {
+		v.reading = make(chan struct{})
+		go v.readVersion(v.reading)
+	}
+	version, reading := v.version, v.reading
+	v.mu.Unlock()
+	if version != "" {
+		return version, nil
+	}
+
+	<-reading
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.version == "" {
+		return "", v.err
+	}
+	return v.version, nil
+}
+
+// readVersion reads the version from the Store, starting one if there's none, such as after it has been evicted, and
+// closes done once it's kept. If it can't be read, the last version is kept for another versionTTL.
+func (v *VersionedStore) readVersion(done chan struct{}) {
+	b, err := v.Store.Get(v.Name)
+	version := string(b)
+	if err == ErrCacheMiss {
+		// Instances starting one at the same time each use their own until they read the version again.
+		version = newVersion()
+		err = v.Store.Set(v.Name, []byte(version), 0)
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if err == nil {
+		v.version = version
+	}
+	v.read, v.err, v.reading = time.Now(), err, nil
+	close(done)
+}
+
+// newVersion returns a version no instance has used before.
+func newVersion() string {
+	return strconv.FormatInt(time.Now().UnixNano(), 36)
+}
@@ -0,0 +1,106 @@
+package templatehandler
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"time"
+)
+
+// defaultStreamBuffer is how much of a streamed page is held back when TemplateHandler.StreamBuffer is unset.
+const defaultStreamBuffer = 4096
+
+// stream executes the page directly to w instead of rendering it into memory first. The first StreamBuffer bytes are
+// held back, so an error before that point can still be turned into a proper error response; an error after it can
+// only be logged, as the client has already received part of the page. before is called with the response headers
+// once the page is known to be starting successfully.
+func (t *TemplateHandler) stream(w http.ResponseWriter, r *http.Request, input map[string]interface{}, before func(http.Header)) {
+	tmpl, pageInput, err := t.current(t.locale(r))
+	if err != nil {
+		t.handleError(w, r, err)
+		return
+	}
+
+	limit := t.StreamBuffer
+	if limit <= 0 {
+		limit = defaultStreamBuffer
+	}
+
+	var zw io.WriteCloser
+	nw := &nonceWriter{nonce: []byte(CSPNonce(r))}
+	p := &prefixWriter{
+		limit: limit,
+		start: func(prefix []byte) io.Writer {
+			before(w.Header())
+			enc := negotiateEncoding(r)
+			if enc != "" {
+				var err error
+				if zw, err = newCompressor(enc, w); err != nil {
+					enc = ""
+				}
+			}
+			setEncodingHeaders(w.Header(), enc, prefix)
+			nw.w = w
+			if zw != nil {
+				nw.w = zw
+			}
+			return nw
+		},
+	}
+
+	start := time.Now()
+	err = tmpl.ExecuteTemplate(p, "base", t.withBreadcrumbs(t.base.Merge.merge(pageInput, input)))
+	t.rendered(start, err)
+	if err != nil && !p.started() {
+		t.handleError(w, r, err)
+		return
+	}
+	if err == nil {
+		err = p.flush()
+	}
+	if err == nil {
+		err = nw.flush()
+	}
+	if zw != nil {
+		zw.Close()
+	}
+	if err != nil {
+		logError(r, err)
+	}
+}
+
+// prefixWriter holds back writes until limit bytes have been written or flush is called, and then starts the real
+// output by calling start with the bytes held back so far.
+type prefixWriter struct {
+	buf   bytes.Buffer
+	limit int
+	start func(prefix []byte) io.Writer
+	out   io.Writer
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	if p.out != nil {
+		return p.out.Write(b)
+	}
+	p.buf.Write(b)
+	if p.buf.Len() >= p.limit {
+		if err := p.flush(); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+func (p *prefixWriter) started() bool {
+	return p.out != nil
+}
+
+func (p *prefixWriter) flush() error {
+	if p.out != nil {
+		return nil
+	}
+	p.out = p.start(p.buf.Bytes())
+	_, err := p.out.Write(p.buf.Bytes())
+	p.buf.Reset()
+	return err
+}
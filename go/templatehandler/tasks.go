@@ -0,0 +1,120 @@
+package templatehandler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// taskTimeout is how long a Tasks job may run if Tasks.Timeout isn't set: as long as App Engine gives a cron request.
+const taskTimeout = 10 * time.Minute
+
+// Job is work a Tasks runs when it's asked to, such as rebuilding an index.
+type Job func(ctx context.Context) error
+
+// Tasks serves jobs for a scheduler, such as App Engine cron or Cloud Scheduler, to run, each at Prefix followed by
+// its name, e.g. /tasks/sitemap, with a GET or POST. The response is a 200 once the job has finished, or a 500 if it
+// failed, so that the scheduler tries again. While a job is running, requests for it wait for that run rather than
+// starting another.
+//
+// Jobs run with a context of their own, cancelled after Timeout, rather than the request's, so that a scheduler giving
+// up on the request doesn't leave a job half done. Tasks doesn't check who's asking; wrap it with AppEngineCron or
+// idtoken.Bearer.
+type Tasks struct {
+	Prefix  string
+	Timeout time.Duration
+
+	mu      sync.Mutex
+	jobs    map[string]Job
+	running map[string]*taskRun
+}
+
+// taskRun is a run of a job. done is closed once it's finished, with err.
+type taskRun struct {
+	done chan struct{}
+	err  error
+}
+
+// NewTasks returns a Tasks serving jobs under prefix, e.g. "/tasks/".
+func NewTasks(prefix string) *Tasks {
+	return &Tasks{Prefix: prefix, jobs: make(map[string]Job), running: make(map[string]*taskRun)}
+}
+
+// Add adds job to the tasks under name, replacing any already added with it.
+func (t *Tasks) Add(name string, job Job) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.jobs[name] = job
+}
+
+// Run runs the named job, or waits for the run in progress, and returns its error. It stops waiting, but doesn't stop
+// the job, when ctx is done.
+func (t *Tasks) Run(ctx context.Context, name string) error {
+	t.mu.Lock()
+	run, ok := t.running[name]
+	if !ok {
+		job, ok := t.jobs[name]
+		if !ok {
+			t.mu.Unlock()
+			return fmt.Errorf("templatehandler: no task %q", name)
+		}
+		run = &taskRun{done: make(chan struct{})}
+		t.running[name] = run
+		go t.run(name, job, run)
+	}
+	t.mu.Unlock()
+
+	select {
+	case <-run.done:
+		return run.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (t *Tasks) run(name string, job Job, run *taskRun) {
+	timeout := t.Timeout
+	if timeout == 0 {
+		timeout = taskTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	start := time.Now()
+	run.err = job(ctx)
+	if run.err != nil {
+		log.Printf("templatehandler: task failed name=%q duration=%v error=%q", name, time.Since(start), run.err)
+	} else {
+		log.Printf("templatehandler: task done name=%q duration=%v", name, time.Since(start))
+	}
+
+	t.mu.Lock()
+	delete(t.running, name)
+	t.mu.Unlock()
+	close(run.done)
+}
+
+func (t *Tasks) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	name, ok := strings.CutPrefix(r.URL.Path, t.Prefix)
+	t.mu.Lock()
+	_, known := t.jobs[name]
+	t.mu.Unlock()
+	if !ok || !known {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Cache-Control", CachePolicy{NoStore: true}.String())
+	if err := t.Run(r.Context(), name); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprintln(w, "done")
+}
@@ -7,6 +7,8 @@
 // - "css": This is any additional CSS you want to add. It's optional, and added to the bottom of the existing CSS.
 // - "js": This is any additional Javascript you want to add. It's optional, and added to the bottom of the existing Javascript.
 // - "input": This is a JSON blob. Here you can add custom elements to the html template's pipeline.
+// - "input_yaml", "input_toml": Like "input", but written as YAML or TOML.
+// - "text", "amp": Optional replacements for "base", served to clients that want plain text and under AMPPrefix.
 //
 // Here is a simple example for rendering an index.html with a base.html:
 //
@@ -37,81 +39,527 @@
 //     {{ end }}
 //
 //     main.go:
-//     b, _ := NewBase("base.html", nil)
-//     h, _ := New(b, "/", "index.html", nil)
+//     b, _ := NewBase("base.html")
+//     h, _ := New(b, "index.html")
 //     http.Handle("/", h)
+//
+// Any template path may be a glob, such as "templates/partials/*.html", to parse every file it matches, and
+// Base.ParseGlob and Base.ParseDir add shared partials to a base for all of its pages to use.
+//
+// A page can also be built from several templates, e.g. New(b, "layouts/docs.html", "pages/emacs.html"), to share an
+// intermediate layout between the base and the page content. WithBases does the same for every page of a base, so
+// that the base itself can be split into a shell, a nav, a footer and so on.
+//
+// Pages can be translated by setting Base.Catalog. The "t" func then looks messages up in the locale negotiated for
+// each request, and input blocks named for a locale, e.g. "input.fr" or "input_yaml.fr", are merged over a template's
+// other input when rendering in that locale.
+//
+// NewBaseFS and NewFS do the same, but read the templates from an fs.FS, such as an embed.FS, so that a deployment
+// can be a single binary without a templates directory next to it.
 package templatehandler
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
 	"html/template"
+	"io/fs"
 	"net/http"
-	"strings"
+	"path"
+	"path/filepath"
+	"sync"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/mconbere/quitlikeapro/go/templatehandler/funcs"
+	"github.com/mconbere/quitlikeapro/go/templatehandler/i18n"
 )
 
 type Base struct {
 	Template *template.Template
-	Input    map[string]interface{}
+
+	// Input is given to every page created from this Base. Once pages are created, change it only with SetInput or
+	// UpdateInput, and read it with InputValue, since it may be replaced while they're serving.
+	Input map[string]interface{}
+
+	// LiveReload makes handlers created from this Base re-parse their templates whenever the base or page template
+	// changes on disk, so that edits show up without restarting the server. It is meant for development only; with it
+	// off, templates are parsed once.
+	LiveReload bool
+
+	// ErrorHandler is used by handlers created from this Base that don't set their own.
+	ErrorHandler ErrorHandler
+
+	// Markdown configures the "markdown" func of pages created from this Base. Set it before creating them.
+	Markdown MarkdownOptions
+
+	// OptionalBlocks names blocks, besides "css" and "js", that the base template uses but pages needn't define, such as
+	// "meta" or "sidebar". They are empty in pages that don't define them. Set it before creating pages.
+	OptionalBlocks []string
+
+	// StrictMode makes pages created from this Base fail to render when they use an input key that isn't set, as in a
+	// misspelled {{ .Titel }}, instead of rendering it as empty. Set it before creating them.
+	StrictMode bool
+
+	// NotFound serves the paths below an index page registered by MarkdownDir that aren't pages themselves. If nil,
+	// they get a plain 404. Set it before calling MarkdownDir.
+	NotFound http.Handler
+
+	// Sitemap, if set, lists the pages MarkdownDir registers. Set it before calling MarkdownDir.
+	Sitemap *Sitemap
+
+	// API makes MarkdownDir also register each page's TemplateHandler.JSON under APIPrefix. Set it before calling
+	// MarkdownDir.
+	API bool
+
+	// Metrics, if set, is told how long pages created from this Base take to render, and how often they fail.
+	Metrics Metrics
+
+	// AccessLog, if set, is told about each request served by the dynamic and static handlers of pages created from
+	// this Base.
+	AccessLog AccessLog
+
+	// SharedCache, if set, is bumped each time Reload or UpdateInput swaps in new templates or input, so that every
+	// instance sharing it stops serving the pages it holds, rendered from the old ones. Use it as the Store of the
+	// static handlers and OutputCaches of pages created from this Base.
+	SharedCache *VersionedStore
+
+	// CDN, if set, has its SiteKey purged each time Reload or UpdateInput swaps in new templates or input, as with
+	// SharedCache, so that the CDN in front of the site stops serving pages rendered from the old ones.
+	CDN *CDN
+
+	// Catalog translates pages created from this Base into each of its locales. Set it before creating them.
+	Catalog *i18n.Catalog
+
+	// Merge is how each page's input blocks and front matter are combined with the input they extend, and how input
+	// given at render time is combined with the page's. By default, top-level keys are replaced whole; MergeDeep lets
+	// a page add to a nested object of the base input instead. Set it before creating pages.
+	Merge MergeStrategy
+
+	src      source
+	layers   []source
+	partials []source
+	funcs    template.FuncMap
+	delims   [2]string
+	loaders  []namedLoader
+	sriURLs  []string
+	assets   map[string]sriAsset
+	env      map[string]bool
+
+	environment Environment
+
+	// fetched is the data fetched for the data URLs in the base's and pages' input, which Reload replaces so that
+	// they're fetched again.
+	fetched *urlData
+
+	// inputMu guards Template, Input and fetched, which SetInput, UpdateInput and Reload replace while pages may be
+	// reading them.
+	inputMu sync.RWMutex
+
+	crumbsMu sync.RWMutex
+	crumbs   map[string]crumbRoute
+
+	// reloadMu serializes Reload, which holds it while fetching and parsing the new templates and data, and updateMu
+	// serializes swapping them in, and the pages rebuilt from them, by Reload and UpdateInput. updates counts the
+	// swaps, so that Reload can tell whether UpdateInput swapped in new input while it was rebuilding pages.
+	reloadMu sync.Mutex
+	updateMu sync.Mutex
+	updates  int
+
+	// pagesMu guards the pages and static handlers created from the base.
+	pagesMu  sync.Mutex
+	pages    []*TemplateHandler
+	statics  []*staticHandler
 }
 
-func NewBase(tmpl string, input map[string]interface{}) (*Base, error) {
-	t := template.New("")
-	t, err := t.ParseFiles(tmpl)
+// NewBase parses the base template tmpl, configured by opts.
+func NewBase(tmpl string, opts ...Option) (*Base, error) {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	b := &Base{
+		Input:  o.input,
+		src:    source{fsys: o.fsys, path: tmpl},
+		funcs:  funcs.Map(),
+		delims: o.delims,
+	}
+	b.funcs["t"] = b.translator("")
+	b.funcs["formatDate"] = b.dateFormatter("")
+	b.funcs["formatNumber"] = b.numberFormatter("")
+	b.funcs["breadcrumbs"] = breadcrumbs
+	b.funcs["cspNonce"] = cspNonce
+	b.funcs["sri"] = b.sri
+	b.funcs["meta"] = meta
+	b.funcs["canonicalURL"] = canonicalURLFunc
+	b.funcs["isProd"] = b.isProd
+	for _, f := range o.funcs {
+		for k, v := range f {
+			b.funcs[k] = v
+		}
+	}
+	for _, c := range o.configure {
+		c(b)
+	}
+	if err := b.loadData(); err != nil {
+		return nil, err
+	}
+	if err := b.loadIntegrity(); err != nil {
+		return nil, err
+	}
+
+	t, err := b.parse()
 	if err != nil {
 		return nil, err
 	}
-	return &Base{
-		Template: t,
-		Input:    input,
-	}, nil
+	if err := b.checkLayers(t); err != nil {
+		return nil, err
+	}
+	b.Template = t
+
+	for _, p := range o.partials {
+		if err := p(b); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// NewBaseFS is like NewBase, but reads the base template from fsys.
+func NewBaseFS(fsys fs.FS, tmpl string, opts ...Option) (*Base, error) {
+	return NewBase(tmpl, append(opts, WithFS(fsys))...)
+}
+
+// newTemplate returns an empty template set for the base or a page template to be parsed into.
+func (b *Base) newTemplate() *template.Template {
+	return template.New("").Delims(b.delims[0], b.delims[1]).Funcs(b.funcs)
+}
+
+// internalTemplate is the template that the templates templatehandler defines itself are parsed through.
+const internalTemplate = "templatehandler-internal"
+
+// parseInternal parses text, written with the default delimiters, into t, whatever delimiters t uses.
+func parseInternal(t *template.Template, text string) error {
+	_, err := t.New(internalTemplate).Delims("", "").Parse(text)
+	return err
+}
+
+// parse parses a fresh copy of the base template and its partials.
+func (b *Base) parse() (*template.Template, error) {
+	t, err := b.src.parse(b.newTemplate())
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range b.partials {
+		if t, err = p.parse(t); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// checkLayers parses the base's layers over a clone of t, the parsed base template, so that a broken one fails NewBase
+// rather than every page created from it.
+func (b *Base) checkLayers(t *template.Template) error {
+	if len(b.layers) == 0 {
+		return nil
+	}
+	t, err := t.Clone()
+	if err != nil {
+		return err
+	}
+	for _, l := range b.layers {
+		if t, err = l.parse(t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sources returns the sources the base template is parsed from.
+func (b *Base) sources() []source {
+	return append([]source{b.src}, b.partials...)
+}
+
+// ParseGlob adds the templates matching pattern, e.g. "templates/partials/*.html", to the base, read from the same
+// filesystem as the base template. Shared partials such as a nav or a footer can then be used by every page created
+// from the base afterwards.
+func (b *Base) ParseGlob(pattern string) error {
+	return b.addPartials(source{fsys: b.src.fsys, path: pattern})
+}
+
+// ParseDir is like ParseGlob, but adds every .html file in dir and the directories below it.
+func (b *Base) ParseDir(dir string) error {
+	var srcs []source
+	walk := func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && path.Ext(p) == ".html" {
+			srcs = append(srcs, source{fsys: b.src.fsys, path: p})
+		}
+		return nil
+	}
+
+	var err error
+	if b.src.fsys == nil {
+		err = filepath.WalkDir(dir, walk)
+	} else {
+		err = fs.WalkDir(b.src.fsys, dir, walk)
+	}
+	if err != nil {
+		return err
+	}
+	if len(srcs) == 0 {
+		return fmt.Errorf("templatehandler: no templates in %q", dir)
+	}
+	return b.addPartials(srcs...)
+}
+
+func (b *Base) addPartials(srcs ...source) error {
+	prev := b.partials
+	b.partials = append(b.partials[:len(b.partials):len(b.partials)], srcs...)
+	t, err := b.parse()
+	if err != nil {
+		b.partials = prev
+		return err
+	}
+	b.Template = t
+	return nil
 }
 
 type TemplateHandler struct {
 	Template *template.Template
 	Input    map[string]interface{}
+
+	// Name identifies the page in Metrics and the AccessLog. It defaults to the path of the page's last template.
+	Name string
+
+	// ErrorHandler writes the response when the page fails to render. If nil, the Base's ErrorHandler is used, and if
+	// that is nil too, DefaultErrorHandler.
+	ErrorHandler ErrorHandler
+
+	// ServeJSON lets clients ask for the page's merged input as JSON, with Accept: application/json or ?format=json,
+	// instead of the rendered HTML.
+	ServeJSON bool
+
+	// Stream makes dynamic handlers execute the page straight to the response rather than buffering all of it first.
+	// Only the first StreamBuffer bytes (4KB if unset) are buffered, so that errors early in the page can still be
+	// reported with an error response.
+	Stream       bool
+	StreamBuffer int
+
+	// EarlyHints sends the page's preloads, listed under PreloadKey in its input, in a 103 Early Hints response before
+	// the page is rendered, as well as with the page itself.
+	EarlyHints bool
+
+	locales map[string]localized
+	text    *texttemplate.Template
+	base    *Base
+	srcs    []source
+	mu      sync.Mutex
+	modTime time.Time
+	bundle  *bundle
+	crumb   string
 }
 
-func New(base *Base, tmpl string) (*TemplateHandler, error) {
-	t, err := base.Template.Clone()
+// New creates a handler for a page made of the given templates, parsed in order on top of a clone of the base. Any
+// templates before the last are layouts: they can define blocks for the templates after them to fill in, and the
+// later templates can override anything they define. Each template's input block is merged over the ones before it.
+func New(base *Base, tmpls ...string) (*TemplateHandler, error) {
+	var srcs []source
+	for _, tmpl := range tmpls {
+		srcs = append(srcs, source{path: tmpl})
+	}
+	return newHandler(base, srcs)
+}
+
+// NewFS is like New, but reads the page templates from fsys.
+func NewFS(base *Base, fsys fs.FS, tmpls ...string) (*TemplateHandler, error) {
+	var srcs []source
+	for _, tmpl := range tmpls {
+		srcs = append(srcs, source{fsys: fsys, path: tmpl})
+	}
+	return newHandler(base, srcs)
+}
+
+func newHandler(base *Base, srcs []source) (*TemplateHandler, error) {
+	if len(srcs) == 0 {
+		return nil, errors.New("templatehandler: no page templates given")
+	}
+	srcs = append(base.layers[:len(base.layers):len(base.layers)], srcs...)
+
+	var modTime time.Time
+	if base.LiveReload {
+		var err error
+		if modTime, err = latestModTime(append(base.sources(), srcs...)...); err != nil {
+			return nil, err
+		}
+	}
+
+	p, err := build(base, base.snapshot(), srcs)
 	if err != nil {
 		return nil, err
 	}
 
-	t.Funcs(template.FuncMap{
-		"markdown": Markdown(t),
-	})
+	t := &TemplateHandler{
+		Template: p.tmpl,
+		Input:    p.input,
+		Name:     srcs[len(srcs)-1].path,
+		locales:  p.locales,
+		text:     p.text,
+		base:     base,
+		srcs:     srcs,
+		modTime:  modTime,
+	}
+	base.pagesMu.Lock()
+	base.pages = append(base.pages, t)
+	base.pagesMu.Unlock()
+	return t, nil
+}
+
+// page is a built page: its template and input, and a copy of both for each locale of the Base's catalog.
+type page struct {
+	tmpl    *template.Template
+	text    *texttemplate.Template
+	input   map[string]interface{}
+	locales map[string]localized
+}
 
-	t, err = t.ParseFiles(tmpl)
+// build parses the page templates srcs in order on top of a clone of s's base template. The page's input is made of the
+// input blocks of the base and of each page template in turn, merged over s's base input.
+func build(base *Base, s snapshot, srcs []source) (*page, error) {
+	t, err := s.tmpl.Clone()
 	if err != nil {
 		return nil, err
 	}
+	t.Funcs(base.pageFuncs(t, ""))
+	if base.StrictMode {
+		t.Option("missingkey=error")
+	}
 
-	if t.Lookup("js") == nil {
-		if _, err := t.Parse("{{ define \"js\" }}{{ end }}"); err != nil {
+	// Reading input executes the template it's read from, and an executed template can't be parsed into any more, so
+	// read the base's input from a clone of its own.
+	bt, err := s.tmpl.Clone()
+	if err != nil {
+		return nil, err
+	}
+	input, err := mergeInputs(base.Merge, s.input, bt, "")
+	if err != nil {
+		return nil, err
+	}
+	localeInputs := make(map[string]map[string]interface{})
+	for _, l := range base.locales() {
+		if localeInputs[l], err = mergeInputs(base.Merge, input, bt, l); err != nil {
 			return nil, err
 		}
 	}
-	if t.Lookup("css") == nil {
-		if _, err := t.Parse("{{ define \"css\" }}{{ end }}"); err != nil {
+
+	for _, src := range srcs {
+		if t, err = src.parse(t); err != nil {
 			return nil, err
 		}
-	}
 
-	input := base.Input
-	if t.Lookup("input") != nil {
-		js, err := jsonFromTmpl(t, "input")
+		// Read each template's input from that template alone, so that one without an input block doesn't pick up
+		// the block of the template before it a second time.
+		layer := base.newTemplate()
+		layer.Funcs(base.pageFuncs(layer, ""))
+		if layer, err = src.parse(layer); err != nil {
+			return nil, err
+		}
+		fm, err := src.frontMatter(base.Markdown)
 		if err != nil {
 			return nil, err
 		}
-		input = mergeMap(base.Input, js)
+		if input, err = mergeLayer(base.Merge, input, layer, fm, ""); err != nil {
+			return nil, err
+		}
+		for l, in := range localeInputs {
+			if localeInputs[l], err = mergeLayer(base.Merge, in, layer, fm, l); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if input, err = base.resolveInput(input, s.fetched); err != nil {
+		return nil, err
+	}
+	for l, in := range localeInputs {
+		if localeInputs[l], err = base.resolveInput(in, s.fetched); err != nil {
+			return nil, err
+		}
 	}
 
-	return &TemplateHandler{
-		Template: t,
-		Input:    input,
+	for _, name := range append([]string{"css", "js"}, base.OptionalBlocks...) {
+		if t.Lookup(name) == nil {
+			if err := parseInternal(t, fmt.Sprintf("{{ define %q }}{{ end }}", name)); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	text, err := base.textTemplate(t, "")
+	if err != nil {
+		return nil, err
+	}
+	locales, err := base.localize(t, localeInputs)
+	if err != nil {
+		return nil, err
+	}
+	return &page{
+		tmpl:    t,
+		text:    text,
+		input:   input,
+		locales: locales,
 	}, nil
 }
 
+// pageFuncs are the funcs templatehandler provides to every page template, translating and formatting for locale.
+func (b *Base) pageFuncs(t *template.Template, locale string) template.FuncMap {
+	return template.FuncMap{
+		"markdown":     MarkdownWith(t, b.Markdown),
+		"markdownFile": b.markdownFile,
+		"t":            b.translator(locale),
+		"formatDate":   b.dateFormatter(locale),
+		"formatNumber": b.numberFormatter(locale),
+	}
+}
+
+// mergeLayer merges the input blocks and front matter fm of one page template over input with m, followed by the
+// template's input blocks for locale, if any.
+func mergeLayer(m MergeStrategy, input map[string]interface{}, layer *template.Template, fm map[string]interface{}, locale string) (map[string]interface{}, error) {
+	input, err := mergeInputs(m, input, layer, "")
+	if err != nil {
+		return nil, err
+	}
+	input = m.merge(input, fm)
+	if locale == "" {
+		return input, nil
+	}
+	return mergeInputs(m, input, layer, locale)
+}
+
+// mergeInputs merges each of the input blocks defined in t over input with m. If locale is set, only the blocks for
+// that locale, such as "input.fr", are merged.
+func mergeInputs(m MergeStrategy, input map[string]interface{}, t *template.Template, locale string) (map[string]interface{}, error) {
+	for _, f := range inputFormats {
+		name := f.name
+		if locale != "" {
+			name += "." + locale
+		}
+		if t.Lookup(name) == nil {
+			continue
+		}
+		in, err := inputFromTmpl(t, name, f.format, f.unmarshal)
+		if err != nil {
+			return nil, err
+		}
+		input = m.merge(input, in)
+	}
+	return input, nil
+}
+
 func Must(t *TemplateHandler, err error) *TemplateHandler {
 	if err != nil {
 		panic(err)
@@ -119,19 +567,41 @@ func Must(t *TemplateHandler, err error) *TemplateHandler {
 	return t
 }
 
-func cleanPath(in string) string {
-	out := strings.ToLower(strings.TrimSuffix(strings.TrimSuffix(in, "index.html"), "/"))
-	if out == "" {
-		out = "/"
+// Render renders the page with input merged over its own, as a dynamic handler would but without a request. It's for
+// output that isn't an HTTP response, such as email bodies, and for checking a page's output in tests. The page is
+// rendered in the Catalog's default locale, and the input has no RequestKey.
+func (t *TemplateHandler) Render(input map[string]interface{}) ([]byte, error) {
+	b, err := t.renderTemplate("", "base", input)
+	if err != nil {
+		return nil, err
 	}
-	return out
+	return withNonce(nil, b), nil
 }
 
+// render renders the variant of the page r asks for, in its locale, and sets the headers for serving it on w.
 func (t *TemplateHandler) render(w http.ResponseWriter, r *http.Request, input map[string]interface{}) ([]byte, error) {
-	input = mergeMap(t.Input, input)
+	variant := t.variant(r)
+	b, err := t.renderVariant(t.locale(r), variant, input)
+	if err != nil {
+		return nil, err
+	}
+	t.varyVariant(w)
+	setVariantHeaders(w.Header(), variant)
+	return b, nil
+}
+
+// renderTemplate executes the named template in locale with input merged over the page input.
+func (t *TemplateHandler) renderTemplate(locale, name string, input map[string]interface{}) ([]byte, error) {
+	tmpl, pageInput, err := t.current(locale)
+	if err != nil {
+		return nil, err
+	}
+	input = t.withBreadcrumbs(t.base.Merge.merge(pageInput, input))
 
 	var b bytes.Buffer
-	err := t.Template.ExecuteTemplate(&b, "base", input)
+	start := time.Now()
+	err = tmpl.ExecuteTemplate(&b, name, input)
+	t.rendered(start, err)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,248 @@
+package templatehandlertest
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+)
+
+// Find returns the elements of page matching selector, in document order, and fails the test if either can't be
+// parsed.
+//
+// Selectors are a subset of CSS: compound selectors of a tag name or *, #id, .class, [attr] and [attr=value], joined by
+// the descendant (space) and child (>) combinators, and grouped with commas. For example "nav a.active",
+// "main > h1" or "meta[name=description]".
+func Find(tb testing.TB, page []byte, selector string) []*html.Node {
+	tb.Helper()
+	groups, err := parseSelector(selector)
+	if err != nil {
+		tb.Fatalf("bad selector %q: %v", selector, err)
+	}
+	doc, err := html.Parse(bytes.NewReader(page))
+	if err != nil {
+		tb.Fatalf("parsing page: %v", err)
+	}
+
+	var found []*html.Node
+	var visit func(n *html.Node)
+	visit = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			for _, g := range groups {
+				if g.match(n) {
+					found = append(found, n)
+					break
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			visit(c)
+		}
+	}
+	visit(doc)
+	return found
+}
+
+// AssertCount fails the test unless exactly n elements of page match selector.
+func AssertCount(tb testing.TB, page []byte, selector string, n int) {
+	tb.Helper()
+	if got := len(Find(tb, page, selector)); got != n {
+		tb.Errorf("%q matches %d elements, want %d", selector, got, n)
+	}
+}
+
+// AssertText fails the test unless the first element of page matching selector has the text want, with whitespace
+// normalized as by Text.
+func AssertText(tb testing.TB, page []byte, selector, want string) {
+	tb.Helper()
+	found := Find(tb, page, selector)
+	if len(found) == 0 {
+		tb.Errorf("%q matches no elements", selector)
+		return
+	}
+	if got := Text(found[0]); got != want {
+		tb.Errorf("%q has text %q, want %q", selector, got, want)
+	}
+}
+
+// Text returns the text content of n, with runs of whitespace collapsed to a single space and the ends trimmed.
+func Text(n *html.Node) string {
+	var b strings.Builder
+	var visit func(n *html.Node)
+	visit = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			b.WriteString(n.Data)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			visit(c)
+		}
+	}
+	visit(n)
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+// Attr returns the value of n's attribute key, and whether it has one.
+func Attr(n *html.Node, key string) (string, bool) {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val, true
+		}
+	}
+	return "", false
+}
+
+// selector is a chain of compound selectors, rightmost last, each with the combinator joining it to the one before.
+type selector []step
+
+type step struct {
+	compound
+	// child is whether this step must be a child of the previous one, rather than any descendant.
+	child bool
+}
+
+type compound struct {
+	tag     string
+	id      string
+	classes []string
+	attrs   []attrMatch
+}
+
+type attrMatch struct {
+	key, val string
+	// hasVal is whether the attribute must equal val, rather than just be present.
+	hasVal bool
+}
+
+func parseSelector(s string) ([]selector, error) {
+	var groups []selector
+	for _, group := range strings.Split(s, ",") {
+		// Make the combinators their own fields.
+		fields := strings.Fields(strings.ReplaceAll(group, ">", " > "))
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("empty selector")
+		}
+		var sel selector
+		child := false
+		for _, f := range fields {
+			if f == ">" {
+				if len(sel) == 0 || child {
+					return nil, fmt.Errorf("misplaced >")
+				}
+				child = true
+				continue
+			}
+			c, err := parseCompound(f)
+			if err != nil {
+				return nil, err
+			}
+			sel = append(sel, step{compound: c, child: child})
+			child = false
+		}
+		if child {
+			return nil, fmt.Errorf("selector ends with >")
+		}
+		groups = append(groups, sel)
+	}
+	return groups, nil
+}
+
+func parseCompound(s string) (compound, error) {
+	var c compound
+	// name reads an identifier from the start of s.
+	name := func() string {
+		i := strings.IndexAny(s, "#.[")
+		if i < 0 {
+			i = len(s)
+		}
+		n := s[:i]
+		s = s[i:]
+		return n
+	}
+
+	c.tag = strings.ToLower(name())
+	if c.tag == "*" {
+		c.tag = ""
+	}
+	for s != "" {
+		kind := s[0]
+		s = s[1:]
+		switch kind {
+		case '#':
+			c.id = name()
+		case '.':
+			c.classes = append(c.classes, name())
+		case '[':
+			end := strings.IndexByte(s, ']')
+			if end < 0 {
+				return c, fmt.Errorf("unclosed [")
+			}
+			a := attrMatch{key: s[:end]}
+			if i := strings.IndexByte(a.key, '='); i >= 0 {
+				a.key, a.val, a.hasVal = a.key[:i], strings.Trim(a.key[i+1:], `"'`), true
+			}
+			a.key = strings.ToLower(a.key)
+			c.attrs = append(c.attrs, a)
+			s = s[end+1:]
+		}
+	}
+	return c, nil
+}
+
+func (c *compound) match(n *html.Node) bool {
+	if n.Type != html.ElementNode || (c.tag != "" && n.Data != c.tag) {
+		return false
+	}
+	if c.id != "" {
+		if id, _ := Attr(n, "id"); id != c.id {
+			return false
+		}
+	}
+	if len(c.classes) > 0 {
+		class, _ := Attr(n, "class")
+		have := strings.Fields(class)
+		for _, want := range c.classes {
+			if !contains(have, want) {
+				return false
+			}
+		}
+	}
+	for _, a := range c.attrs {
+		v, ok := Attr(n, a.key)
+		if !ok || (a.hasVal && v != a.val) {
+			return false
+		}
+	}
+	return true
+}
+
+// match reports whether n is matched by the last step of sel, with its ancestors matching the steps before it.
+func (sel selector) match(n *html.Node) bool {
+	last := len(sel) - 1
+	if !sel[last].match(n) {
+		return false
+	}
+	if last == 0 {
+		return true
+	}
+	rest := sel[:last]
+	for p := n.Parent; p != nil; p = p.Parent {
+		if rest.match(p) {
+			return true
+		}
+		if sel[last].child {
+			return false
+		}
+	}
+	return false
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,95 @@
+// Package templatehandlertest provides helpers for testing pages served by templatehandler: rendering them against
+// golden files, and checking parts of their output with CSS selectors.
+//
+// Golden files are rewritten, rather than compared against, when the tests are run with -update:
+//
+//	func TestIndex(t *testing.T) {
+//		templatehandlertest.Golden(t, index, nil, "testdata/index.golden")
+//		page := templatehandlertest.MustRender(t, index, nil)
+//		templatehandlertest.AssertText(t, page, "h1", "Quit like a pro")
+//	}
+package templatehandlertest
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mconbere/quitlikeapro/go/templatehandler"
+)
+
+var update = flag.Bool("update", false, "rewrite golden files with the current output instead of comparing against them")
+
+// MustRender renders h with input, as TemplateHandler.Render does, and fails the test if it can't.
+func MustRender(tb testing.TB, h *templatehandler.TemplateHandler, input map[string]interface{}) []byte {
+	tb.Helper()
+	b, err := h.Render(input)
+	if err != nil {
+		tb.Fatalf("rendering page: %v", err)
+	}
+	return b
+}
+
+// Golden renders h with input and compares the result, normalized with Normalize, against the golden file. With
+// -update, it writes the file instead.
+func Golden(tb testing.TB, h *templatehandler.TemplateHandler, input map[string]interface{}, golden string) {
+	tb.Helper()
+	got := Normalize(MustRender(tb, h, input))
+
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(golden), 0755); err != nil {
+			tb.Fatal(err)
+		}
+		if err := os.WriteFile(golden, got, 0644); err != nil {
+			tb.Fatal(err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		tb.Fatalf("reading golden file (run with -update to create it): %v", err)
+	}
+	if line, g, w, ok := firstDiff(got, Normalize(want)); !ok {
+		tb.Errorf("page differs from %s at line %d (run with -update to accept):\n got: %s\nwant: %s", golden, line, g, w)
+	}
+}
+
+// Normalize makes rendered output insensitive to changes in indentation and blank lines: each line is trimmed, runs of
+// whitespace within it collapsed to a single space, and empty lines dropped. Whitespace in <pre> elements is
+// normalized too.
+func Normalize(b []byte) []byte {
+	var out bytes.Buffer
+	for _, line := range strings.Split(string(b), "\n") {
+		line = strings.Join(strings.Fields(line), " ")
+		if line == "" {
+			continue
+		}
+		out.WriteString(line)
+		out.WriteByte('\n')
+	}
+	return out.Bytes()
+}
+
+// firstDiff compares got and want line by line, returning the first line number at which they differ and the lines
+// there, or ok if they are the same.
+func firstDiff(got, want []byte) (line int, g, w string, ok bool) {
+	gl := strings.Split(string(got), "\n")
+	wl := strings.Split(string(want), "\n")
+	for i := 0; i < len(gl) || i < len(wl); i++ {
+		g, w = "(end of page)", "(end of page)"
+		if i < len(gl) {
+			g = gl[i]
+		}
+		if i < len(wl) {
+			w = wl[i]
+		}
+		if g != w {
+			return i + 1, g, w, false
+		}
+	}
+	return 0, "", "", true
+}
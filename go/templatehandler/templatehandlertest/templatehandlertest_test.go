@@ -0,0 +1,182 @@
+package templatehandlertest
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/mconbere/quitlikeapro/go/templatehandler"
+)
+
+var pages = fstest.MapFS{
+	"base.html": {Data: []byte(`{{ define "base" }}<html><body>{{ template "content" . }}</body></html>{{ end }}`)},
+	"page.html": {Data: []byte(`{{ define "content" }}
+  <h1 id="title" class="big  title">  Quit   {{ .Name }} </h1>
+  <ul><li><a href="/vim">Vim</a></li><li><a href="/emacs" class="active">Emacs</a></li></ul>
+  <meta name="description" content="How to quit">
+{{ end }}`)},
+	"broken.html": {Data: []byte(`{{ define "content" }}{{ template "missing" . }}{{ end }}`)},
+}
+
+func newPage(t *testing.T, name string) *templatehandler.TemplateHandler {
+	t.Helper()
+	base, err := templatehandler.NewBaseFS(pages, "base.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return templatehandler.Must(templatehandler.NewFS(base, pages, name))
+}
+
+// recorder is a testing.TB that notes failures rather than reporting them, so that the helpers' own can be checked.
+type recorder struct {
+	testing.TB
+	failures []string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.failures = append(r.failures, fmt.Sprintf(format, args...))
+}
+
+func (r *recorder) Fatalf(format string, args ...interface{}) {
+	r.Errorf(format, args...)
+	runtime.Goexit()
+}
+
+func (r *recorder) Fatal(args ...interface{}) {
+	r.Fatalf("%s", fmt.Sprint(args...))
+}
+
+// failures runs f with a recorder, in a goroutine of its own so that Fatalf can stop it, and returns its failures.
+func failures(t *testing.T, f func(tb testing.TB)) []string {
+	r := &recorder{TB: t}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f(r)
+	}()
+	<-done
+	return r.failures
+}
+
+func TestMustRender(t *testing.T) {
+	page := MustRender(t, newPage(t, "page.html"), map[string]interface{}{"Name": "Vim"})
+	if !strings.Contains(string(page), "Quit   Vim") {
+		t.Errorf("MustRender = %q, want the page with its input", page)
+	}
+	if f := failures(t, func(tb testing.TB) { MustRender(tb, newPage(t, "broken.html"), nil) }); len(f) != 1 {
+		t.Errorf("MustRender of a broken page failed with %q, want one failure", f)
+	}
+}
+
+func TestFind(t *testing.T) {
+	page := MustRender(t, newPage(t, "page.html"), map[string]interface{}{"Name": "Vim"})
+	for _, tt := range []struct {
+		selector string
+		n        int
+	}{
+		{"h1", 1},
+		{"#title", 1},
+		{".title.big", 1},
+		{"ul a", 2},
+		{"ul > a", 0},
+		{"li > a.active", 1},
+		{"a[href=/vim]", 1},
+		{"meta[name=description]", 1},
+		{"h1, li", 3},
+		{"*[class]", 2},
+		{"table", 0},
+	} {
+		if got := len(Find(t, page, tt.selector)); got != tt.n {
+			t.Errorf("Find(%q) found %d elements, want %d", tt.selector, got, tt.n)
+		}
+	}
+	if f := failures(t, func(tb testing.TB) { Find(tb, page, "a[href") }); len(f) != 1 {
+		t.Errorf("Find with a bad selector failed with %q, want one failure", f)
+	}
+}
+
+func TestAssertCount(t *testing.T) {
+	page := MustRender(t, newPage(t, "page.html"), map[string]interface{}{"Name": "Vim"})
+	if f := failures(t, func(tb testing.TB) { AssertCount(tb, page, "li", 2) }); len(f) != 0 {
+		t.Errorf("AssertCount of the right count failed with %q", f)
+	}
+	if f := failures(t, func(tb testing.TB) { AssertCount(tb, page, "li", 3) }); len(f) != 1 {
+		t.Errorf("AssertCount of the wrong count failed with %q, want one failure", f)
+	}
+}
+
+func TestAssertText(t *testing.T) {
+	page := MustRender(t, newPage(t, "page.html"), map[string]interface{}{"Name": "Vim"})
+	for _, tt := range []struct {
+		selector, want string
+		failures       int
+	}{
+		{"h1", "Quit Vim", 0},
+		{"a", "Vim", 0},
+		{"h1", "Quit Emacs", 1},
+		{"table", "", 1},
+	} {
+		if f := failures(t, func(tb testing.TB) { AssertText(tb, page, tt.selector, tt.want) }); len(f) != tt.failures {
+			t.Errorf("AssertText(%q, %q) failed with %q, want %d failures", tt.selector, tt.want, f, tt.failures)
+		}
+	}
+}
+
+func TestAttr(t *testing.T) {
+	page := MustRender(t, newPage(t, "page.html"), map[string]interface{}{"Name": "Vim"})
+	a := Find(t, page, "a.active")[0]
+	if v, ok := Attr(a, "href"); !ok || v != "/emacs" {
+		t.Errorf("Attr(href) = %q, %v; want %q, true", v, ok, "/emacs")
+	}
+	if v, ok := Attr(a, "title"); ok {
+		t.Errorf("Attr(title) = %q, true; want none", v)
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	got := string(Normalize([]byte("  <p>\n\n\t a   b  </p>\n")))
+	if want := "<p>\na b </p>\n"; got != want {
+		t.Errorf("Normalize = %q, want %q", got, want)
+	}
+}
+
+func TestGolden(t *testing.T) {
+	page := newPage(t, "page.html")
+	input := map[string]interface{}{"Name": "Vim"}
+	golden := filepath.Join(t.TempDir(), "testdata", "page.golden")
+	defer func(u bool) { *update = u }(*update)
+	*update = false
+
+	if f := failures(t, func(tb testing.TB) { Golden(tb, page, input, golden) }); len(f) != 1 {
+		t.Errorf("Golden without a golden file failed with %q, want one failure", f)
+	}
+
+	*update = true
+	Golden(t, page, input, golden)
+	*update = false
+	if b, err := os.ReadFile(golden); err != nil || string(b) != string(Normalize(MustRender(t, page, input))) {
+		t.Fatalf("Golden with -update wrote %q, %v; want the normalized page", b, err)
+	}
+
+	if f := failures(t, func(tb testing.TB) { Golden(tb, page, input, golden) }); len(f) != 0 {
+		t.Errorf("Golden of the same page failed with %q", f)
+	}
+	// Indentation doesn't matter, but the text does.
+	b, _ := os.ReadFile(golden)
+	if err := os.WriteFile(golden, []byte(strings.ReplaceAll(string(b), "\n", "\n    ")), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if f := failures(t, func(tb testing.TB) { Golden(tb, page, input, golden) }); len(f) != 0 {
+		t.Errorf("Golden of the page reindented failed with %q", f)
+	}
+	f := failures(t, func(tb testing.TB) { Golden(tb, page, map[string]interface{}{"Name": "Emacs"}, golden) })
+	if len(f) != 1 || !strings.Contains(f[0], "Quit Emacs") {
+		t.Errorf("Golden of a different page failed with %q, want one failure showing the line", f)
+	}
+}
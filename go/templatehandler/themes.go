@@ -0,0 +1,110 @@
+package templatehandler
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// DefaultThemeCookie is the cookie and query parameter a visitor's chosen theme is read from, unless Themes says
+// otherwise.
+const DefaultThemeCookie = "theme"
+
+// Themes serves pages in one of several themes, such as "light", "dark" and "terminal", chosen per request. Each
+// theme is a Base of its own, and each page is built once per theme, so static pages are cached separately for each.
+type Themes struct {
+	// Default is the theme for visitors who haven't chosen one.
+	Default string
+
+	// Cookie and Param are the cookie and query parameter the chosen theme is read from, DefaultThemeCookie if
+	// unset. A theme chosen with the parameter, as in /?theme=dark, is remembered in the cookie.
+	Cookie string
+	Param  string
+
+	bases map[string]*Base
+}
+
+// NewThemes returns Themes for the given bases, keyed by theme name. def must be one of them.
+func NewThemes(def string, bases map[string]*Base) (*Themes, error) {
+	if _, ok := bases[def]; !ok {
+		return nil, fmt.Errorf("templatehandler: default theme %q has no base", def)
+	}
+	return &Themes{Default: def, bases: bases}, nil
+}
+
+// Names returns the names of the themes, sorted.
+func (th *Themes) Names() []string {
+	var names []string
+	for name := range th.bases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Handler builds a handler for each theme with build, which is given the theme's Base, and returns a handler that
+// serves each request with the one for the request's theme:
+//
+//	index, err := themes.Handler(func(b *templatehandler.Base) (http.Handler, error) {
+//		h, err := templatehandler.New(b, "templates/index.html")
+//		if err != nil {
+//			return nil, err
+//		}
+//		return h.Static(nil), nil
+//	})
+func (th *Themes) Handler(build func(b *Base) (http.Handler, error)) (http.Handler, error) {
+	handlers := make(map[string]http.Handler)
+	for name, b := range th.bases {
+		h, err := build(b)
+		if err != nil {
+			return nil, fmt.Errorf("theme %q: %v", name, err)
+		}
+		handlers[name] = h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		addVary(w.Header(), "Cookie")
+		handlers[th.choose(w, r)].ServeHTTP(w, r)
+	}), nil
+}
+
+// Theme returns the name of the theme r asks for: the one named by the query parameter, then by the cookie, and the
+// default if neither names a theme.
+func (th *Themes) Theme(r *http.Request) string {
+	if name := r.URL.Query().Get(th.param()); th.bases[name] != nil {
+		return name
+	}
+	if c, err := r.Cookie(th.cookie()); err == nil && th.bases[c.Value] != nil {
+		return c.Value
+	}
+	return th.Default
+}
+
+// choose returns the theme for r, remembering a theme chosen with the query parameter in the cookie.
+func (th *Themes) choose(w http.ResponseWriter, r *http.Request) string {
+	name := th.Theme(r)
+	if r.URL.Query().Get(th.param()) == name {
+		http.SetCookie(w, &http.Cookie{
+			Name:     th.cookie(),
+			Value:    name,
+			Path:     "/",
+			MaxAge:   int((365 * 24 * time.Hour).Seconds()),
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+	return name
+}
+
+func (th *Themes) cookie() string {
+	if th.Cookie == "" {
+		return DefaultThemeCookie
+	}
+	return th.Cookie
+}
+
+func (th *Themes) param() string {
+	if th.Param == "" {
+		return DefaultThemeCookie
+	}
+	return th.Param
+}
@@ -0,0 +1,84 @@
+package templatehandler
+
+import (
+	"bytes"
+	"html/template"
+	"regexp"
+
+	"github.com/mconbere/quitlikeapro/go/templatehandler/funcs"
+	"github.com/russross/blackfriday"
+)
+
+// TOCKey is the input key under which pages built from a Markdown file find its table of contents, when the Base's
+// MarkdownOptions set TOC.
+const TOCKey = "TOC"
+
+// TOCEntry is a heading in a Markdown file's table of contents, with the headings below it before the next heading
+// of its level or higher as its Children. A sidebar can link to each as <a href="#{{ .ID }}">{{ .Title }}</a>.
+type TOCEntry struct {
+	// Title is the heading's text, without its formatting.
+	Title string
+
+	// HTML is the heading's rendered contents, with its formatting.
+	HTML template.HTML
+
+	// ID is the heading's id attribute, for linking to it.
+	ID string
+
+	// Level is 1 for a level one heading (#), 2 for a level two heading (##), and so on.
+	Level int
+
+	Children []*TOCEntry
+}
+
+// markdownTOC returns the table of contents of the Markdown in, with the ids its headings get when rendered with
+// opts. Headings whose file skips a level, such as a ### straight after a #, are nested under the nearest heading
+// above them of a higher level, and headings with no such heading are at the top.
+func markdownTOC(in []byte, opts MarkdownOptions) []*TOCEntry {
+	flags, extensions := markdownFlags(opts)
+	r := &tocRenderer{Renderer: blackfriday.HtmlRenderer(flags, "", "")}
+	blackfriday.MarkdownOptions(in, r, blackfriday.Options{Extensions: extensions})
+
+	var toc []*TOCEntry
+	var open []*TOCEntry
+	for _, e := range r.headings {
+		for len(open) > 0 && open[len(open)-1].Level >= e.Level {
+			open = open[:len(open)-1]
+		}
+		if len(open) == 0 {
+			toc = append(toc, e)
+		} else {
+			parent := open[len(open)-1]
+			parent.Children = append(parent.Children, e)
+		}
+		open = append(open, e)
+	}
+	return toc
+}
+
+// headerID matches the id blackfriday's HTML renderer gives a heading's opening tag.
+var headerID = regexp.MustCompile(`<h\d id="([^"]*)">$`)
+
+// tocRenderer is a blackfriday renderer that records each heading as the wrapped renderer renders it, so that their
+// ids are the ones the rendered page uses, made unique in the same way.
+type tocRenderer struct {
+	blackfriday.Renderer
+	headings []*TOCEntry
+}
+
+func (r *tocRenderer) Header(out *bytes.Buffer, text func() bool, level int, id string) {
+	marker := out.Len()
+	r.Renderer.Header(out, func() bool {
+		start := out.Len()
+		if !text() {
+			return false
+		}
+		e := &TOCEntry{HTML: template.HTML(out.Bytes()[start:]), Level: level}
+		e.Title = funcs.PlainText(e.HTML)
+		if m := headerID.FindSubmatch(out.Bytes()[marker:start]); m != nil {
+			e.ID = string(m[1])
+		}
+		r.headings = append(r.headings, e)
+		return true
+	}, level, id)
+}
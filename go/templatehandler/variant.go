@@ -0,0 +1,128 @@
+package templatehandler
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"mime"
+	"net/http"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+// AMPPrefix is the path prefix under which pages that define an "amp" template serve it, e.g. /amp/about for /about.
+const AMPPrefix = "/amp/"
+
+// The templates a page can define to be rendered in place of "base" for some requests.
+const (
+	textVariant = "text"
+	ampVariant  = "amp"
+)
+
+// variant returns the name of the template to render for r: "amp" for paths under AMPPrefix, "text" for clients that
+// want plain text, such as curl, and otherwise "base". A variant is only used if the page defines it.
+func (t *TemplateHandler) variant(r *http.Request) string {
+	tmpl, _, err := t.current("")
+	if err != nil {
+		return "base"
+	}
+	if strings.HasPrefix(r.URL.Path, AMPPrefix) && tmpl.Lookup(ampVariant) != nil {
+		return ampVariant
+	}
+	if tmpl.Lookup(textVariant) != nil && wantsText(r) {
+		return textVariant
+	}
+	return "base"
+}
+
+// wantsText reports whether r asks for plain text, with a format=text query parameter, an Accept header that lists
+// text/plain but not text/html, or from a command line client.
+func wantsText(r *http.Request) bool {
+	if r.URL.Query().Get("format") == "text" {
+		return true
+	}
+	ua := r.Header.Get("User-Agent")
+	for _, client := range []string{"curl/", "Wget/", "HTTPie/"} {
+		if strings.HasPrefix(ua, client) {
+			return true
+		}
+	}
+	var hasText, hasHTML bool
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		t, _, err := mime.ParseMediaType(part)
+		if err != nil {
+			continue
+		}
+		switch t {
+		case "text/plain":
+			hasText = true
+		case "text/html":
+			hasHTML = true
+		}
+	}
+	return hasText && !hasHTML
+}
+
+// varyVariant records on w that the response depends on the headers the text variant is negotiated from, if the page
+// has one.
+func (t *TemplateHandler) varyVariant(w http.ResponseWriter) {
+	tmpl, _, err := t.current("")
+	if err == nil && tmpl.Lookup(textVariant) != nil {
+		addVary(w.Header(), "Accept", "User-Agent")
+	}
+}
+
+// renderVariant renders the variant template of the page in locale. The text variant is executed with text/template,
+// so that nothing in it is HTML escaped.
+func (t *TemplateHandler) renderVariant(locale, variant string, input map[string]interface{}) ([]byte, error) {
+	if variant != textVariant {
+		return t.renderTemplate(locale, variant, input)
+	}
+	tmpl, pageInput, err := t.currentText(locale)
+	if err != nil {
+		return nil, err
+	}
+	if tmpl == nil {
+		return nil, fmt.Errorf("templatehandler: page %q has no %q template", t.Name, textVariant)
+	}
+	input = t.withBreadcrumbs(t.base.Merge.merge(pageInput, input))
+
+	var b bytes.Buffer
+	start := time.Now()
+	err = tmpl.ExecuteTemplate(&b, textVariant, input)
+	t.rendered(start, err)
+	if err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// textTemplate returns a text/template copy of tmpl, a page's template for locale, for rendering its text variant, or
+// nil if it has none. It's made from the parse trees html/template would escape, so call it before tmpl is executed.
+func (b *Base) textTemplate(tmpl *template.Template, locale string) (*texttemplate.Template, error) {
+	if tmpl.Lookup(textVariant) == nil {
+		return nil, nil
+	}
+	text := texttemplate.New("").Funcs(texttemplate.FuncMap(b.funcs)).Funcs(texttemplate.FuncMap(b.pageFuncs(tmpl, locale)))
+	if b.StrictMode {
+		text.Option("missingkey=error")
+	}
+	for _, t := range tmpl.Templates() {
+		if t.Tree == nil {
+			continue
+		}
+		if _, err := text.AddParseTree(t.Name(), t.Tree.Copy()); err != nil {
+			return nil, err
+		}
+	}
+	return text, nil
+}
+
+// setVariantHeaders sets the headers for serving variant.
+func setVariantHeaders(h http.Header, variant string) {
+	if variant == textVariant {
+		h.Set("Content-Type", "text/plain; charset=utf-8")
+		h.Set("X-Content-Type-Options", "nosniff")
+	}
+}
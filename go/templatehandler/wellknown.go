@@ -0,0 +1,122 @@
+package templatehandler
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Robots serves a robots.txt, asking crawlers to keep out of some of the site.
+type Robots struct {
+	// Allow and Disallow are the path prefixes all crawlers may and may not visit, e.g. "/_admin/".
+	Allow    []string `json:"Allow" yaml:"Allow" toml:"Allow"`
+	Disallow []string `json:"Disallow" yaml:"Disallow" toml:"Disallow"`
+
+	// Sitemap is the path of the site's sitemap, if it has one, e.g. "/sitemap.xml".
+	Sitemap string `json:"Sitemap" yaml:"Sitemap" toml:"Sitemap"`
+
+	// Private, if set and true for the request, makes the robots.txt disallow everything instead, e.g. for staging
+	// versions of the site that shouldn't show up in search results next to it.
+	Private func(r *http.Request) bool `json:"-" yaml:"-" toml:"-"`
+}
+
+func (rb *Robots) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var b strings.Builder
+	b.WriteString("User-agent: *\n")
+	if rb.Private != nil && rb.Private(r) {
+		b.WriteString("Disallow: /\n")
+	} else {
+		for _, p := range rb.Allow {
+			fmt.Fprintf(&b, "Allow: %s\n", p)
+		}
+		for _, p := range rb.Disallow {
+			fmt.Fprintf(&b, "Disallow: %s\n", p)
+		}
+		if len(rb.Allow) == 0 && len(rb.Disallow) == 0 {
+			// An empty Disallow allows everything.
+			b.WriteString("Disallow:\n")
+		}
+		if rb.Sitemap != "" {
+			fmt.Fprintf(&b, "\nSitemap: %s\n", absoluteURL(r, rb.Sitemap))
+		}
+	}
+	writeText(w, r, b.String())
+}
+
+// IsVersionHost reports whether r was sent to the address of a particular App Engine version or service, such as
+// 1-0-dot-example.appspot.com or beta-dot-example.appspot.com, rather than to the site's own.
+func IsVersionHost(r *http.Request) bool {
+	return strings.Contains(r.Host, "-dot-")
+}
+
+// DefaultSecurityExpiry is how far ahead a SecurityTxt without an Expires date says it expires.
+const DefaultSecurityExpiry = 180 * 24 * time.Hour
+
+// SecurityTxt serves a security.txt, as defined by RFC 9116, telling security researchers how to report
+// vulnerabilities. Mount it at /.well-known/security.txt.
+type SecurityTxt struct {
+	// Contact are the addresses to report vulnerabilities to, as URLs such as "mailto:security@example.com" or
+	// "https://example.com/security". At least one is needed; without any, the security.txt isn't served.
+	Contact []string `json:"Contact" yaml:"Contact" toml:"Contact"`
+
+	// Expires is when the file should no longer be trusted. If zero, DefaultSecurityExpiry after each request.
+	Expires time.Time `json:"Expires" yaml:"Expires" toml:"Expires"`
+
+	// Encryption, Acknowledgments, Policy and Hiring are optional URLs of the key to encrypt reports with, of the
+	// list of researchers thanked, of the disclosure policy and of security jobs.
+	Encryption      string `json:"Encryption" yaml:"Encryption" toml:"Encryption"`
+	Acknowledgments string `json:"Acknowledgments" yaml:"Acknowledgments" toml:"Acknowledgments"`
+	Policy          string `json:"Policy" yaml:"Policy" toml:"Policy"`
+	Hiring          string `json:"Hiring" yaml:"Hiring" toml:"Hiring"`
+
+	// PreferredLanguages are the languages reports may be written in, e.g. "en, fr".
+	PreferredLanguages string `json:"PreferredLanguages" yaml:"PreferredLanguages" toml:"PreferredLanguages"`
+}
+
+func (s *SecurityTxt) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if len(s.Contact) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	expires := s.Expires
+	if expires.IsZero() {
+		expires = time.Now().Add(DefaultSecurityExpiry)
+	}
+
+	var b strings.Builder
+	for _, c := range s.Contact {
+		fmt.Fprintf(&b, "Contact: %s\n", c)
+	}
+	fmt.Fprintf(&b, "Expires: %s\n", expires.UTC().Format(time.RFC3339))
+	for _, f := range []struct{ name, value string }{
+		{"Encryption", s.Encryption},
+		{"Acknowledgments", s.Acknowledgments},
+		{"Policy", s.Policy},
+		{"Hiring", s.Hiring},
+		{"Preferred-Languages", s.PreferredLanguages},
+	} {
+		if f.value != "" {
+			fmt.Fprintf(&b, "%s: %s\n", f.name, f.value)
+		}
+	}
+	fmt.Fprintf(&b, "Canonical: %s\n", absoluteURL(r, r.URL.Path))
+	writeText(w, r, b.String())
+}
+
+// absoluteURL resolves ref, a path or URL, against the site r was sent to.
+func absoluteURL(r *http.Request, ref string) string {
+	u, err := url.Parse(ref)
+	if err != nil {
+		return ref
+	}
+	return (&url.URL{Scheme: scheme(r), Host: r.Host}).ResolveReference(u).String()
+}
+
+// writeText serves s as plain text, cacheable for a day.
+func writeText(w http.ResponseWriter, r *http.Request, s string) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", CachePolicy{MaxAge: 24 * time.Hour}.String())
+	writeCompressed(w, r, []byte(s))
+}
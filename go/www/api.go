@@ -0,0 +1,120 @@
+package www
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mconbere/quitlikeapro/go/templatehandler"
+	"github.com/mconbere/quitlikeapro/go/templatehandler/funcs"
+)
+
+// APIPrefix is where the versioned JSON API for the site's programs is served.
+const APIPrefix = "/api/v1/"
+
+// apiQuittable is a program as the API serves it.
+type apiQuittable struct {
+	Title string `json:"title"`
+	Slug  string `json:"slug"`
+	Path  string `json:"path"`
+
+	// Steps are HTML, as on the site, and StepsText the same steps as plain text.
+	Steps     []string `json:"steps"`
+	StepsText []string `json:"stepsText"`
+
+	Tags      []string   `json:"tags"`
+	Platforms []string   `json:"platforms"`
+	Added     *time.Time `json:"added,omitempty"`
+	Saved     int64      `json:"saved"`
+}
+
+func newAPIQuittable(q Quittable) apiQuittable {
+	a := apiQuittable{
+		Title:     funcs.PlainText(q.Title),
+		Slug:      q.Slug(),
+		Path:      q.Path(),
+		Steps:     []string{},
+		StepsText: []string{},
+		Tags:      append([]string{}, q.Tags...),
+		Platforms: append([]string{}, q.Platforms...),
+		Saved:     q.Saved,
+	}
+	for _, s := range q.Steps {
+		a.Steps = append(a.Steps, string(s))
+		a.StepsText = append(a.StepsText, funcs.PlainText(s))
+	}
+	if !q.Added.IsZero() {
+		a.Added = &q.Added
+	}
+	return a
+}
+
+// api returns the handler for APIPrefix, which serves the programs in base's current input:
+//
+//	GET  /api/v1/quittables                            every program, as {"quittables": [...]}
+//	GET  /api/v1/quittables?tag=editor&platform=linux  the programs with every tag and platform given
+//	GET  /api/v1/quittables/vim                        one program, or a 404
+//	POST /api/v1/quittables/vim/saved                  says Vim's steps saved you, when counts is set
+//
+// Each program's "saved" count is from counts, or zero if it's nil. Scripts on any site may read the programs, so that
+// browser extensions and bots can use the data, but only the site's own pages may save them. Responses are kept in
+// shared, if it isn't nil.
+func api(base *templatehandler.Base, counts *savedCounts, shared *templatehandler.VersionedStore) http.Handler {
+	cache := &templatehandler.CachePolicy{MaxAge: 5 * time.Minute}
+	if counts != nil {
+		cache = &savedCountsCache
+	}
+
+	list := templatehandler.JSONFunc(func(r *http.Request) (interface{}, error) {
+		q := r.URL.Query()
+		matches := []apiQuittable{}
+		for _, p := range withSaved(quittables(base), counts.get(r.Context())) {
+			if hasAll(p.Tags, q["tag"]) && hasAll(p.Platforms, q["platform"]) {
+				matches = append(matches, newAPIQuittable(p))
+			}
+		}
+		return map[string]interface{}{"quittables": matches}, nil
+	})
+	list.Cache = cache
+	list.OutputCache = outputCache(shared, counts, 5*time.Minute, "tag", "platform")
+
+	one := templatehandler.JSONFunc(func(r *http.Request) (interface{}, error) {
+		p, ok := findQuittable(base, templatehandler.PathParams(r)["slug"])
+		if !ok {
+			return nil, templatehandler.ErrNotFound
+		}
+		p.Saved = counts.get(r.Context())[p.Slug()]
+		return newAPIQuittable(p), nil
+	})
+	one.Cache = cache
+	one.OutputCache = outputCache(shared, counts, 5*time.Minute)
+
+	anyOrigin := templatehandler.CORS("*")
+	router := templatehandler.NewRouter()
+	router.NotFound = anyOrigin(templatehandler.JSONFunc(func(*http.Request) (interface{}, error) {
+		return nil, templatehandler.ErrNotFound
+	}))
+	router.Handle(APIPrefix+"quittables", anyOrigin(list))
+	router.Handle(APIPrefix+"quittables/{slug}", anyOrigin(one))
+	if counts != nil {
+		router.Handle(APIPrefix+"quittables/{slug}/saved", save(base, counts))
+	}
+	return router
+}
+
+// hasAll reports whether have includes every one of want, ignoring case.
+func hasAll(have, want []string) bool {
+	for _, w := range want {
+		found := false
+		for _, h := range have {
+			if strings.EqualFold(h, w) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
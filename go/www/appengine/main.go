@@ -1,12 +1,127 @@
+// Command appengine serves the site on App Engine's Go 1.21 runtime, which runs it as a server listening on $PORT.
 package main
 
 import (
+	"context"
+	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/mconbere/quitlikeapro/go/templatehandler"
+	"github.com/mconbere/quitlikeapro/go/templatehandler/gcs"
+	"github.com/mconbere/quitlikeapro/go/templatehandler/memcache"
 	"github.com/mconbere/quitlikeapro/go/www"
 )
 
-func init() {
-	root := www.New()
-	http.Handle("/", root)
+// countInterval is how often the saved and view counts are written to Firestore. Every instance writes its own, so
+// the writes a popular program's counters take grow with the instances rather than the visitors.
+const countInterval = 10 * time.Second
+
+// shutdownTimeout is how long in-flight requests and unwritten counts have after App Engine sends SIGTERM, which is
+// followed by SIGKILL a few seconds later.
+const shutdownTimeout = 2 * time.Second
+
+func main() {
+	env := templatehandler.DetectEnvironment()
+	c := www.Config{
+		Deadline: www.AppEngineDeadline,
+		// Outside App Engine, such as under go run, templates are re-read as they're edited.
+		LiveReload: env.Name == templatehandler.EnvDev,
+		// Set in the deployment's env_variables, or left unset to disable the /_admin/ endpoints.
+		ReloadToken: os.Getenv("RELOAD_TOKEN"),
+		Environment: env,
+		// App Engine removes X-Appengine-Cron from requests from outside, but there's no App Engine in development.
+		AppEngineCron: env.Name != templatehandler.EnvDev,
+		// Set to let Cloud Scheduler jobs run the tasks too, with an OIDC token for TASK_AUDIENCE from one of the
+		// comma separated service accounts in TASK_ACCOUNTS.
+		TaskAudience: os.Getenv("TASK_AUDIENCE"),
+	}
+	if accounts := os.Getenv("TASK_ACCOUNTS"); accounts != "" {
+		c.TaskAccounts = strings.Split(accounts, ",")
+	}
+	// Set IAP_AUDIENCE once Identity-Aware Proxy is turned on for the app, to let the users it admits, or only those
+	// of them in the comma separated IAP_USERS, use the /_admin/ endpoints without RELOAD_TOKEN.
+	c.IAPAudience = os.Getenv("IAP_AUDIENCE")
+	if users := os.Getenv("IAP_USERS"); users != "" {
+		c.IAPUsers = strings.Split(users, ",")
+	}
+	// Suggestions from /submit and the "this saved me" and view counts are kept in files in development. Instances in
+	// production don't share a disk, so they're kept in the project's Firestore database there, and an instance that
+	// can't find it doesn't start rather than serve a /submit that loses what's posted to it.
+	var counters []*www.BufferedCounter
+	if c.LiveReload {
+		c.Submissions = www.NewFileSubmissions(filepath.Join(os.TempDir(), "quitlikeapro-submissions.json"))
+		c.Saved = www.NewFileCounter(filepath.Join(os.TempDir(), "quitlikeapro-saved.json"))
+		c.Views = www.NewFileCounter(filepath.Join(os.TempDir(), "quitlikeapro-views.json"))
+	} else {
+		project := os.Getenv("GOOGLE_CLOUD_PROJECT")
+		if project == "" {
+			log.Fatal("GOOGLE_CLOUD_PROJECT isn't set, so there's no Firestore database to keep submissions in")
+		}
+		db := www.NewFirestore(project)
+		c.Submissions = www.NewFirestoreSubmissions(db)
+		saved := www.NewBufferedCounter(www.NewFirestoreCounter(db), countInterval)
+		views := www.NewBufferedCounter(www.NewFirestoreViewCounter(db), countInterval)
+		c.Saved, c.Views = saved, views
+		counters = append(counters, saved, views)
+		// App Engine picks up JSON lines on stdout as structured logs, and shows each under the request it's about.
+		c.AccessLog = templatehandler.CloudAccessLog(os.Stdout, project)
+	}
+	// Set FASTLY_SERVICE_ID and FASTLY_API_TOKEN if the app is behind Fastly, to have it keep pages for longer and purge
+	// them whenever the data changes.
+	if service := os.Getenv("FASTLY_SERVICE_ID"); service != "" {
+		c.CDNPurger = &templatehandler.FastlyPurger{ServiceID: service, Token: os.Getenv("FASTLY_API_TOKEN")}
+	}
+	// Set MEMCACHE_ADDR to a Memorystore for Memcached node's host:port, reached through the app's VPC connector, to
+	// share rendered pages between instances.
+	if addr := os.Getenv("MEMCACHE_ADDR"); addr != "" {
+		c.Cache = memcache.New(addr)
+	}
+	// Set CONTENT_BUCKET, such as to gs://quitlikeapro-content/, to serve the templates and data uploaded there rather
+	// than those deployed with the app. Uploads go live on the next POST /_admin/reload.
+	if u := os.Getenv("CONTENT_BUCKET"); u != "" {
+		bucket, err := gcs.NewURL(u)
+		if err != nil {
+			log.Fatal(err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		err = bucket.Refresh(ctx)
+		cancel()
+		if err != nil {
+			log.Fatal(err)
+		}
+		c.Content = bucket
+	}
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8080"
+	}
+	srv := &http.Server{Addr: ":" + port, Handler: www.New(c)}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGTERM, os.Interrupt)
+		<-sig
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Printf("could not drain connections: %v", err)
+		}
+		for _, c := range counters {
+			if err := c.Flush(ctx); err != nil {
+				log.Printf("could not write counts: %v", err)
+			}
+		}
+	}()
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	<-done
 }
@@ -0,0 +1,29 @@
+// Posts a program's "this saved me" button without leaving the page, and shows the new count. Without scripts the
+// form posts as usual and comes back to the program's page.
+document.addEventListener("submit", function (e) {
+  var form = e.target;
+  if (!form.classList.contains("saved")) {
+    return;
+  }
+  e.preventDefault();
+  var button = form.querySelector("button");
+  var count = form.querySelector(".saved-count");
+  button.disabled = true;
+  fetch(form.action, {method: "POST", headers: {"Accept": "application/json"}})
+    .then(function (resp) {
+      if (resp.status === 429) {
+        button.textContent = "Already counted, thanks!";
+        return;
+      }
+      if (!resp.ok) {
+        throw new Error(resp.statusText);
+      }
+      return resp.json().then(function (body) {
+        button.textContent = "Thanks!";
+        count.textContent = body.saved === 1 ? "Saved 1 person" : "Saved " + body.saved + " people";
+      });
+    })
+    .catch(function () {
+      button.disabled = false;
+    });
+});
@@ -0,0 +1,40 @@
+package www
+
+import (
+	"context"
+	"log"
+	"net/http"
+
+	"github.com/mconbere/quitlikeapro/go/templatehandler"
+)
+
+// eventsPath is where Pub/Sub push subscriptions post the events that change the site's data.
+const eventsPath = "/events/pubsub"
+
+// The values of a message's "event" attribute that make the site re-read its data, for publishers other than Cloud
+// Storage, such as a script that updates the data elsewhere or a moderation tool.
+const (
+	EventDatasetUpdated     = "dataset.updated"
+	EventSubmissionApproved = "submission.approved"
+)
+
+// events returns the handler for eventsPath. A Cloud Storage notification of an object being written or deleted, such
+// as in the content bucket, or a message whose "event" attribute is EventDatasetUpdated or EventSubmissionApproved,
+// runs the reload task, which re-reads the templates and data, drops the shared cache and builds the search index and
+// sitemap again. Pub/Sub delivers each message to one instance, so only it reloads; the others keep their data until
+// they're reloaded or restarted. Other messages are acknowledged and ignored.
+func events(jobs *templatehandler.Tasks) http.Handler {
+	return templatehandler.PubSub(func(ctx context.Context, m templatehandler.PubSubMessage) error {
+		switch m.Attributes["event"] {
+		case EventDatasetUpdated, EventSubmissionApproved:
+		default:
+			switch m.Attributes["eventType"] {
+			case "OBJECT_FINALIZE", "OBJECT_DELETE":
+			default:
+				return nil
+			}
+		}
+		log.Printf("www: reloading for event id=%s attributes=%v", m.ID, m.Attributes)
+		return jobs.Run(ctx, "reload")
+	})
+}
@@ -0,0 +1,516 @@
+package www
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultFirestoreEndpoint is the address of Firestore's REST API.
+const DefaultFirestoreEndpoint = "https://firestore.googleapis.com/v1/"
+
+// metadataTokenURL is where the metadata server hands out access tokens for the service account App Engine runs as.
+const metadataTokenURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+
+// Firestore is a project's Firestore database, which must be in Native mode, for FirestoreCounter and
+// FirestoreSubmissions to keep their data in, so that every instance of the site shares it.
+//
+// It speaks Firestore's REST API, and only the calls those need.
+type Firestore struct {
+	// Project is the Google Cloud project ID, which App Engine sets as $GOOGLE_CLOUD_PROJECT.
+	Project string
+
+	// Endpoint is the API's address, such as http://localhost:8200/v1/ for the emulator. If empty,
+	// DefaultFirestoreEndpoint.
+	Endpoint string
+
+	// Token returns the OAuth2 access token to call the API with. If nil, calls aren't authenticated, as the emulator
+	// expects.
+	Token func(ctx context.Context) (string, error)
+
+	// Client makes the calls. If nil, one that gives up on a call after firestoreTimeout.
+	Client *http.Client
+}
+
+// firestoreTimeout bounds each call Firestore makes with its default client, so that a call for a request with no
+// deadline of its own can't hold it up for long.
+const firestoreTimeout = 10 * time.Second
+
+// firestoreClient makes Firestore's calls when it has no Client.
+var firestoreClient = &http.Client{Timeout: firestoreTimeout}
+
+// NewFirestore returns project's Firestore database, authenticated as the service account App Engine runs as.
+func NewFirestore(project string) *Firestore {
+	return &Firestore{Project: project, Token: (&metadataToken{}).get}
+}
+
+// errFirestoreNotFound is returned by call for a document that isn't there.
+var errFirestoreNotFound = errors.New("firestore: not found")
+
+// database returns the resource name of the project's database.
+func (f *Firestore) database() string {
+	return "projects/" + f.Project + "/databases/(default)"
+}
+
+// document returns the resource name of the document id in collection.
+func (f *Firestore) document(collection, id string) string {
+	return f.database() + "/documents/" + collection + "/" + id
+}
+
+// call makes a request to the API for the resource at name, with body as JSON if it isn't nil, and decodes the
+// response into v.
+func (f *Firestore) call(ctx context.Context, method, name string, body, v interface{}) error {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(b)
+	}
+	endpoint := f.Endpoint
+	if endpoint == "" {
+		endpoint = DefaultFirestoreEndpoint
+	}
+	req, err := http.NewRequestWithContext(ctx, method, endpoint+name, r)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if f.Token != nil {
+		token, err := f.Token(ctx)
+		if err != nil {
+			return fmt.Errorf("firestore: could not get an access token: %v", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	client := f.Client
+	if client == nil {
+		client = firestoreClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("firestore: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return errFirestoreNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&e)
+		return fmt.Errorf("firestore: %s %s: %s: %s", method, name, resp.Status, e.Error.Message)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("firestore: could not parse the response to %s %s: %v", method, name, err)
+	}
+	return nil
+}
+
+// firestoreDocument is a document as the API sends and takes it.
+type firestoreDocument struct {
+	Name   string                    `json:"name,omitempty"`
+	Fields map[string]firestoreValue `json:"fields,omitempty"`
+}
+
+// list returns every document in collection, with only the given fields if there are any.
+func (f *Firestore) list(ctx context.Context, collection string, fields ...string) ([]firestoreDocument, error) {
+	var docs []firestoreDocument
+	q := url.Values{"pageSize": {"300"}, "mask.fieldPaths": fields}
+	for {
+		var resp struct {
+			Documents     []firestoreDocument `json:"documents"`
+			NextPageToken string              `json:"nextPageToken"`
+		}
+		err := f.call(ctx, http.MethodGet, f.database()+"/documents/"+collection+"?"+q.Encode(), nil, &resp)
+		if err == errFirestoreNotFound {
+			// A collection with no documents doesn't exist.
+			return docs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, resp.Documents...)
+		if resp.NextPageToken == "" {
+			return docs, nil
+		}
+		q.Set("pageToken", resp.NextPageToken)
+	}
+}
+
+// firestoreValue is a field value as the API sends and takes it. Only the kinds of value the site stores are here,
+// and exactly one is set.
+type firestoreValue struct {
+	StringValue    *string         `json:"stringValue,omitempty"`
+	IntegerValue   string          `json:"integerValue,omitempty"`
+	TimestampValue string          `json:"timestampValue,omitempty"`
+	ArrayValue     *firestoreArray `json:"arrayValue,omitempty"`
+}
+
+type firestoreArray struct {
+	Values []firestoreValue `json:"values,omitempty"`
+}
+
+func stringValue(s string) firestoreValue {
+	return firestoreValue{StringValue: &s}
+}
+
+func stringsValue(s []string) firestoreValue {
+	a := &firestoreArray{}
+	for _, v := range s {
+		a.Values = append(a.Values, stringValue(v))
+	}
+	return firestoreValue{ArrayValue: a}
+}
+
+// timestampValue stores t to the microsecond, which is as precise as Firestore's timestamps are.
+func timestampValue(t time.Time) firestoreValue {
+	return firestoreValue{TimestampValue: t.UTC().Format("2006-01-02T15:04:05.000000Z")}
+}
+
+func (v firestoreValue) string() string {
+	if v.StringValue == nil {
+		return ""
+	}
+	return *v.StringValue
+}
+
+func (v firestoreValue) strings() []string {
+	if v.ArrayValue == nil {
+		return nil
+	}
+	var s []string
+	for _, e := range v.ArrayValue.Values {
+		s = append(s, e.string())
+	}
+	return s
+}
+
+// time returns the timestamp, or the zero time if v isn't one.
+func (v firestoreValue) time() time.Time {
+	t, _ := time.Parse(time.RFC3339Nano, v.TimestampValue)
+	return t
+}
+
+// FirestoreCounter is a SavedCounter in Collection of a Firestore database, with each count in a Field of its
+// documents, so that saves from every instance are counted atomically. A document takes only about one write a second,
+// so each program's count is spread over Shards documents, named by its slug and, past the first, "~" and the shard's
+// number, and each write goes to one of them at random. Counts adds them up.
+type FirestoreCounter struct {
+	DB         *Firestore
+	Collection string
+	Field      string
+
+	// Shards is how many documents each count is spread over. If zero, one.
+	Shards int
+}
+
+var _ BatchCounter = (*FirestoreCounter)(nil)
+
+// counterShards is how many documents NewFirestoreCounter and NewFirestoreViewCounter spread each count over, enough
+// for a few writes a second to any one program.
+const counterShards = 8
+
+// maxFirestoreWrites is how many writes Firestore takes in one commit.
+const maxFirestoreWrites = 500
+
+// NewFirestoreCounter returns a SavedCounter keeping its counts in the "saved" fields of db's "saved" collection.
+func NewFirestoreCounter(db *Firestore) *FirestoreCounter {
+	return &FirestoreCounter{DB: db, Collection: "saved", Field: "saved", Shards: counterShards}
+}
+
+// NewFirestoreViewCounter returns a SavedCounter for Config.Views, keeping its counts in the "views" fields of db's
+// "views" collection.
+func NewFirestoreViewCounter(db *Firestore) *FirestoreCounter {
+	return &FirestoreCounter{DB: db, Collection: "views", Field: "views", Shards: counterShards}
+}
+
+// shard returns the resource name of a document, chosen at random, that slug's count is spread over.
+func (f *FirestoreCounter) shard(slug string) string {
+	id := slug
+	if f.Shards > 1 {
+		if n := rand.Intn(f.Shards); n > 0 {
+			id += "~" + strconv.Itoa(n)
+		}
+	}
+	return f.DB.document(f.Collection, id)
+}
+
+// increment is a write adding n to the count in the document name, creating it if it isn't there.
+func (f *FirestoreCounter) increment(name string, n int64) interface{} {
+	// An update with an empty mask and a transform increments the field.
+	return map[string]interface{}{
+		"update":     firestoreDocument{Name: name},
+		"updateMask": map[string]interface{}{},
+		"updateTransforms": []interface{}{map[string]interface{}{
+			"fieldPath": f.Field,
+			"increment": firestoreValue{IntegerValue: strconv.FormatInt(n, 10)},
+		}},
+	}
+}
+
+func (f *FirestoreCounter) Save(ctx context.Context, slug string) (int64, error) {
+	body := map[string]interface{}{"writes": []interface{}{f.increment(f.shard(slug), 1)}}
+	var resp struct {
+		WriteResults []struct {
+			TransformResults []firestoreValue `json:"transformResults"`
+		} `json:"writeResults"`
+	}
+	if err := f.DB.call(ctx, http.MethodPost, f.DB.database()+"/documents:commit", body, &resp); err != nil {
+		return 0, err
+	}
+	if len(resp.WriteResults) != 1 || len(resp.WriteResults[0].TransformResults) != 1 {
+		return 0, fmt.Errorf("firestore: commit returned %d write results, not one with one transform result",
+			len(resp.WriteResults))
+	}
+	n, err := strconv.ParseInt(resp.WriteResults[0].TransformResults[0].IntegerValue, 10, 64)
+	if err != nil || f.Shards <= 1 {
+		return n, err
+	}
+	return f.total(ctx, slug)
+}
+
+// total returns slug's count, added up from each of its shards.
+func (f *FirestoreCounter) total(ctx context.Context, slug string) (int64, error) {
+	names := []string{f.DB.document(f.Collection, slug)}
+	for i := 1; i < f.Shards; i++ {
+		names = append(names, f.DB.document(f.Collection, slug+"~"+strconv.Itoa(i)))
+	}
+	body := map[string]interface{}{"documents": names, "mask": map[string]interface{}{"fieldPaths": []string{f.Field}}}
+	var resp []struct {
+		Found *firestoreDocument `json:"found"`
+	}
+	if err := f.DB.call(ctx, http.MethodPost, f.DB.database()+"/documents:batchGet", body, &resp); err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, r := range resp {
+		if r.Found == nil {
+			continue
+		}
+		n, err := strconv.ParseInt(r.Found.Fields[f.Field].IntegerValue, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("firestore: %s has an invalid count: %v", r.Found.Name, err)
+		}
+		total += n
+	}
+	return total, nil
+}
+
+// Add adds each of counts to the count for its slug, in as few commits as Firestore allows.
+func (f *FirestoreCounter) Add(ctx context.Context, counts map[string]int64) error {
+	var writes []interface{}
+	commit := func() error {
+		if len(writes) == 0 {
+			return nil
+		}
+		var resp struct{}
+		err := f.DB.call(ctx, http.MethodPost, f.DB.database()+"/documents:commit", map[string]interface{}{"writes": writes}, &resp)
+		writes = nil
+		return err
+	}
+	for slug, n := range counts {
+		writes = append(writes, f.increment(f.shard(slug), n))
+		if len(writes) == maxFirestoreWrites {
+			if err := commit(); err != nil {
+				return err
+			}
+		}
+	}
+	return commit()
+}
+
+func (f *FirestoreCounter) Counts(ctx context.Context) (map[string]int64, error) {
+	docs, err := f.DB.list(ctx, f.Collection, f.Field)
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int64)
+	for _, d := range docs {
+		n, err := strconv.ParseInt(d.Fields[f.Field].IntegerValue, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("firestore: %s has an invalid count: %v", d.Name, err)
+		}
+		slug, _, _ := strings.Cut(path.Base(d.Name), "~")
+		counts[slug] += n
+	}
+	return counts, nil
+}
+
+// FirestoreSubmissions is a SubmissionStore in Collection of a Firestore database, with a document for each
+// submission named by its ID.
+type FirestoreSubmissions struct {
+	DB         *Firestore
+	Collection string
+}
+
+var _ SubmissionStore = (*FirestoreSubmissions)(nil)
+
+// NewFirestoreSubmissions returns a SubmissionStore keeping submissions in db's "submissions" collection.
+func NewFirestoreSubmissions(db *Firestore) *FirestoreSubmissions {
+	return &FirestoreSubmissions{DB: db, Collection: "submissions"}
+}
+
+func (f *FirestoreSubmissions) Add(ctx context.Context, s Submission) error {
+	doc := firestoreDocument{Fields: map[string]firestoreValue{
+		"title":     stringValue(s.Title),
+		"steps":     stringsValue(s.Steps),
+		"status":    stringValue(s.Status),
+		"submitted": timestampValue(s.Submitted),
+	}}
+	q := url.Values{"documentId": {s.ID}}
+	var created firestoreDocument
+	return f.DB.call(ctx, http.MethodPost, f.DB.database()+"/documents/"+f.Collection+"?"+q.Encode(), doc, &created)
+}
+
+func (f *FirestoreSubmissions) List(ctx context.Context) ([]Submission, error) {
+	docs, err := f.DB.list(ctx, f.Collection)
+	if err != nil {
+		return nil, err
+	}
+	all := make([]Submission, len(docs))
+	for i, d := range docs {
+		all[i] = submissionFromDocument(d)
+	}
+	sort.SliceStable(all, func(i, j int) bool { return all[i].Submitted.Before(all[j].Submitted) })
+	return all, nil
+}
+
+func (f *FirestoreSubmissions) Review(ctx context.Context, id, status string) (Submission, error) {
+	doc := firestoreDocument{Fields: map[string]firestoreValue{
+		"status":   stringValue(status),
+		"reviewed": timestampValue(time.Now()),
+	}}
+	// Only those fields are changed, and only if the submission is there.
+	q := url.Values{"updateMask.fieldPaths": {"status", "reviewed"}, "currentDocument.exists": {"true"}}
+	var updated firestoreDocument
+	err := f.DB.call(ctx, http.MethodPatch, f.DB.document(f.Collection, id)+"?"+q.Encode(), doc, &updated)
+	if err == errFirestoreNotFound {
+		return Submission{}, ErrNoSubmission
+	}
+	if err != nil {
+		return Submission{}, err
+	}
+	return submissionFromDocument(updated), nil
+}
+
+func submissionFromDocument(d firestoreDocument) Submission {
+	return Submission{
+		ID:        path.Base(d.Name),
+		Title:     d.Fields["title"].string(),
+		Steps:     d.Fields["steps"].strings(),
+		Status:    d.Fields["status"].string(),
+		Submitted: d.Fields["submitted"].time(),
+		Reviewed:  d.Fields["reviewed"].time(),
+	}
+}
+
+// metadataTokenTimeout is how long a metadataToken waits for the metadata server, which is on the instance's own
+// network and so answers quickly when it answers at all.
+const metadataTokenTimeout = 5 * time.Second
+
+// metadataToken gets access tokens from the metadata server, keeping each until shortly before it expires. Once a
+// token is close to expiring a new one is fetched in the background, and the old one returned meanwhile; only calls
+// with no token left to use wait for the fetch.
+type metadataToken struct {
+	mu      sync.Mutex
+	token   string
+	refresh time.Time
+	expires time.Time
+	err     error
+	// fetching is closed when the fetch in flight finishes, and is nil when there's none.
+	fetching chan struct{}
+}
+
+func (m *metadataToken) get(ctx context.Context) (string, error) {
+	m.mu.Lock()
+	now := time.Now()
+	usable := m.token != "" && now.Before(m.expires)
+	if usable && now.Before(m.refresh) {
+		defer m.mu.Unlock()
+		return m.token, nil
+	}
+	if m.fetching == nil {
+		m.fetching = make(chan struct{})
+		go m.fetch(m.fetching)
+	}
+	token, fetching := m.token, m.fetching
+	m.mu.Unlock()
+	if usable {
+		return token, nil
+	}
+
+	select {
+	case <-fetching:
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.token == "" || !time.Now().Before(m.expires) {
+		return "", m.err
+	}
+	return m.token, nil
+}
+
+// fetch fetches a token from the metadata server, and closes done once it's kept. If it fails, the last token is
+// kept for as long as it can still be used.
+func (m *metadataToken) fetch(done chan struct{}) {
+	ctx, cancel := context.WithTimeout(context.Background(), metadataTokenTimeout)
+	defer cancel()
+	token, ttl, err := fetchMetadataToken(ctx)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	if err == nil {
+		// Leave a minute for the calls made with it, and start fetching the next one five minutes before that.
+		m.token, m.expires, m.refresh = token, now.Add(ttl-time.Minute), now.Add(ttl-6*time.Minute)
+	} else {
+		// Try again no more than once a minute while the last token lasts.
+		m.refresh = now.Add(time.Minute)
+	}
+	m.err, m.fetching = err, nil
+	close(done)
+}
+
+// fetchMetadataToken gets an access token from the metadata server, and how long it can be used for.
+func fetchMetadataToken(ctx context.Context) (string, time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, metadataTokenURL, nil)
+	if err != nil {
+		return "", 0, err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", 0, fmt.Errorf("metadata server: %s", resp.Status)
+	}
+	var t struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&t); err != nil {
+		return "", 0, fmt.Errorf("metadata server: %v", err)
+	}
+	return t.AccessToken, time.Duration(t.ExpiresIn) * time.Second, nil
+}
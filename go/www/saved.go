@@ -0,0 +1,378 @@
+package www
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mconbere/quitlikeapro/go/templatehandler"
+	"github.com/mconbere/quitlikeapro/go/templatehandler/csrf"
+)
+
+// SavedCounter counts, by slug, how many visitors have said a program's steps got them out of it. FileCounter keeps
+// the counts in a file, which suits a single server; on App Engine, whose instances don't share a disk, use a
+// FirestoreCounter instead.
+type SavedCounter interface {
+	// Save adds one to the count for slug, and returns the new count.
+	Save(ctx context.Context, slug string) (int64, error)
+
+	// Counts returns the count for every slug that has one.
+	Counts(ctx context.Context) (map[string]int64, error)
+}
+
+// BatchCounter is a SavedCounter that can also add many saves at once, which a BufferedCounter writes with.
+type BatchCounter interface {
+	SavedCounter
+
+	// Add adds each of counts to the count for its slug.
+	Add(ctx context.Context, counts map[string]int64) error
+}
+
+// FileCounter keeps the counts as a JSON object in the file at Path.
+type FileCounter struct {
+	Path string
+
+	mu sync.Mutex
+}
+
+var _ BatchCounter = (*FileCounter)(nil)
+
+// NewFileCounter returns a SavedCounter keeping its counts in the file at path, which is created on the first Save.
+func NewFileCounter(path string) *FileCounter {
+	return &FileCounter{Path: path}
+}
+
+func (f *FileCounter) Save(ctx context.Context, slug string) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	counts := make(map[string]int64)
+	if err := readJSONFile(f.Path, &counts); err != nil {
+		return 0, err
+	}
+	counts[slug]++
+	return counts[slug], writeJSONFile(f.Path, counts)
+}
+
+func (f *FileCounter) Add(ctx context.Context, add map[string]int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	counts := make(map[string]int64)
+	if err := readJSONFile(f.Path, &counts); err != nil {
+		return err
+	}
+	for slug, n := range add {
+		counts[slug] += n
+	}
+	return writeJSONFile(f.Path, counts)
+}
+
+func (f *FileCounter) Counts(ctx context.Context) (map[string]int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	counts := make(map[string]int64)
+	return counts, readJSONFile(f.Path, &counts)
+}
+
+// BufferedCounter is a SavedCounter that adds saves up in memory and writes them to Counter in the background, every
+// Interval, in one Add for all the programs saved since the last, so that requests don't wait on the write and a
+// popular program's count isn't written for every visitor. Counts are the Counter's plus the saves not yet written, and
+// Save returns the count as of the last Counts plus those, so both are only as exact as the Counter's last Counts.
+//
+// Saves not yet written when the process exits are lost, unless it calls Flush first.
+type BufferedCounter struct {
+	Counter  BatchCounter
+	Interval time.Duration
+
+	mu      sync.Mutex
+	counts  map[string]int64
+	pending map[string]int64
+	// flushing is whether a goroutine is writing pending every Interval. It stops once there's nothing to write.
+	flushing bool
+}
+
+var _ SavedCounter = (*BufferedCounter)(nil)
+
+// bufferedCounterWrite is how long a BufferedCounter's background writes may take.
+const bufferedCounterWrite = 10 * time.Second
+
+// NewBufferedCounter returns a SavedCounter that writes saves to c every interval.
+func NewBufferedCounter(c BatchCounter, interval time.Duration) *BufferedCounter {
+	return &BufferedCounter{Counter: c, Interval: interval}
+}
+
+func (b *BufferedCounter) Save(ctx context.Context, slug string) (int64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.pending == nil {
+		b.pending = make(map[string]int64)
+	}
+	b.pending[slug]++
+	if !b.flushing {
+		b.flushing = true
+		go b.flushEvery()
+	}
+	return b.counts[slug] + b.pending[slug], nil
+}
+
+func (b *BufferedCounter) Counts(ctx context.Context) (map[string]int64, error) {
+	counts, err := b.Counter.Counts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.counts = counts
+	out := make(map[string]int64, len(counts))
+	for slug, n := range counts {
+		out[slug] = n
+	}
+	for slug, n := range b.pending {
+		out[slug] += n
+	}
+	return out, nil
+}
+
+// Flush writes the saves not yet written now.
+func (b *BufferedCounter) Flush(ctx context.Context) error {
+	b.mu.Lock()
+	pending := b.pending
+	b.pending = nil
+	b.mu.Unlock()
+	if len(pending) == 0 {
+		return nil
+	}
+
+	if err := b.Counter.Add(ctx, pending); err != nil {
+		// Keep them for the next write.
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if b.pending == nil {
+			b.pending = make(map[string]int64)
+		}
+		for slug, n := range pending {
+			b.pending[slug] += n
+		}
+		return err
+	}
+	// Count them in the counts Save returns until the next Counts fetches them.
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	counts := make(map[string]int64, len(b.counts)+len(pending))
+	for slug, n := range b.counts {
+		counts[slug] = n
+	}
+	for slug, n := range pending {
+		counts[slug] += n
+	}
+	b.counts = counts
+	return nil
+}
+
+// flushEvery writes the pending saves every Interval, until there are none left to write.
+func (b *BufferedCounter) flushEvery() {
+	for {
+		time.Sleep(b.Interval)
+		ctx, cancel := context.WithTimeout(context.Background(), bufferedCounterWrite)
+		if err := b.Flush(ctx); err != nil {
+			log.Printf("www: could not write saves: %v", err)
+		}
+		cancel()
+
+		b.mu.Lock()
+		if len(b.pending) == 0 {
+			b.flushing = false
+			b.mu.Unlock()
+			return
+		}
+		b.mu.Unlock()
+	}
+}
+
+// savedCountsTTL is how long an instance shows the counts it has fetched before fetching them again, and
+// savedCountsTimeout how long it waits for them.
+const (
+	savedCountsTTL     = time.Minute
+	savedCountsTimeout = 5 * time.Second
+)
+
+// savedCountsCache is the cache policy for pages showing saved counts, so that browsers don't show counts older than
+// an instance would.
+var savedCountsCache = templatehandler.CachePolicy{MaxAge: savedCountsTTL}
+
+// savedCounts keeps the counts from a SavedCounter for savedCountsTTL, so that pages showing them don't each fetch
+// them. A nil *savedCounts has no counts.
+type savedCounts struct {
+	counter SavedCounter
+
+	mu      sync.Mutex
+	counts  map[string]int64
+	fetched time.Time
+	// fetching is closed when the fetch in flight finishes, and is nil when there's none.
+	fetching chan struct{}
+}
+
+// get returns the counts. Once they're older than savedCountsTTL they're fetched again in the background, and the old
+// ones returned meanwhile; only the first requests, before there are any, wait for them, for as long as ctx allows. If
+// they can't be fetched, the last ones are kept for another savedCountsTTL, so that a failing counter is tried no more
+// than once a minute. The map mustn't be changed.
+func (s *savedCounts) get(ctx context.Context) map[string]int64 {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	if s.counts != nil && time.Since(s.fetched) < savedCountsTTL {
+		defer s.mu.Unlock()
+		return s.counts
+	}
+	if s.fetching == nil {
+		s.fetching = make(chan struct{})
+		go s.fetch(s.fetching)
+	}
+	counts, fetching := s.counts, s.fetching
+	s.mu.Unlock()
+	if counts != nil {
+		return counts
+	}
+
+	select {
+	case <-fetching:
+	case <-ctx.Done():
+		return map[string]int64{}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.counts
+}
+
+// fetch fetches the counts from the counter, and closes done once they're kept.
+func (s *savedCounts) fetch(done chan struct{}) {
+	ctx, cancel := context.WithTimeout(context.Background(), savedCountsTimeout)
+	defer cancel()
+	counts, err := s.counter.Counts(ctx)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		log.Printf("www: could not get saved counts: %v", err)
+		if counts = s.counts; counts == nil {
+			counts = make(map[string]int64)
+		}
+	}
+	s.counts, s.fetched, s.fetching = counts, time.Now(), nil
+	close(done)
+}
+
+// set records a count Save returned, so that the visitor who saved the program sees it straight away.
+func (s *savedCounts) set(slug string, n int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.counts == nil {
+		return
+	}
+	// get has handed out the current map, so replace it rather than change it.
+	counts := make(map[string]int64, len(s.counts)+1)
+	for k, v := range s.counts {
+		counts[k] = v
+	}
+	counts[slug] = n
+	s.counts = counts
+}
+
+// withSaved returns a copy of q with each program's Saved count from counts.
+func withSaved(q []Quittable, counts map[string]int64) []Quittable {
+	out := make([]Quittable, len(q))
+	for i, p := range q {
+		p.Saved = counts[p.Slug()]
+		out[i] = p
+	}
+	return out
+}
+
+// popular returns a copy of q with each program's Saved count from counts, the most saved first. Programs saved as
+// often as each other stay in the order of q.
+func popular(q []Quittable, counts map[string]int64) []Quittable {
+	out := withSaved(q, counts)
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Saved > out[j].Saved })
+	return out
+}
+
+// savePath returns the path to POST to to say that q's steps saved you.
+func savePath(q Quittable) string {
+	return APIPrefix + "quittables/" + q.Slug() + "/saved"
+}
+
+// The allowance of saves each client has: any one program once a day, and no more than savesPerHour programs an hour.
+const (
+	savesPerHour   = 20
+	saveEachPerDay = 1
+)
+
+// postingClient keys a templatehandler.RateLimit by the ClientIP of POST requests, so that only posts count towards a
+// client's allowance and other methods still get their 405.
+func postingClient(r *http.Request) string {
+	if r.Method != http.MethodPost {
+		return ""
+	}
+	return templatehandler.ClientIP(r)
+}
+
+// save returns the handler for POST /api/v1/quittables/{slug}/saved, which adds one to the count of visitors the
+// program's steps have saved and responds with the new count, as {"saved": 12}. A form posted from a page without
+// scripts, which asks for HTML, is sent back to the program's page instead, at a URL of its own so that the browser
+// doesn't show a copy cached from before the save. Clients saving more often than their
+// allowance get a 429, and browsers posting from another site's page a 403.
+func save(base *templatehandler.Base, counts *savedCounts) http.Handler {
+	add := func(r *http.Request) (Quittable, int64, error) {
+		q, ok := findQuittable(base, templatehandler.PathParams(r)["slug"])
+		if !ok {
+			return Quittable{}, 0, templatehandler.ErrNotFound
+		}
+		n, err := counts.counter.Save(r.Context(), q.Slug())
+		if err != nil {
+			return Quittable{}, 0, err
+		}
+		counts.set(q.Slug(), n)
+		return q, n, nil
+	}
+
+	saved := templatehandler.JSONFunc(func(r *http.Request) (interface{}, error) {
+		_, n, err := add(r)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]int64{"saved": n}, nil
+	})
+	saved.Methods = []string{http.MethodPost}
+	saved.Cache = &templatehandler.CachePolicy{NoStore: true}
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || !strings.Contains(r.Header.Get("Accept"), "text/html") {
+			saved.ServeHTTP(w, r)
+			return
+		}
+		q, _, err := add(r)
+		if err == templatehandler.ErrNotFound {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			templatehandler.DefaultErrorHandler(w, r, err)
+			return
+		}
+		http.Redirect(w, r, q.Path()+"?saved", http.StatusSeeOther)
+	})
+	return templatehandler.Chain(h,
+		csrf.SameOrigin,
+		templatehandler.RateLimit(savesPerHour, time.Hour, postingClient),
+		templatehandler.RateLimit(saveEachPerDay, 24*time.Hour, func(r *http.Request) string {
+			if c := postingClient(r); c != "" {
+				return c + " " + templatehandler.PathParams(r)["slug"]
+			}
+			return ""
+		}),
+	)
+}
@@ -0,0 +1,168 @@
+package www
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/mconbere/quitlikeapro/go/templatehandler"
+	"github.com/mconbere/quitlikeapro/go/templatehandler/funcs"
+)
+
+// searchStopWords are the words of a question like "how do I exit vim" that say nothing about which program it's
+// about.
+var searchStopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "can": true, "close": true, "do": true, "does": true, "exit": true,
+	"from": true, "get": true, "how": true, "i": true, "in": true, "is": true, "it": true, "leave": true, "me": true,
+	"my": true, "of": true, "out": true, "quit": true, "quitting": true, "stop": true, "the": true, "to": true,
+	"you": true,
+}
+
+// searchIndex finds programs by the words of their titles, aliases and tags, forgiving small typos.
+type searchIndex struct {
+	quittables []Quittable
+
+	// terms are the words each program is found by, in the same order as quittables. Each name is a term with its
+	// words run together too, so that "python interpreter" finds it as a whole as well as by its words.
+	terms [][]string
+}
+
+func newSearchIndex(q []Quittable) *searchIndex {
+	idx := &searchIndex{quittables: q, terms: make([][]string, len(q))}
+	for i, p := range q {
+		names := append([]string{funcs.PlainText(p.Title)}, p.Aliases...)
+		var terms []string
+		for _, n := range append(names, p.Tags...) {
+			slug := funcs.Slugify(n)
+			terms = append(terms, strings.ReplaceAll(slug, "-", ""))
+			terms = append(terms, strings.Split(slug, "-")...)
+		}
+		idx.terms[i] = terms
+	}
+	return idx
+}
+
+// search returns the programs matching query, best first. A program matches if it's found by any word of the query
+// other than searchStopWords; the more words find it, and the more closely, the better it ranks.
+func (idx *searchIndex) search(query string) []Quittable {
+	var words []string
+	for _, w := range strings.Split(funcs.Slugify(query), "-") {
+		if w != "" && !searchStopWords[w] {
+			words = append(words, w)
+		}
+	}
+	if len(words) == 0 {
+		return nil
+	}
+	if len(words) > 1 {
+		// The whole query run together, so that "ctrl-c" is found by "ctrl c" as well as "ctrlc".
+		words = append(words, strings.Join(words, ""))
+	}
+
+	type match struct {
+		q     Quittable
+		score int
+	}
+	var matches []match
+	for i, terms := range idx.terms {
+		score := 0
+		for _, w := range words {
+			best := 0
+			for _, t := range terms {
+				if s := termScore(w, t); s > best {
+					best = s
+				}
+			}
+			score += best
+		}
+		if score > 0 {
+			matches = append(matches, match{idx.quittables[i], score})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].score > matches[j].score })
+	results := make([]Quittable, len(matches))
+	for i, m := range matches {
+		results[i] = m.q
+	}
+	return results
+}
+
+// termScore says how well the query word w matches the term t: 3 if they're the same, 2 if one starts with the other,
+// such as "pyth" and "python", 1 if w is a typo of t, and 0 otherwise. Short words must match exactly or as a prefix
+// of at least two letters, since a single typo makes most short words into others.
+func termScore(w, t string) int {
+	switch {
+	case w == t:
+		return 3
+	case len(w) >= 2 && strings.HasPrefix(t, w), len(t) >= 3 && strings.HasPrefix(w, t):
+		return 2
+	}
+	allowed := 0
+	switch {
+	case len(w) >= 8:
+		allowed = 2
+	case len(w) >= 4:
+		allowed = 1
+	}
+	if allowed > 0 && editDistance(w, t) <= allowed {
+		return 1
+	}
+	return 0
+}
+
+// editDistance returns the number of single letter insertions, deletions, substitutions and swaps of neighbouring
+// letters that turn a into b.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	d := make([][]int, len(ra)+1)
+	for i := range d {
+		d[i] = make([]int, len(rb)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			d[i][j] = min(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				d[i][j] = min(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(ra)][len(rb)]
+}
+
+// searcher keeps a searchIndex of the programs in a base's input, built again whenever a Reload or UpdateInput
+// replaces them.
+type searcher struct {
+	base *templatehandler.Base
+
+	mu  sync.Mutex
+	idx *searchIndex
+}
+
+func (s *searcher) search(query string) []Quittable {
+	return s.index(false).search(query)
+}
+
+// index returns the index of the base's current programs, building it again if they've changed or rebuild is set.
+func (s *searcher) index(rebuild bool) *searchIndex {
+	q := quittables(s.base)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if rebuild || s.idx == nil || !sameQuittables(s.idx.quittables, q) {
+		s.idx = newSearchIndex(q)
+	}
+	return s.idx
+}
+
+// sameQuittables reports whether a and b are the same slice, rather than equal ones, which is enough to tell when the
+// input has been replaced.
+func sameQuittables(a, b []Quittable) bool {
+	return len(a) == len(b) && (len(a) == 0 || &a[0] == &b[0])
+}
@@ -0,0 +1,428 @@
+package www
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mconbere/quitlikeapro/go/templatehandler"
+	"github.com/mconbere/quitlikeapro/go/templatehandler/funcs"
+)
+
+// The statuses of a Submission.
+const (
+	StatusPending  = "pending"
+	StatusApproved = "approved"
+	StatusRejected = "rejected"
+	// StatusExpired is a submission left pending for longer than submissionExpiry, which the submissions task drops.
+	StatusExpired = "expired"
+)
+
+// submissionExpiry is how long a submission may wait for a moderator before it's expired, so that ones nobody got to
+// don't keep /submit turned away at maxPending.
+const submissionExpiry = 90 * 24 * time.Hour
+
+// storeTimeout bounds the calls to a SubmissionStore that aren't made for a request, and so have no deadline of their
+// own.
+const storeTimeout = 10 * time.Second
+
+// maxPending is how many submissions may wait for a moderator at once. Once there are that many, /submit turns new
+// ones away until some are reviewed.
+const maxPending = 200
+
+// submitsPerHour is how many times a client may post /submit in an hour, whether or not what it sends is valid.
+const submitsPerHour = 10
+
+// maxSubmittedSteps is how many steps a submission may have.
+const maxSubmittedSteps = 10
+
+// Submission is a program proposed by a visitor on /submit. It only appears on the site once a moderator approves it.
+type Submission struct {
+	ID    string   `json:"id"`
+	Title string   `json:"title"`
+	Steps []string `json:"steps"`
+
+	Status    string    `json:"status"`
+	Submitted time.Time `json:"submitted"`
+	Reviewed  time.Time `json:"reviewed"`
+}
+
+// codeSpan matches text between backticks in a submitted step, such as `:q`, which is shown as code.
+var codeSpan = regexp.MustCompile("`([^`]+)`")
+
+// Quittable returns the submission as a program for the site. Visitors' text isn't trusted, so it's escaped, with
+// only backticks turned into code.
+func (s Submission) Quittable() Quittable {
+	q := Quittable{Title: template.HTML(template.HTMLEscapeString(s.Title)), Added: s.Reviewed}
+	for _, step := range s.Steps {
+		escaped := template.HTMLEscapeString(step)
+		q.Steps = append(q.Steps, template.HTML(codeSpan.ReplaceAllString(escaped, "<code>$1</code>")))
+	}
+	return q
+}
+
+// ErrNoSubmission is returned by a SubmissionStore for an ID it doesn't have.
+var ErrNoSubmission = errors.New("www: no such submission")
+
+// SubmissionStore keeps the site's submissions. FileSubmissions keeps them in a file, which suits a single server;
+// on App Engine, whose instances don't share a disk, use FirestoreSubmissions instead.
+type SubmissionStore interface {
+	// Add stores a new submission.
+	Add(ctx context.Context, s Submission) error
+
+	// List returns every submission, oldest first.
+	List(ctx context.Context) ([]Submission, error)
+
+	// Review sets the status of the submission with the given ID, and when it was reviewed, and returns it.
+	Review(ctx context.Context, id, status string) (Submission, error)
+}
+
+// FileSubmissions keeps submissions as a JSON list in the file at Path.
+type FileSubmissions struct {
+	Path string
+
+	mu sync.Mutex
+}
+
+var _ SubmissionStore = (*FileSubmissions)(nil)
+
+// NewFileSubmissions returns a SubmissionStore keeping submissions in the file at path, which is created when the
+// first is added.
+func NewFileSubmissions(path string) *FileSubmissions {
+	return &FileSubmissions{Path: path}
+}
+
+func (f *FileSubmissions) read() ([]Submission, error) {
+	var s []Submission
+	return s, readJSONFile(f.Path, &s)
+}
+
+func (f *FileSubmissions) write(s []Submission) error {
+	return writeJSONFile(f.Path, s)
+}
+
+// readJSONFile decodes the JSON in the file at path into v, leaving v alone if there's no such file yet.
+func readJSONFile(path string, v interface{}) error {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("could not parse %q: %v", path, err)
+	}
+	return nil
+}
+
+// writeJSONFile replaces the file at path with v as JSON, writing it alongside first so that a crash can't leave it
+// half written.
+func writeJSONFile(path string, v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (f *FileSubmissions) Add(ctx context.Context, s Submission) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	all, err := f.read()
+	if err != nil {
+		return err
+	}
+	return f.write(append(all, s))
+}
+
+func (f *FileSubmissions) List(ctx context.Context) ([]Submission, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.read()
+}
+
+func (f *FileSubmissions) Review(ctx context.Context, id, status string) (Submission, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	all, err := f.read()
+	if err != nil {
+		return Submission{}, err
+	}
+	for i := range all {
+		if all[i].ID == id {
+			all[i].Status, all[i].Reviewed = status, time.Now().UTC()
+			return all[i], f.write(all)
+		}
+	}
+	return Submission{}, ErrNoSubmission
+}
+
+// loadQuittables returns a templatehandler.Loader for the site's programs: those in content's quittablesFile, then the
+// approved submissions in store, if it's set. A submission is left out if the file has since added a program with the
+// same page.
+func loadQuittables(content fs.FS, store SubmissionStore) templatehandler.Loader {
+	return func() (interface{}, error) {
+		q, err := readQuittables(content)
+		if err != nil || store == nil {
+			return q, err
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), storeTimeout)
+		defer cancel()
+		all, err := store.List(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range all {
+			if s.Status != StatusApproved {
+				continue
+			}
+			p := s.Quittable()
+			if containsQuittable(q, p.Slug()) {
+				log.Printf("www: submission %s has the same page as a program in %s; leaving it out", s.ID, quittablesFile)
+				continue
+			}
+			q = append(q, p)
+		}
+		return q, nil
+	}
+}
+
+func containsQuittable(q []Quittable, slug string) bool {
+	for _, p := range q {
+		if p.Slug() == slug {
+			return true
+		}
+	}
+	return false
+}
+
+// submittedSteps returns the non-blank lines of the steps field, one step each.
+func submittedSteps(v string) []string {
+	var steps []string
+	for _, line := range strings.Split(v, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			steps = append(steps, line)
+		}
+	}
+	return steps
+}
+
+// submitRules are the checks on the /submit form. New programs must have a page of their own, which none of the site's
+// other routes on mux may shadow.
+func submitRules(base *templatehandler.Base, mux *http.ServeMux) templatehandler.Rules {
+	return templatehandler.Rules{
+		"title": {
+			templatehandler.Required(),
+			templatehandler.MaxLength(60),
+			func(v string) error {
+				slug := funcs.Slugify(v)
+				if slug == "" {
+					return errors.New("needs some letters or digits")
+				}
+				if _, ok := findQuittable(base, slug); ok {
+					return errors.New("is already on the site")
+				}
+				// Programs' pages are served by the router at "/", so any other pattern means the path is taken.
+				_, pattern := mux.Handler(&http.Request{Method: http.MethodGet, URL: &url.URL{Path: "/" + slug}})
+				if reservedSlugs[slug] || pattern != "/" {
+					return errors.New("is the name of one of the site's own pages")
+				}
+				return nil
+			},
+		},
+		"steps": {
+			templatehandler.Required(),
+			templatehandler.MaxLength(1000),
+			func(v string) error {
+				if len(submittedSteps(v)) > maxSubmittedSteps {
+					return fmt.Errorf("must be at most %d lines", maxSubmittedSteps)
+				}
+				return nil
+			},
+		},
+	}
+}
+
+// submit adds a valid /submit form to store as pending, and sends the visitor back to the form to say thanks. Forms
+// with the hidden "website" field filled in are from bots, which are thanked without storing anything. When
+// maxPending submissions are already waiting, the form gets a 503 instead.
+func submit(store SubmissionStore) func(w http.ResponseWriter, r *http.Request, values map[string]string) {
+	return func(w http.ResponseWriter, r *http.Request, values map[string]string) {
+		if values["website"] == "" {
+			n, err := countPending(r.Context(), store)
+			if err != nil {
+				templatehandler.DefaultErrorHandler(w, r, err)
+				return
+			}
+			if n >= maxPending {
+				log.Printf("www: turning a submission away with %d waiting for review", n)
+				w.Header().Set("Retry-After", "86400")
+				http.Error(w, "Too many suggestions are waiting to be reviewed. Please try again tomorrow.",
+					http.StatusServiceUnavailable)
+				return
+			}
+			id := make([]byte, 8)
+			if _, err := rand.Read(id); err != nil {
+				templatehandler.DefaultErrorHandler(w, r, err)
+				return
+			}
+			err = store.Add(r.Context(), Submission{
+				ID:        hex.EncodeToString(id),
+				Title:     strings.TrimSpace(values["title"]),
+				Steps:     submittedSteps(values["steps"]),
+				Status:    StatusPending,
+				Submitted: time.Now().UTC(),
+			})
+			if err != nil {
+				templatehandler.DefaultErrorHandler(w, r, err)
+				return
+			}
+		}
+		http.Redirect(w, r, "/submit?thanks=1", http.StatusSeeOther)
+	}
+}
+
+// countPending returns how many of store's submissions are waiting for review.
+func countPending(ctx context.Context, store SubmissionStore) (int, error) {
+	all, err := store.List(ctx)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, s := range all {
+		if s.Status == StatusPending {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// moderation returns the handler for the moderation endpoints under /_admin/submissions, which anyone can call, so
+// wrap it with the admin endpoints' authentication:
+//
+//	GET  /_admin/submissions               the pending submissions, as {"submissions": [...]}
+//	POST /_admin/submissions/{id}/approve  puts the submission's program on the site
+//	POST /_admin/submissions/{id}/reject   drops it
+//
+// Approving a submission adds its program to base's input, so its page and the home page show it straight away.
+func moderation(base *templatehandler.Base, store SubmissionStore) http.Handler {
+	pending := templatehandler.JSONFunc(func(r *http.Request) (interface{}, error) {
+		all, err := store.List(r.Context())
+		if err != nil {
+			return nil, err
+		}
+		s := []Submission{}
+		for _, sub := range all {
+			if sub.Status == StatusPending {
+				s = append(s, sub)
+			}
+		}
+		return map[string]interface{}{"submissions": s}, nil
+	})
+
+	review := func(status string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				w.Header().Set("Allow", http.MethodPost)
+				http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+				return
+			}
+			id := templatehandler.PathParams(r)["id"]
+			var conflict reviewConflict
+			switch err := checkReviewable(r.Context(), base, store, id, status); {
+			case errors.As(err, &conflict):
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			case err == ErrNoSubmission:
+				http.NotFound(w, r)
+				return
+			case err != nil:
+				templatehandler.DefaultErrorHandler(w, r, err)
+				return
+			}
+			s, err := store.Review(r.Context(), id, status)
+			if err == ErrNoSubmission {
+				http.NotFound(w, r)
+				return
+			}
+			if err != nil {
+				templatehandler.DefaultErrorHandler(w, r, err)
+				return
+			}
+			if status == StatusApproved {
+				err := base.UpdateInput(func(input map[string]interface{}) {
+					q, _ := input["Quittables"].([]Quittable)
+					input["Quittables"] = append(q[:len(q):len(q)], s.Quittable())
+				})
+				if err != nil {
+					templatehandler.DefaultErrorHandler(w, r, err)
+					return
+				}
+			}
+			log.Printf("www: submission %s id=%s title=%q", status, s.ID, s.Title)
+			fmt.Fprintln(w, status)
+		})
+	}
+
+	router := templatehandler.NewRouter()
+	router.Handle("/_admin/submissions", pending)
+	router.Handle("/_admin/submissions/{id}/approve", review(StatusApproved))
+	router.Handle("/_admin/submissions/{id}/reject", review(StatusRejected))
+	return router
+}
+
+// reviewConflict is why a submission can't be reviewed as asked, which the moderation endpoints answer with a 409.
+type reviewConflict string
+
+func (e reviewConflict) Error() string {
+	return string(e)
+}
+
+// checkReviewable fails with a reviewConflict if the submission with the given ID isn't pending, or if it's to be
+// approved but would have the same page as a program already on the site, and with ErrNoSubmission if there's none.
+func checkReviewable(ctx context.Context, base *templatehandler.Base, store SubmissionStore, id, status string) error {
+	all, err := store.List(ctx)
+	if err != nil {
+		return err
+	}
+	for _, s := range all {
+		if s.ID != id {
+			continue
+		}
+		if s.Status != StatusPending {
+			return reviewConflict(fmt.Sprintf("submission %s is already %s", id, s.Status))
+		}
+		if q, ok := findQuittable(base, s.Quittable().Slug()); ok && status == StatusApproved {
+			return reviewConflict(fmt.Sprintf("submission %s has the same page as %q", id, funcs.PlainText(q.Title)))
+		}
+		return nil
+	}
+	return ErrNoSubmission
+}
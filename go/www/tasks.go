@@ -0,0 +1,94 @@
+package www
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/mconbere/quitlikeapro/go/templatehandler"
+)
+
+// tasksPrefix is where the site's scheduled jobs are served.
+const tasksPrefix = "/tasks/"
+
+// tasks returns the site's scheduled jobs, run by App Engine cron or Cloud Scheduler at /tasks/{name}:
+//
+//	search       builds the search index again
+//	sitemap      generates the sitemap's program pages again; until it first runs, they're listed as it's served
+//	analytics    writes the saved and view counts kept in memory, and logs their totals
+//	submissions  expires the submissions pending for longer than submissionExpiry
+//	reload       calls reload, which re-reads the site as POST /_admin/reload does
+func tasks(c Config, reload func() error, s *searcher, sitemap *templatehandler.Sitemap) *templatehandler.Tasks {
+	t := templatehandler.NewTasks(tasksPrefix)
+	t.Add("reload", func(ctx context.Context) error {
+		return reload()
+	})
+	t.Add("search", func(ctx context.Context) error {
+		s.index(true)
+		return nil
+	})
+	t.Add("sitemap", func(ctx context.Context) error {
+		return sitemap.Refresh()
+	})
+	t.Add("analytics", func(ctx context.Context) error {
+		return rollUpCounts(ctx, c.Saved, c.Views)
+	})
+	t.Add("submissions", func(ctx context.Context) error {
+		if c.Submissions == nil {
+			return nil
+		}
+		return expireSubmissions(ctx, c.Submissions, time.Now().Add(-submissionExpiry))
+	})
+	return t
+}
+
+// flusher is a SavedCounter that keeps counts in memory until it's flushed, such as a BufferedCounter.
+type flusher interface {
+	Flush(ctx context.Context) error
+}
+
+// rollUpCounts writes the counts saved and views keep in memory, and logs the totals of each, for a log-based metric.
+// Either may be nil.
+func rollUpCounts(ctx context.Context, saved, views SavedCounter) error {
+	for _, c := range []struct {
+		name    string
+		counter SavedCounter
+	}{{"saved", saved}, {"views", views}} {
+		if c.counter == nil {
+			continue
+		}
+		if f, ok := c.counter.(flusher); ok {
+			if err := f.Flush(ctx); err != nil {
+				return err
+			}
+		}
+		counts, err := c.counter.Counts(ctx)
+		if err != nil {
+			return err
+		}
+		var total int64
+		for _, n := range counts {
+			total += n
+		}
+		log.Printf("www: counts name=%s total=%d programs=%d", c.name, total, len(counts))
+	}
+	return nil
+}
+
+// expireSubmissions marks the submissions in store that have been pending since before the given time as expired.
+func expireSubmissions(ctx context.Context, store SubmissionStore, before time.Time) error {
+	all, err := store.List(ctx)
+	if err != nil {
+		return err
+	}
+	for _, s := range all {
+		if s.Status != StatusPending || !s.Submitted.Before(before) {
+			continue
+		}
+		if _, err := store.Review(ctx, s.ID, StatusExpired); err != nil {
+			return err
+		}
+		log.Printf("www: submission expired id=%s title=%q", s.ID, s.Title)
+	}
+	return nil
+}
@@ -1,55 +1,734 @@
 package www
 
 import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
 	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"html/template"
 
 	"github.com/mconbere/quitlikeapro/go/templatehandler"
+	"github.com/mconbere/quitlikeapro/go/templatehandler/csrf"
+	"github.com/mconbere/quitlikeapro/go/templatehandler/funcs"
+	"github.com/mconbere/quitlikeapro/go/templatehandler/idtoken"
+	"github.com/mconbere/quitlikeapro/go/templatehandler/images"
+	"gopkg.in/yaml.v3"
 )
 
+// Quittable is a program on the home page, and the steps to quit it. Both are HTML.
 type Quittable struct {
-	Title template.HTML
-	Steps []template.HTML
-}
-
-var quittables = []Quittable{{
-	Title: "Emacs",
-	Steps: []template.HTML{
-		"Hold down <code>CTRL</code>",
-		"Press <code>x</code>",
-		"Press <code>c</code>",
-	},
-}, {
-	Title: "Vim",
-	Steps: []template.HTML{
-		"Type <code>:q</code>",
-		"Press <code>enter</code>",
-	},
-}, {
-	Title: "Python Interpreter",
-	Steps: []template.HTML{
-		"Type <code>CTRL</code>-<code>d</code>",
-	},
-}, {
-	Title: "Every other command line tool",
-	Steps: []template.HTML{
-		"Type <code>CTRL</code>-<code>c</code>",
-	},
-}}
-
-func New() http.Handler {
+	Title template.HTML   `yaml:"title"`
+	Steps []template.HTML `yaml:"steps"`
+
+	// Added is when the program was added to the site, if known. Only programs with it are listed in the feed.
+	Added time.Time `yaml:"added"`
+
+	// Aliases are other names the program is searched for by, such as nvim for Vim.
+	Aliases []string `yaml:"aliases"`
+
+	// Tags say what kind of program it is, e.g. editor, and Platforms what it runs on, e.g. linux. The API can filter
+	// programs by either.
+	Tags      []string `yaml:"tags"`
+	Platforms []string `yaml:"platforms"`
+
+	// Saved is how many visitors have said the steps got them out of the program, when the site counts them. It isn't
+	// part of quittablesFile.
+	Saved int64 `yaml:"-"`
+}
+
+// quittablesFile lists the home page's programs. Adding one is an edit to it, with no change to the code.
+const quittablesFile = "data/quittables.yaml"
+
+// readQuittables reads the home page's programs from quittablesFile in content. The file is part of the site, so its
+// HTML is trusted.
+func readQuittables(content fs.FS) ([]Quittable, error) {
+	b, err := fs.ReadFile(content, quittablesFile)
+	if err != nil {
+		return nil, err
+	}
+	return parseQuittables(quittablesFile, b)
+}
+
+// yamlLine matches the line number yaml gives its errors.
+var yamlLine = regexp.MustCompile(`^(?:yaml: )?line (\d+): `)
+
+// parseQuittables parses the programs in b, the contents of the file name, and checks them: every key must be one of
+// Quittable's, every program needs a title and at least one step, no two may have the same page, and tags and
+// platforms must be slugs, such as command-line. Each problem is reported as name:line: followed by what's wrong, so
+// that a broken edit to the file is quick to find.
+func parseQuittables(name string, b []byte) ([]Quittable, error) {
+	var q []Quittable
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(&q); err != nil && err != io.EOF {
+		problems := []string{err.Error()}
+		if te, ok := err.(*yaml.TypeError); ok {
+			problems = te.Errors
+		}
+		for i, p := range problems {
+			problems[i] = yamlLine.ReplaceAllString(p, name+":$1: ")
+		}
+		return nil, fmt.Errorf("could not parse %s:\n%s", name, strings.Join(problems, "\n"))
+	}
+	if len(q) == 0 {
+		return nil, fmt.Errorf("%s lists no programs", name)
+	}
+
+	// The decode succeeded, so the document is a sequence with an item for each program.
+	var doc yaml.Node
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return nil, err
+	}
+	items := doc.Content[0].Content
+
+	var problems []string
+	problem := func(i int, format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf("%s:%d: ", name, items[i].Line)+fmt.Sprintf(format, args...))
+	}
+	pages := make(map[string]int)
+	for i, p := range q {
+		if strings.TrimSpace(string(p.Title)) == "" {
+			problem(i, "program has no title")
+			continue
+		}
+		if p.Slug() == "" {
+			problem(i, "title %q has no letters or digits to make its page's path from", p.Title)
+		} else if j, ok := pages[p.Slug()]; ok {
+			problem(i, "%q has the same page, %s, as %q on line %d", p.Title, p.Path(), q[j].Title, items[j].Line)
+		} else {
+			pages[p.Slug()] = i
+		}
+		if len(p.Steps) == 0 {
+			problem(i, "%q has no steps", p.Title)
+		}
+		for _, a := range p.Aliases {
+			if funcs.Slugify(a) == "" {
+				problem(i, "%q has an alias, %q, with no letters or digits to search for", p.Title, a)
+			}
+		}
+		for n, s := range p.Steps {
+			if strings.TrimSpace(string(s)) == "" {
+				problem(i, "%q has an empty step %d", p.Title, n+1)
+			}
+		}
+		for _, list := range []struct {
+			name   string
+			values []string
+		}{{"tag", p.Tags}, {"platform", p.Platforms}} {
+			for _, v := range list.values {
+				if v == "" || v != funcs.Slugify(v) {
+					problem(i, "%q has %s %q, which should be lowercase letters, digits and dashes", p.Title, list.name, v)
+				}
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("invalid %s:\n%s", name, strings.Join(problems, "\n"))
+	}
+	return q, nil
+}
+
+// Path returns the path of the program's own page, e.g. /vim.
+func (q Quittable) Path() string {
+	return "/" + q.Slug()
+}
+
+// Slug returns the name of the program in its page's path, e.g. vim.
+func (q Quittable) Slug() string {
+	return funcs.Slugify(funcs.PlainText(q.Title))
+}
+
+// quittables returns the programs in base's current input, as loaded by loadQuittables.
+func quittables(base *templatehandler.Base) []Quittable {
+	q, _ := base.InputValue("Quittables").([]Quittable)
+	return q
+}
+
+// findQuittable returns the program in base's current input with the given slug.
+func findQuittable(base *templatehandler.Base, slug string) (Quittable, bool) {
+	for _, q := range quittables(base) {
+		if q.Slug() == slug {
+			return q, true
+		}
+	}
+	return Quittable{}, false
+}
+
+// quittableEntries returns the feed entries for the programs in base's current input that have an Added date. Each
+// links to the program's own page.
+func quittableEntries(base *templatehandler.Base) ([]templatehandler.FeedEntry, error) {
+	var entries []templatehandler.FeedEntry
+	for _, p := range quittables(base) {
+		if p.Added.IsZero() {
+			continue
+		}
+		var steps strings.Builder
+		steps.WriteString("<ol>")
+		for _, s := range p.Steps {
+			fmt.Fprintf(&steps, "<li>%s</li>", s)
+		}
+		steps.WriteString("</ol>")
+		entries = append(entries, templatehandler.FeedEntry{
+			Title:   "How to quit " + string(p.Title),
+			URL:     p.Path(),
+			Updated: p.Added,
+			Content: template.HTML(steps.String()),
+		})
+	}
+	return entries, nil
+}
+
+// AppEngineDeadline is the request deadline to use on App Engine standard. It leaves some headroom below the platform's
+// 60 second limit so that the timeout page can still be written.
+const AppEngineDeadline = 55 * time.Second
+
+// contentSecurityPolicy allows the site's own assets, jQuery from Google's CDN and Google Analytics, and inline scripts
+// and styles only when they carry the request's nonce.
+const contentSecurityPolicy = "default-src 'self'; " +
+	"script-src 'self' {nonce} https://ajax.googleapis.com https://www.googletagmanager.com; " +
+	"style-src 'self' {nonce}; " +
+	"img-src 'self' https://www.google-analytics.com https://www.googletagmanager.com; " +
+	"connect-src 'self' https://*.google-analytics.com https://*.analytics.google.com; " +
+	"object-src 'none'; base-uri 'self'"
+
+// Config controls how New builds the site.
+type Config struct {
+	// Deadline is how long a request may take. Requests that run longer have their context cancelled and are served
+	// the timeout page instead.
+	Deadline time.Duration
+
+	// LiveReload re-parses templates when they change on disk, and makes pages that use missing input fail to render.
+	// Only use it in development.
+	LiveReload bool
+
+	// Metrics, if set, receives render timings and errors for every page.
+	Metrics templatehandler.Metrics
+
+	// AccessLog, if set, is told about every page request.
+	AccessLog templatehandler.AccessLog
+
+	// ReloadToken, if set, enables the admin endpoints, when called with it as a bearer token: POST /_admin/reload,
+	// which re-reads the templates and data without restarting, and the moderation of Submissions under
+	// /_admin/submissions.
+	ReloadToken string
+
+	// IAPAudience, if set, enables the admin endpoints for the users Identity-Aware Proxy lets through, by the
+	// assertion it adds to their requests, as an alternative to ReloadToken. It's the audience of the site's
+	// assertions: on App Engine, "/projects/PROJECT_NUMBER/apps/PROJECT_ID". IAPUsers, if set, limits them to those
+	// emails; otherwise IAP's access policy says who they are.
+	IAPAudience string
+	IAPUsers    []string
+
+	// AppEngineCron lets App Engine's cron service, as told by the X-Appengine-Cron header, run the jobs under /tasks/
+	// that keep the site up to date: see tasks. Only set it on App Engine, which removes the header from requests from
+	// outside.
+	AppEngineCron bool
+
+	// TaskAudience and TaskAccounts, if set, let the service accounts TaskAccounts, such as a Cloud Scheduler job's,
+	// run the jobs under /tasks/ with a Google-signed OIDC token issued for TaskAudience, as an alternative to
+	// AppEngineCron, and enable /events/pubsub for Pub/Sub push subscriptions using the same: see events.
+	TaskAudience string
+	TaskAccounts []string
+
+	// Saved, if set, counts the visitors each program's steps have saved, which its page shows and the home page lists
+	// the programs by, most saved first. Visitors add to it with the button on each program.
+	Saved SavedCounter
+
+	// Views, if set, counts the views of each program's page, by slug, for analytics. Every GET of a page adds to it
+	// while the page is served, so give it a BufferedCounter, which writes in the background.
+	Views SavedCounter
+
+	// Submissions, if set, enables /submit, where visitors propose programs for the site, and keeps what they send
+	// until a moderator approves or rejects it.
+	Submissions SubmissionStore
+
+	// Environment is the deployment the site is running in. Analytics are only sent from prod, and other environments
+	// show a banner saying which they are.
+	Environment templatehandler.Environment
+
+	// Cache, if set, is a Store shared by every instance of the site, such as a memcache.Client, in which rendered pages
+	// and API responses are kept, so that each is rendered once between the instances rather than once by each. All of
+	// it is dropped whenever the data is reloaded. It isn't used while live reloading.
+	Cache templatehandler.Store
+
+	// CDNPurger, if set, purges the CDN in front of the site, such as a templatehandler.FastlyPurger, which is then
+	// told to keep pages for longer than browsers may: see cdnRoutes. It's purged whenever the data changes.
+	CDNPurger templatehandler.Purger
+
+	// Content, if set, is where the templates/, content/ and data/ directories and site.yaml are read from, instead of
+	// the working directory, such as a gcs.Bucket, so that they can be changed without a deploy. If it's a
+	// templatehandler.Refresher, POST /_admin/reload refreshes it first. static/ and redirects.yaml are always read
+	// from the working directory.
+	Content fs.FS
+}
+
+// Site is the site's handler, as New returns it.
+type Site struct {
+	http.Handler
+
+	base      *templatehandler.Base
+	sitemap   *templatehandler.Sitemap
+	content   fs.FS
+	private   func(r *http.Request) bool
+	redirects *templatehandler.Redirects
+	wellKnown atomic.Pointer[wellKnown]
+
+	// afterReload is called once a Reload has replaced the base's data.
+	afterReload func() error
+}
+
+var _ templatehandler.Reloader = (*Site)(nil)
+
+// Reload re-reads the templates, data and site.yaml, as Base.Reload does, and redirects.yaml and the robots.txt and
+// security.txt site.yaml configures, and swaps each in for the requests that arrive from then on. redirects.yaml is
+// read first, so that a broken one changes nothing; if site.yaml's well-known files can't be read once the base has
+// reloaded, the old ones are kept.
+func (s *Site) Reload() error {
+	redirects, err := templatehandler.LoadRedirects("redirects.yaml")
+	if err != nil {
+		return err
+	}
+	if err := s.base.Reload(); err != nil {
+		return err
+	}
+	s.redirects.Set(redirects)
+	wk, err := readWellKnown(s.content, s.private)
+	if err != nil {
+		return err
+	}
+	s.wellKnown.Store(wk)
+	return s.afterReload()
+}
+
+// Pages returns the paths of the site's pages, as listed in its sitemap: the home page, the pages under content/ and
+// each program's.
+func (s *Site) Pages() ([]string, error) {
+	entries, err := s.sitemap.Entries()
+	if err != nil {
+		return nil, err
+	}
+	paths := make([]string, len(entries))
+	for i, e := range entries {
+		paths[i] = e.Path
+	}
+	return paths, nil
+}
+
+// wellKnown are the robots.txt and security.txt site.yaml configures.
+type wellKnown struct {
+	Robots   templatehandler.Robots      `yaml:"Robots"`
+	Security templatehandler.SecurityTxt `yaml:"Security"`
+}
+
+// readWellKnown reads the wellKnown files from site.yaml in content. The robots.txt disallows everything for the
+// requests private reports.
+func readWellKnown(content fs.FS, private func(r *http.Request) bool) (*wellKnown, error) {
+	var wk wellKnown
+	if err := templatehandler.ReadDataFS(content, "site.yaml", &wk); err != nil {
+		return nil, err
+	}
+	if wk.Robots.Sitemap == "" {
+		wk.Robots.Sitemap = "/sitemap.xml"
+	}
+	wk.Robots.Private = private
+	return &wk, nil
+}
+
+// reservedSlugs are the names no program's page may take, besides the paths New registers on its mux, which submitRules
+// looks up there: the routes App Engine and cmd/www serve, those New only registers below a prefix such as AMP pages',
+// and the names of its files such as feed.xml, which a page's slug would be confusingly close to.
+var reservedSlugs = map[string]bool{
+	"_ah":         true,
+	"_admin":      true,
+	".well-known": true,
+	"amp":         true,
+	"api":         true,
+	"events":      true,
+	"feed":        true,
+	"images":      true,
+	"readyz":      true,
+	"robots":      true,
+	"search":      true,
+	"sitemap":     true,
+	"static":      true,
+	"submit":      true,
+	"tasks":       true,
+}
+
+// New returns the site's handler.
+func New(c Config) *Site {
 	mux := http.NewServeMux()
+	content := c.Content
+	if content == nil {
+		content = os.DirFS(".")
+	}
+
+	// Screenshots and other images under static/ are served resized for smaller screens with {{ img }}.
+	imgs := images.New(os.DirFS("static"), "/static/", "/images/")
+	mux.Handle(imgs.URL, imgs)
+
+	// App Engine serves static/ itself, but {{ asset }} fingerprints the URLs pages link to there, so that browsers
+	// fetch a file again as soon as it changes.
+	assets := templatehandler.Assets(os.DirFS("static"), "/static/")
+	var preload []string
+	for _, name := range []string{"css/bootstrap.min.css", "css/main.css"} {
+		u, err := assets.URL(name)
+		if err != nil {
+			panic(err)
+		}
+		preload = append(preload, u)
+	}
+
+	// The saved counts are fetched at most once a minute for the pages that show them.
+	var counts *savedCounts
+	if c.Saved != nil {
+		counts = &savedCounts{counter: c.Saved}
+	}
+
+	// What's kept in the shared cache is versioned per deployment, as each renders its own templates, and the version
+	// is bumped whenever the base's data changes.
+	var shared *templatehandler.VersionedStore
+	if c.Cache != nil && !c.LiveReload {
+		shared = templatehandler.NewVersionedStore(c.Cache, "quitlikeapro:version:"+c.Environment.Version)
+	}
+
+	var cdn *templatehandler.CDN
+	if c.CDNPurger != nil {
+		cdn = &templatehandler.CDN{Routes: cdnRoutes(counts != nil), Purger: c.CDNPurger}
+	}
+
+	base, err := templatehandler.NewBaseFS(content, "templates/base.html",
+		templatehandler.WithSite("site.yaml"),
+		templatehandler.WithEnv("GA_ID"),
+		templatehandler.WithEnvironment(c.Environment),
+		templatehandler.WithData("Quittables", loadQuittables(content, c.Submissions)),
+		templatehandler.WithInput(map[string]interface{}{
+			templatehandler.PreloadKey: preload,
+		}),
+		templatehandler.WithPartialsDir("templates/partials"),
+		templatehandler.WithMarkdown(templatehandler.MarkdownOptions{TOC: true}),
+		templatehandler.WithLiveReload(c.LiveReload),
+		templatehandler.WithStrict(c.LiveReload),
+		templatehandler.WithFuncs(imgs.Funcs()),
+		templatehandler.WithFuncs(assets.Funcs()),
+		templatehandler.WithFuncs(template.FuncMap{
+			// savePath is where a program's "this saved me" button posts to, or empty if the site doesn't count them.
+			"savePath": func(q Quittable) string {
+				if counts == nil {
+					return ""
+				}
+				return savePath(q)
+			},
+		}),
+		templatehandler.WithMetrics(c.Metrics),
+		templatehandler.WithAccessLog(c.AccessLog),
+		templatehandler.WithSharedCache(shared),
+		templatehandler.WithCDN(cdn),
+	)
+	if err != nil {
+		panic(err)
+	}
+	base.ErrorHandler = templatehandler.ErrorPage(templatehandler.Must(templatehandler.NewFS(base, content, "templates/error.html")))
+
+	notFound, err := templatehandler.NotFoundFS(base, content, "templates/404.html")
+	if err != nil {
+		panic(err)
+	}
+	base.NotFound = notFound
 
-	base, err := templatehandler.NewBase("templates/base.html", map[string]interface{}{
-		"Quittables": quittables,
+	sitemap := templatehandler.NewSitemap()
+	base.Sitemap = sitemap
+	base.API = true
+	if err := base.MarkdownDir(mux, "content", "templates/layouts/markdown.html"); err != nil {
+		panic(err)
+	}
+	index := templatehandler.Must(templatehandler.NewFS(base, content, "templates/index.html"))
+	index.ServeJSON = true
+	indexPage := index.Static(nil)
+	if counts != nil {
+		// The most saved programs come first, so the page is rendered again with the latest counts once they're stale.
+		indexPage.TTL = savedCountsTTL
+		indexPage.Cache = &savedCountsCache
+		indexPage.Load = func(ctx context.Context) (map[string]interface{}, error) {
+			ctx, cancel := context.WithTimeout(ctx, savedCountsTimeout)
+			defer cancel()
+			return map[string]interface{}{"Quittables": popular(quittables(base), counts.get(ctx))}, nil
+		}
+	}
+	if shared != nil {
+		indexPage.Store = shared
+	}
+	if err := indexPage.PreRender(); err != nil {
+		panic(err)
+	}
+	// site returns the value of key in site.yaml as of the latest Reload, so that a change shows without a restart.
+	site := func(key string) interface{} {
+		values, _ := base.InputValue(templatehandler.SiteKey).(map[string]interface{})
+		return values[key]
+	}
+
+	// Each program has a page of its own, such as /vim, so that `curl quitlikea.pro/vim` prints just its steps. They're
+	// rendered from the base's data, so a Reload adds and removes them.
+	quittable := templatehandler.Must(templatehandler.NewFS(base, content, "templates/quittable.html")).Dynamic(
+		func(w http.ResponseWriter, r *http.Request) map[string]interface{} {
+			q, _ := findQuittable(base, templatehandler.PathParams(r)["slug"])
+			q.Saved = counts.get(r.Context())[q.Slug()]
+			return map[string]interface{}{
+				"Title":       fmt.Sprintf("How to quit %s - %v", funcs.PlainText(q.Title), site("Title")),
+				"Description": fmt.Sprintf("How to quit %s like a pro", funcs.PlainText(q.Title)),
+				"Quittable":   q,
+			}
+		})
+	quittable.Methods = []string{http.MethodGet}
+	quittable.Cache = &templatehandler.CachePolicy{MaxAge: time.Hour}
+	if counts != nil {
+		quittable.Cache = &savedCountsCache
+	}
+	quittable.OutputCache = outputCache(shared, counts, time.Hour)
+	router := templatehandler.NewRouter()
+	router.NotFound = notFound
+	router.HandleFunc("/{slug}", func(w http.ResponseWriter, r *http.Request) {
+		q, ok := findQuittable(base, templatehandler.PathParams(r)["slug"])
+		if !ok {
+			notFound.ServeHTTP(w, r)
+			return
+		}
+		// Views are counted here rather than in the page's input func, which isn't called for pages from its cache.
+		if c.Views != nil && r.Method == http.MethodGet {
+			if _, err := c.Views.Save(r.Context(), q.Slug()); err != nil {
+				log.Printf("www: could not count view of %q: %v", q.Slug(), err)
+			}
+		}
+		quittable.ServeHTTP(w, r)
+	})
+	// Search results are found per request, from an index that's built again when the programs change.
+	s := &searcher{base: base}
+	searchPage := templatehandler.Must(templatehandler.NewFS(base, content, "templates/search.html"))
+	searchPage.ServeJSON = true
+	search := searchPage.Dynamic(
+		func(w http.ResponseWriter, r *http.Request) map[string]interface{} {
+			q := strings.TrimSpace(r.URL.Query().Get("q"))
+			title := fmt.Sprintf("Search - %v", site("Title"))
+			if q != "" {
+				title = fmt.Sprintf("%s - Search - %v", q, site("Title"))
+			}
+			return map[string]interface{}{
+				"Title":       title,
+				"Description": "Find out how to quit any program",
+				"Query":       q,
+				"Results":     withSaved(s.search(q), counts.get(r.Context())),
+			}
+		})
+	search.Methods = []string{http.MethodGet}
+	search.Cache = &templatehandler.CachePolicy{MaxAge: 5 * time.Minute}
+	if counts != nil {
+		search.Cache = &savedCountsCache
+	}
+	search.OutputCache = outputCache(shared, counts, 5*time.Minute, "q")
+	mux.Handle("/search", search)
+
+	st := &Site{base: base, sitemap: sitemap, content: content}
+	st.afterReload = func() error {
+		s.index(true)
+		return sitemap.Refresh()
+	}
+	jobs := tasks(c, st.Reload, s, sitemap)
+	var oidc templatehandler.Middleware
+	if c.TaskAudience != "" {
+		oidc = idtoken.Bearer(idtoken.NewGoogle(c.TaskAudience), c.TaskAccounts...)
+		mux.Handle(eventsPath, templatehandler.Chain(events(jobs), oidc))
+	}
+	if c.AppEngineCron || oidc != nil {
+		auth := oidc
+		if c.AppEngineCron {
+			auth = templatehandler.AppEngineCron(oidc)
+		}
+		mux.Handle(tasksPrefix, templatehandler.Chain(jobs, auth))
+	}
+
+	sitemap.AddFunc(func() ([]templatehandler.SitemapEntry, error) {
+		var entries []templatehandler.SitemapEntry
+		for _, q := range quittables(base) {
+			entries = append(entries, templatehandler.SitemapEntry{Path: q.Path(), ChangeFreq: "monthly"})
+		}
+		return entries, nil
+	})
+
+	sitemap.Handle(mux, "/", templatehandler.Exact("/", indexPage, router), templatehandler.SitemapEntry{
+		ChangeFreq: "weekly",
+		Priority:   1,
+	})
+	mux.Handle(templatehandler.APIPrefix, templatehandler.Exact(templatehandler.APIPrefix, index.JSON(), notFound))
+	mux.Handle(APIPrefix, api(base, counts, shared))
+	mux.Handle("/sitemap.xml", sitemap)
+	mux.Handle("/_ah/warmup", warmup(mux, st, s))
+	feed := func() ([]templatehandler.FeedEntry, error) { return quittableEntries(base) }
+	mux.HandleFunc("/feed.xml", func(w http.ResponseWriter, r *http.Request) {
+		templatehandler.Feed(fmt.Sprint(site("Title")), fmt.Sprint(site("Author")), feed).ServeHTTP(w, r)
 	})
+	// Keep development servers and App Engine's per-version addresses, such as beta's, out of search results.
+	st.private = func(r *http.Request) bool { return c.LiveReload || templatehandler.IsVersionHost(r) }
+	wk, err := readWellKnown(content, st.private)
 	if err != nil {
 		panic(err)
 	}
+	st.wellKnown.Store(wk)
+	mux.HandleFunc("/robots.txt", func(w http.ResponseWriter, r *http.Request) {
+		st.wellKnown.Load().Robots.ServeHTTP(w, r)
+	})
+	mux.HandleFunc("/.well-known/security.txt", func(w http.ResponseWriter, r *http.Request) {
+		st.wellKnown.Load().Security.ServeHTTP(w, r)
+	})
+	// The admin endpoints take the reload token, or an Identity-Aware Proxy assertion if IAP is in front of the site.
+	var admin templatehandler.Middleware
+	if c.ReloadToken != "" {
+		admin = templatehandler.BearerToken(c.ReloadToken)
+	}
+	if c.IAPAudience != "" {
+		admin = idtoken.IAP(idtoken.NewIAP(c.IAPAudience), admin, c.IAPUsers...)
+	}
+	if admin != nil {
+		mux.Handle("/_admin/reload", templatehandler.Chain(templatehandler.ReloadEndpoint(st), admin))
+	}
+	if c.Submissions != nil {
+		submitPage := templatehandler.Must(templatehandler.NewFS(base, content, "templates/submit.html"))
+		// Posts from other sites' pages are refused, so that a visitor can't be made to submit without knowing.
+		mux.Handle("/submit", submitPage.Form(submitRules(base, mux), submit(c.Submissions)).With(
+			csrf.SameOrigin,
+			templatehandler.RateLimit(submitsPerHour, time.Hour, postingClient),
+		))
+		if admin != nil {
+			moderate := templatehandler.Chain(moderation(base, c.Submissions), admin)
+			mux.Handle("/_admin/submissions", moderate)
+			mux.Handle("/_admin/submissions/", moderate)
+		}
+	}
+
+	redirects, err := templatehandler.LoadRedirects("redirects.yaml")
+	if err != nil {
+		panic(err)
+	}
+	st.redirects = templatehandler.NewRedirects(redirects)
+
+	req, err := http.NewRequest("GET", "/", nil)
+	if err != nil {
+		panic(err)
+	}
+	var timeout bufferWriter
+	templatehandler.Must(templatehandler.NewFS(base, content, "templates/timeout.html")).Static(nil).ServeHTTP(&timeout, req)
+
+	// http.TimeoutHandler passes panics on to the server, so recover them inside it.
+	middleware := []templatehandler.Middleware{
+		templatehandler.CSP(contentSecurityPolicy),
+		func(h http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				baseURL, _ := site("BaseURL").(string)
+				templatehandler.CanonicalLink(baseURL)(h).ServeHTTP(w, r)
+			})
+		},
+		templatehandler.Recover(base.ErrorHandler, nil),
+	}
+	if cdn != nil {
+		middleware = append(middleware, cdn.Middleware)
+	}
+	h := templatehandler.Chain(st.redirects.Middleware(templatehandler.Canonical(mux)), middleware...)
+	st.Handler = http.TimeoutHandler(h, c.Deadline, timeout.String())
+	return st
+}
+
+// cdnPageTTL is how long a CDN keeps pages that don't show saved counts. It's purged whenever the data changes, so it
+// can keep them much longer than browsers, which can't be.
+const cdnPageTTL = 24 * time.Hour
 
-	mux.Handle("/about", templatehandler.Must(templatehandler.New(base, "templates/about/index.html")).Static(nil))
-	mux.Handle("/", templatehandler.Must(templatehandler.New(base, "templates/index.html")).Static(nil))
+// cdnRoutes are how long a CDN keeps each part of the site, and the surrogate keys it's tagged with. If counts is set,
+// pages and API responses show saved counts, and are kept no longer than an instance keeps those.
+func cdnRoutes(counts bool) []templatehandler.CDNRoute {
+	pages, api := cdnPageTTL, 5*time.Minute
+	if counts {
+		pages, api = savedCountsTTL, savedCountsTTL
+	}
+	return []templatehandler.CDNRoute{
+		{Prefix: "/", SMaxAge: pages, Keys: []string{"pages"}},
+		{Prefix: "/search", SMaxAge: min(pages, 5*time.Minute), Keys: []string{"pages"}},
+		{Prefix: APIPrefix, SMaxAge: api, Keys: []string{"api"}},
+		{Prefix: "/images/", SMaxAge: 30 * 24 * time.Hour, Keys: []string{"images"}},
+		// The feeds don't show counts.
+		{Prefix: "/feed.xml", SMaxAge: cdnPageTTL, Keys: []string{"feeds"}},
+		{Prefix: "/sitemap.xml", SMaxAge: cdnPageTTL, Keys: []string{"feeds"}},
+	}
+}
+
+// outputCache returns an OutputCache keeping pages in shared for ttl, or for savedCountsTTL if they show counts, per
+// each of the query parameters query. It's nil, caching nothing, if there's no shared cache.
+func outputCache(shared *templatehandler.VersionedStore, counts *savedCounts, ttl time.Duration, query ...string) *templatehandler.OutputCache {
+	if shared == nil {
+		return nil
+	}
+	if counts != nil {
+		ttl = savedCountsTTL
+	}
+	return &templatehandler.OutputCache{Store: shared, TTL: ttl, Query: query}
+}
 
-	return mux
+// warmup builds the search index and renders each of the site's pages on h once, so that static pages are cached, and
+// those with an OutputCache kept in the shared cache, before App Engine sends the instance its first real request. A
+// page that fails to render fails the warmup request with a 500.
+func warmup(h http.Handler, site *Site, s *searcher) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths, err := site.Pages()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		s.index(false)
+		for _, p := range paths {
+			if err := prerender(h, r, p); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+	})
 }
+
+func prerender(h http.Handler, r *http.Request, path string) (err error) {
+	req, err := http.NewRequest("GET", path, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if v := recover(); v != nil {
+			err = fmt.Errorf("could not render %q: %v", path, v)
+		}
+	}()
+	var b bufferWriter
+	h.ServeHTTP(&b, req.WithContext(r.Context()))
+	if b.code >= 500 {
+		return fmt.Errorf("could not render %q: status %d", path, b.code)
+	}
+	return nil
+}
+
+// bufferWriter is an http.ResponseWriter that collects the response status and body in memory.
+type bufferWriter struct {
+	bytes.Buffer
+	header http.Header
+	code   int
+}
+
+func (b *bufferWriter) Header() http.Header {
+	if b.header == nil {
+		b.header = make(http.Header)
+	}
+	return b.header
+}
+
+func (b *bufferWriter) WriteHeader(code int) { b.code = code }
@@ -0,0 +1,161 @@
+package www
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestSite returns the site built from appengine/ with c.
+func newTestSite(t *testing.T, c Config) *Site {
+	t.Helper()
+	t.Chdir("appengine")
+	if c.Deadline == 0 {
+		c.Deadline = 10 * time.Second
+	}
+	return New(c)
+}
+
+// postForm posts values to path on h as a browser would from a page at origin.
+func postForm(h http.Handler, path, origin string, values url.Values) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("POST", path, strings.NewReader(values.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("Origin", origin)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestSubmitRefusesCrossOrigin(t *testing.T) {
+	store := NewFileSubmissions(filepath.Join(t.TempDir(), "submissions.json"))
+	h := newTestSite(t, Config{Submissions: store})
+	form := url.Values{"title": {"Frobnicator"}, "steps": {"Press q"}}
+
+	if w := postForm(h, "/submit", "https://evil.example", form); w.Code != http.StatusForbidden {
+		t.Errorf("cross-origin POST /submit = %d, want %d", w.Code, http.StatusForbidden)
+	}
+	if all, err := store.List(context.Background()); err != nil || len(all) != 0 {
+		t.Fatalf("after a cross-origin POST, store has %v, %v; want nothing", all, err)
+	}
+
+	if w := postForm(h, "/submit", "http://example.com", form); w.Code != http.StatusSeeOther {
+		t.Errorf("same-origin POST /submit = %d, want %d", w.Code, http.StatusSeeOther)
+	}
+	if all, err := store.List(context.Background()); err != nil || len(all) != 1 {
+		t.Errorf("after a same-origin POST, store has %v, %v; want one submission", all, err)
+	}
+}
+
+func TestSubmitRefusesSiteRoutes(t *testing.T) {
+	store := NewFileSubmissions(filepath.Join(t.TempDir(), "submissions.json"))
+	h := newTestSite(t, Config{Submissions: store})
+
+	// The pages New registers, a content page, a route cmd/www serves and one of a file's.
+	for _, title := range []string{"Search", "About", "Readyz", "Sitemap"} {
+		form := url.Values{"title": {title}, "steps": {"Press q"}}
+		if w := postForm(h, "/submit", "http://example.com", form); w.Code != http.StatusUnprocessableEntity {
+			t.Errorf("POST /submit titled %q = %d, want %d", title, w.Code, http.StatusUnprocessableEntity)
+		}
+	}
+	if all, err := store.List(context.Background()); err != nil || len(all) != 0 {
+		t.Errorf("after submitting the site's own pages, store has %v, %v; want nothing", all, err)
+	}
+}
+
+func TestReviewSubmission(t *testing.T) {
+	store := NewFileSubmissions(filepath.Join(t.TempDir(), "submissions.json"))
+	for _, s := range []Submission{
+		{ID: "pending", Title: "Frobnicator", Steps: []string{"Press q"}, Status: StatusPending, Submitted: time.Now()},
+		{ID: "rejected", Title: "Spam", Steps: []string{"Buy"}, Status: StatusRejected, Submitted: time.Now()},
+	} {
+		if err := store.Add(context.Background(), s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h := newTestSite(t, Config{Submissions: store, ReloadToken: "secret"})
+
+	for _, tt := range []struct {
+		path string
+		code int
+	}{
+		{"/_admin/submissions/missing/approve", http.StatusNotFound},
+		{"/_admin/submissions/rejected/approve", http.StatusConflict},
+		{"/_admin/submissions/pending/approve", http.StatusOK},
+		{"/_admin/submissions/pending/reject", http.StatusConflict},
+	} {
+		r := httptest.NewRequest("POST", tt.path, nil)
+		r.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tt.code {
+			t.Errorf("POST %s = %d, want %d", tt.path, w.Code, tt.code)
+		}
+	}
+}
+
+func TestTasksExpireSubmissions(t *testing.T) {
+	store := NewFileSubmissions(filepath.Join(t.TempDir(), "submissions.json"))
+	for _, s := range []Submission{
+		{ID: "old", Title: "Old", Status: StatusPending, Submitted: time.Now().Add(-submissionExpiry - time.Hour)},
+		{ID: "new", Title: "New", Status: StatusPending, Submitted: time.Now()},
+	} {
+		if err := store.Add(context.Background(), s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h := newTestSite(t, Config{Submissions: store, AppEngineCron: true})
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/tasks/submissions", nil))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("GET /tasks/submissions without X-Appengine-Cron = %d, want %d", w.Code, http.StatusForbidden)
+	}
+
+	r := httptest.NewRequest("GET", "/tasks/submissions", nil)
+	r.Header.Set("X-Appengine-Cron", "true")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /tasks/submissions from cron = %d %q, want %d", w.Code, w.Body, http.StatusOK)
+	}
+	all, err := store.List(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"old": StatusExpired, "new": StatusPending}
+	for _, s := range all {
+		if s.Status != want[s.ID] {
+			t.Errorf("submission %s is %s, want %s", s.ID, s.Status, want[s.ID])
+		}
+	}
+}
+
+// TestWarmupPages checks that warmup renders the pages the sitemap lists, programs' pages included, rather than a list
+// of its own.
+func TestWarmupPages(t *testing.T) {
+	h := newTestSite(t, Config{})
+	pages, err := h.Pages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"/", "/about", "/vim"} {
+		found := false
+		for _, p := range pages {
+			found = found || p == want
+		}
+		if !found {
+			t.Errorf("Pages() = %q, missing %q", pages, want)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/_ah/warmup", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("GET /_ah/warmup = %d %q, want %d", w.Code, w.Body, http.StatusOK)
+	}
+}